@@ -1,7 +1,16 @@
 package cgroups
 
 //import "./subsystems"
-import "github.com/qqzeng/tinydocker/cgroups/subsystems"
+import (
+	"bufio"
+	"fmt"
+	"github.com/qqzeng/tinydocker/cgroups/subsystems"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
 
 type CgroupManager struct {
 	Path string
@@ -37,4 +46,101 @@ func (cm *CgroupManager) Destory() error {
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+/*
+	freeze processes sharing the cgroup of given process, e.g. to commit a consistent root
+	filesystem. Processes of containers started before freezer was managed are moved in first.
+*/
+func (cm *CgroupManager) Freeze(pid string) error {
+	pids, err := GetProcesses(pid)
+	if err != nil {
+		return err
+	}
+	freezer := &subsystems.FreezerSubsystem{}
+	for _, p := range pids {
+		if err := freezer.Apply(cm.Path, p); err != nil {
+			return err
+		}
+	}
+	return freezer.SetState(cm.Path, subsystems.FreezerFrozen)
+}
+
+func (cm *CgroupManager) Thaw() error {
+	return (&subsystems.FreezerSubsystem{}).SetState(cm.Path, subsystems.FreezerThawed)
+}
+
+/* list pids of every process sharing the cgroup of given process. */
+func GetProcesses(pid string) ([]int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%s/cgroup", pid))
+	if err != nil {
+		return nil, fmt.Errorf("open cgroup file of process %s error : %v", pid, err)
+	}
+	defer f.Close()
+	/* prefer the hierarchies managed by us, fall back to the unified one. */
+	var procsFile, unifiedProcsFile string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && procsFile == "" {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[1] == "" {
+			unifiedProcsFile = path.Join(subsystems.FindCgroup2MountPoint(), fields[2], "cgroup.procs")
+			continue
+		}
+		/* a container started before a subsystem was managed is left in its root cgroup. */
+		if fields[2] == "/" {
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			for _, subsystemIns := range subsystems.SubsystemInstances {
+				if controller == subsystemIns.Name() {
+					procsFile = path.Join(subsystems.FindCgroupMountPoint(controller), fields[2], "cgroup.procs")
+				}
+			}
+		}
+	}
+	if procsFile != "" {
+		return readProcs(procsFile)
+	}
+	if unifiedProcsFile == "" {
+		return nil, fmt.Errorf("fail to find cgroup of process %s", pid)
+	}
+	/*
+		containers are never placed in a cgroup of their own on the unified hierarchy, whose cgroup
+		is the one of the process running the container, e.g. a session of user. Only processes in
+		the pid namespace of container are taken from it.
+	*/
+	pidNs, err := os.Readlink(fmt.Sprintf("/proc/%s/ns/pid", pid))
+	if err != nil {
+		return nil, fmt.Errorf("read pid namespace of process %s error : %v", pid, err)
+	}
+	if selfNs, err := os.Readlink("/proc/self/ns/pid"); err != nil || selfNs == pidNs {
+		return nil, fmt.Errorf("process %s has neither a cgroup nor a pid namespace of its own", pid)
+	}
+	candidates, err := readProcs(unifiedProcsFile)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, p := range candidates {
+		if ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", p)); err == nil && ns == pidNs {
+			pids = append(pids, p)
+		}
+	}
+	return pids, nil
+}
+
+func readProcs(procsFile string) ([]int, error) {
+	content, err := ioutil.ReadFile(procsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read cgroup processes %s error : %v", procsFile, err)
+	}
+	var pids []int
+	for _, line := range strings.Fields(string(content)) {
+		if p, err := strconv.Atoi(line); err == nil {
+			pids = append(pids, p)
+		}
+	}
+	return pids, nil
+}
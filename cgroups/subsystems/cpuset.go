@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type CpusetSubsystem struct {
@@ -13,13 +14,16 @@ type CpusetSubsystem struct {
 }
 
 func (css *CpusetSubsystem) Name() string {
-	return "cpusset"
+	return "cpuset"
 }
 
 func (css *CpusetSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsystemCgroupPath, err := GetCgroupPath(css.Name(), cgroupPath, true); err != nil {
 		return err
 	} else {
+		if err := css.inheritParent(cgroupPath); err != nil {
+			return err
+		}
 		if res.CpuSet != "" {
 			if err := ioutil.WriteFile(path.Join(subsystemCgroupPath, "cpuset.cpus"),
 				[]byte(res.CpuSet), 0644); err != nil {
@@ -49,3 +53,28 @@ func (css *CpusetSubsystem) Remove(cgroupPath string) error {
 		return os.RemoveAll(subsystemCgroupPath)
 	}
 }
+
+/* a new cpuset cgroup accepts no task until its cpus and mems are set, inherit them from ancestors. */
+func (css *CpusetSubsystem) inheritParent(cgroupPath string) error {
+	current := FindCgroupMountPoint(css.Name())
+	for _, dir := range strings.Split(strings.Trim(cgroupPath, "/"), "/") {
+		parent := current
+		current = path.Join(current, dir)
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			content, err := ioutil.ReadFile(path.Join(current, file))
+			if err != nil {
+				return fmt.Errorf ("read %s of cgroup %s fail %v", file, current, err)
+			}
+			if strings.TrimSpace(string(content)) != "" {
+				continue
+			}
+			if content, err = ioutil.ReadFile(path.Join(parent, file)); err != nil {
+				return fmt.Errorf ("read %s of cgroup %s fail %v", file, parent, err)
+			}
+			if err := ioutil.WriteFile(path.Join(current, file), content, 0644); err != nil {
+				return fmt.Errorf ("inherit %s of cgroup %s fail %v", file, current, err)
+			}
+		}
+	}
+	return nil
+}
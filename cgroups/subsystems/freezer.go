@@ -0,0 +1,78 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FreezerFrozen = "FROZEN"
+	FreezerThawed = "THAWED"
+	/* the longest time to wait for processes of cgroup to freeze. */
+	freezeTimeout = 10 * time.Second
+)
+
+/* freezer suspends processes of container, it has no resource to set. */
+type FreezerSubsystem struct {
+}
+
+func (fs *FreezerSubsystem) Name() string {
+	return "freezer"
+}
+
+func (fs *FreezerSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
+	_, err := GetCgroupPath(fs.Name(), cgroupPath, true)
+	return err
+}
+
+func (fs *FreezerSubsystem) Apply(cgroupPath string, pid int) error {
+	if subsystemCgroupPath, err := GetCgroupPath(fs.Name(), cgroupPath, true); err != nil {
+		return fmt.Errorf("get cgroup %v error: %v", cgroupPath, err)
+	} else {
+		if err := ioutil.WriteFile(path.Join(subsystemCgroupPath, "tasks"),
+			[]byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("apply cgroup proc fail %v", err)
+		}
+		return nil
+	}
+}
+
+func (fs *FreezerSubsystem) Remove(cgroupPath string) error {
+	if subsystemCgroupPath, err := GetCgroupPath(fs.Name(), cgroupPath, false); err != nil {
+		return fmt.Errorf("get cgroup %v error: %v", cgroupPath, err)
+	} else {
+		return os.RemoveAll(subsystemCgroupPath)
+	}
+}
+
+/* freeze or thaw processes of cgroup, and wait until all of them are in that state. */
+func (fs *FreezerSubsystem) SetState(cgroupPath string, state string) error {
+	subsystemCgroupPath, err := GetCgroupPath(fs.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %v error: %v", cgroupPath, err)
+	}
+	stateFile := path.Join(subsystemCgroupPath, "freezer.state")
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		/* a process forking while freezing may be missed, so state is written until it sticks. */
+		if err := ioutil.WriteFile(stateFile, []byte(state), 0644); err != nil {
+			return fmt.Errorf("set freezer state %s error : %v", state, err)
+		}
+		current, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			return fmt.Errorf("read freezer state error : %v", err)
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("processes of cgroup %s are still %s", cgroupPath, strings.TrimSpace(string(current)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,9 +1,9 @@
 package subsystems
 
 type ResourceConfig struct {
-	MemoryLimit string
-	CpuShare string
-	CpuSet string
+	MemoryLimit string `json:"memoryLimit"`
+	CpuShare string `json:"cpuShare"`
+	CpuSet string `json:"cpuSet"`
 }
 
 type Subsystem interface {
@@ -17,6 +17,7 @@ var (
 	SubsystemInstances = []Subsystem {
 		&CpusetSubsystem{},
 		&MemorySubsystem{},
+		&FreezerSubsystem{},
 		//&CpuSubsystem{}
 	}
 )
\ No newline at end of file
@@ -31,11 +31,33 @@ func FindCgroupMountPoint(subsystem string) string {
 	return ""
 }
 
+/* the mount point of cgroup v2 unified hierarchy, whose mount source type is cgroup2. */
+func FindCgroup2MountPoint() string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		for i, field := range fields {
+			if field == "-" && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+				return fields[4]
+			}
+		}
+	}
+	return ""
+}
+
 func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountPoint(subsystem)
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("cgroup subsystem %s is not mounted", subsystem)
+	}
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err2 := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err2 != nil {
+			if err2 := os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755); err2 != nil {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
 		}
@@ -1,19 +1,156 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/cgroups"
 	"github.com/qqzeng/tinydocker/container"
-	"os/exec"
+	"github.com/qqzeng/tinydocker/image"
+	"github.com/qqzeng/tinydocker/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
 )
 
-func commitContainer(containerName string, imageName string) {
-	mntUrl  :=  fmt.Sprintf (container.MntUrl,  containerName)
-	mntUrl += "/"
-	imageTar := container.RootUrl  + "/" +  imageName  + ".tar"
-	log.Infof("commit image %s", imageTar)
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntUrl, ".").
-		CombinedOutput(); err != nil {
-		log.Errorf("Create tar %s error : %v", imageTar, err)
+/* how commit makes image from container besides labels. */
+type commitOptions struct {
+	message string   /* the comment of history of new layer */
+	author  string   /* the author of image */
+	changes []string /* the Dockerfile instructions changing config of image */
+	pause   bool     /* whether processes of container are frozen while committing */
+}
+
+/*
+	commit the changes of container to the files of its image as a new layer on top of the image,
+	tagged by imageName in image store. Config and history of the image are inherited, with labels
+	and instructions of options applied.
+*/
+func commitContainer(containerName string, imageName string, labels map[string]string, options commitOptions) {
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		log.Errorf("Get container name %s error : %v", containerName, err)
+		return
+	}
+	ref, err := image.ParseReference(imageName)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	store, err := openImageStore()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	baseImage, err := containerImage(store, containerInfo)
+	if err != nil {
+		log.Errorf("Get image of container %s error : %v", containerName, err)
+		return
+	}
+	config := baseImage.Config.Copy()
+	if config.Labels == nil && len(labels) > 0 {
+		config.Labels = map[string]string{}
+	}
+	for key, value := range labels {
+		config.Labels[key] = value
+	}
+	for _, change := range options.changes {
+		if err := config.ApplyChange(change); err != nil {
+			log.Error(err)
+			return
+		}
+	}
+	driver, err := containerInfo.Storage()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	mntUrl := fmt.Sprintf(container.MntUrl, containerInfo.Id)
+	if _, err := os.Stat(mntUrl); err != nil {
+		log.Errorf("Root filesystem of container %s is not mounted : %v", containerName, err)
+		return
+	}
+
+	if options.pause && containerInfo.Status == container.RUNNING {
+		cgroupManager := cgroups.NewCgroupManager(path.Join(container.CgroupParent, containerInfo.Id))
+		if err := cgroupManager.Freeze(containerInfo.Pid); err != nil {
+			cgroupManager.Thaw()
+			log.Errorf("Pause container %s error : %v", containerName, err)
+			return
+		}
+		defer func() {
+			if err := cgroupManager.Thaw(); err != nil {
+				log.Errorf("Unpause container %s error : %v", containerName, err)
+			}
+		}()
+	}
+	log.Infof("commit container %s to image %s", containerInfo.Id, ref)
+	changes, err := driver.Diff(containerInfo.Id, containerInfo.ImageDir())
+	if err != nil {
+		log.Errorf("Get changes of container %s error : %v", containerName, err)
+		return
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(storage.WriteChanges(writer, mntUrl, changes))
+	}()
+	diffID, err := store.ImportLayer(reader)
+	reader.Close()
+	if err != nil {
+		log.Errorf("Create layer of container %s error : %v", containerName, err)
+		return
+	}
+
+	img := image.NewImage()
+	img.Author = options.author
+	img.Config = config
+	img.RootFS.DiffIDs = append(append([]image.Digest(nil), baseImage.RootFS.DiffIDs...), diffID)
+	img.History = append(append([]image.History(nil), baseImage.History...), image.History{
+		Created:   img.Created,
+		CreatedBy: "commit " + containerInfo.Id + " " + containerInfo.Command,
+		Author:    options.author,
+		Comment:   options.message,
+	})
+	if _, err := store.CreateImage(img); err != nil {
+		log.Errorf("Create image %s error : %v", ref, err)
+		return
+	}
+	if err := store.Tag(ref, img.ID); err != nil {
+		log.Errorf("Tag image %s error : %v", ref, err)
+		return
+	}
+	fmt.Println(img.ID)
+}
+
+/* the image container was run from, containers run before the image store refer to it by name. */
+func containerImage(store *image.Store, containerInfo *container.ContainerInfo) (*image.Image, error) {
+	if containerInfo.ImageID != "" {
+		return store.Get(image.Digest(containerInfo.ImageID))
+	}
+	return resolveImage(store, containerInfo.Image)
+}
+
+/* metadata of image saved beside its tarball by commit before the image store. */
+type imageInfo struct {
+	Name      string            `json:"name"`      /* the name of image */
+	Created   string            `json:"created"`   /* the commit time of image */
+	Container string            `json:"container"` /* the id of container committed from */
+	Labels    map[string]string `json:"labels"`    /* the user defined metadata of image */
+}
+
+func imageInfoFile(imageName string) string {
+	return container.RootUrl + "/" + imageName + ".json"
+}
+
+func loadImageInfo(imageName string) (*imageInfo, error) {
+	content, err := ioutil.ReadFile(imageInfoFile(imageName))
+	if err != nil {
+		return nil, err
+	}
+	var ii imageInfo
+	if err := json.Unmarshal(content, &ii); err != nil {
+		return nil, fmt.Errorf("unmarshal image content for image %s error : %v", imageName, err)
 	}
+	return &ii, nil
 }
@@ -0,0 +1,80 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+/* allocate a pseudo terminal pair, the master end and the path of slave end are returned. */
+func NewPty() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("open /dev/ptmx error : %v", err)
+	}
+	var ptyNum uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&ptyNum))); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("get pty number error : %v", err)
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, "", fmt.Errorf("unlock pty error : %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", ptyNum), nil
+}
+
+/* whether the given file descriptor refers to a terminal. */
+func IsTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+/* put terminal into raw mode, the returned function restores the previous state. */
+func SetRawTerminal(fd uintptr) (func(), error) {
+	var oldState syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, uintptr(unsafe.Pointer(&oldState))); err != nil {
+		return nil, fmt.Errorf("get terminal attributes error : %v", err)
+	}
+	newState := oldState
+	/* the same as cfmakeraw(3). */
+	newState.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	newState.Oflag &^= syscall.OPOST
+	newState.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	newState.Cflag &^= syscall.CSIZE | syscall.PARENB
+	newState.Cflag |= syscall.CS8
+	newState.Cc[syscall.VMIN] = 1
+	newState.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&newState))); err != nil {
+		return nil, fmt.Errorf("set terminal attributes error : %v", err)
+	}
+	return func() {
+		ioctl(fd, syscall.TCSETS, uintptr(unsafe.Pointer(&oldState)))
+	}, nil
+}
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+/* copy the window size of terminal `from` to terminal `to`. */
+func ResizeTerminal(from uintptr, to uintptr) error {
+	var ws winsize
+	if err := ioctl(from, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return err
+	}
+	return ioctl(to, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+func ioctl(fd uintptr, req uintptr, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
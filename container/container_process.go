@@ -1,17 +1,22 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/cgroups/subsystems"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 var (
 	RootUrl = "/root"
 	MntUrl  = "/root/mnt/%s"
-	WriteLayer = "/root/writeLayer/%s"
 )
 
 type ContainerInfo struct {
@@ -23,28 +28,164 @@ type ContainerInfo struct {
 	Status		string `json:"status"`			/* the status of container */
 	Volume 		string `json:"volume"`			/* the mounted volume of container */
 	PortMapping []string `json:"portmapping"`	/* the port mapping of container */
+	Image		string `json:"image"`			/* the image name of container */
+	ImageID		string `json:"imageId"`			/* the id of image in image store */
+	Env			[]string `json:"env"`			/* the extra environment variables of container */
+	Tty			bool `json:"tty"`				/* whether container is attached to terminal */
+	Resources	*subsystems.ResourceConfig `json:"resources"`	/* the cgroup resource limits of container */
+	Mounts		[]Mount `json:"mounts"`			/* the root filesystem and volume mounts of container */
+	NetworkSettings *NetworkSettings `json:"networkSettings"`	/* the network endpoint of container */
+	ExitCode	int `json:"exitCode"`			/* the exit code of init process, valid once exited */
+	StartedAt	string `json:"startedAt"`		/* the time init process started */
+	InitStartTime	string `json:"initStartTime"`	/* the start time of init process in clock ticks since boot */
+	FinishedAt	string `json:"finishedAt"`		/* the time init process exited */
+	Labels		map[string]string `json:"labels"`	/* the user defined metadata of container */
+	Healthcheck	*HealthConfig `json:"healthcheck"`	/* the health check of container */
+	Health		*Health `json:"health"`			/* the health status of container */
+	RestartCount int `json:"restartCount"`		/* the times container was restarted */
+	Ulimits		[]Ulimit `json:"ulimits"`		/* the resource limits of init process */
+	Sysctls		map[string]string `json:"sysctls"`	/* the namespaced kernel parameters of container */
+	Namespaces	map[string]string `json:"namespaces"`	/* the namespaces shared with host or other containers */
+	TimeOffsets	*TimeOffsets `json:"timeOffsets"`	/* the clock offsets of time namespace of container */
+	Driver		string `json:"driver"`			/* the storage driver of root filesystem */
+	StorageOpt	map[string]string `json:"storageOpt"`	/* the options of writable layer, e.g. its size */
+	WorkingDir	string `json:"workingDir"`		/* the working directory of init process, from image */
+	User		string `json:"user"`			/* the user of init process in form of user[:group], from image */
 }
 
+type Mount struct {
+	Type		string `json:"type"`			/* the filesystem type of mount */
+	Source		string `json:"source"`			/* the source path in host machine */
+	Destination	string `json:"destination"`	/* the mount point in container */
+}
+
+type NetworkSettings struct {
+	Network		string `json:"network"`		/* the name of connected network */
+	EndpointId	string `json:"endpointId"`		/* the id of endpoint in network */
+	IPAddress	string `json:"ipAddress"`		/* the ip address of container in network */
+	Gateway		string `json:"gateway"`		/* the gateway ip address of network */
+	MacAddress	string `json:"macAddress"`		/* the mac address of container interface */
+	PortMapping	[]string `json:"portmapping"`	/* the host to container port mappings */
+}
+
+const TimeFormat = "2006-01-02 15:04:05"
+
 const (
 	RUNNING  			string = "running"
 	STOP  	 			string = "stopped"
 	EXIT  	 			string = "exited"
-	DefaultInfoLocation string = "/var/run/tinydocker/%s/"
+	DefaultInfoLocation string = "/var/run/tinydocker/containers/%s/"
+	NameIndexLocation	string = "/var/run/tinydocker/names/"
 	ConfigName			string = "config.json"
-	NameLength			int    = 10
 	LogName				string = "container.log"
+	CgroupParent		string = "tinydocker-cgroup"
 )
 
-func NewParentProcess(tty bool, volumeStr string, containerName string, imageName string,
-	envSlice []string) (*exec.Cmd, *os.File) {
+/* save container information to its config file. */
+func (ci *ContainerInfo) Dump() error {
+	containerSavedUrl := fmt.Sprintf(DefaultInfoLocation, ci.Id)
+	if err := os.MkdirAll(containerSavedUrl, 0622); err != nil {
+		return fmt.Errorf("create container saved directory failed, %v", err)
+	}
+	containerBytes, err := json.Marshal(ci)
+	if err != nil {
+		return fmt.Errorf("marshal container %s information error %v", ci.Name, err)
+	}
+	/* write and rename, so that concurrent readers never see a partial file. */
+	tmpFile, err := ioutil.TempFile(containerSavedUrl, "."+ConfigName)
+	if err != nil {
+		return fmt.Errorf("write container infomation to file failed, %v", err)
+	}
+	_, err = tmpFile.Write(containerBytes)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0622)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), containerSavedUrl+ConfigName)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("write container infomation to file failed, %v", err)
+	}
+	return nil
+}
+
+/*
+	sync status with the init process, which may be gone without any record, e.g. the supervisor
+	was killed, or the pid has been reused by another process. Report whether the status changed.
+*/
+func (ci *ContainerInfo) RefreshStatus() bool {
+	if ci.Status != RUNNING || ci.initAlive() {
+		return false
+	}
+	ci.Status = EXIT
+	ci.Pid = ""
+	/* nobody observed the exit, the exit code is unknown. */
+	ci.ExitCode = -1
+	if ci.FinishedAt == "" {
+		ci.FinishedAt = time.Now().Format(TimeFormat)
+	}
+	return true
+}
+
+/* containers recorded without start time of init process only have their pid checked. */
+func (ci *ContainerInfo) initAlive() bool {
+	if ci.InitStartTime == "" {
+		return processAlive(ci.Pid)
+	}
+	pid, err := strconv.Atoi(ci.Pid)
+	return err == nil && pid > 0 && ProcessStartTime(pid) == ci.InitStartTime
+}
+
+/* the start time of process in clock ticks since boot, empty if process does not exist. */
+func ProcessStartTime(pid int) string {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ""
+	}
+	return parseStartTime(string(stat))
+}
+
+/* the start time in content of /proc/<pid>/stat, empty if process is a zombie. */
+func parseStartTime(stat string) string {
+	/* comm may contain spaces and brackets, fields are counted from the last ')'. */
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	/* a zombie has exited already. */
+	if len(fields) < 20 || fields[0] == "Z" {
+		return ""
+	}
+	return fields[19]
+}
+
+func NewParentProcess(tty bool, volumeStr string, containerId string, imageDir string,
+	envSlice []string, namespaces map[string]string, driverName string,
+	storageOpts map[string]string) (*exec.Cmd, *os.File) {
+	cmd, wp := NewInitProcess(tty, containerId, envSlice, namespaces)
+	if cmd == nil {
+		return nil, nil
+	}
+	if err := NewWorkSpace(volumeStr, imageDir, containerId, driverName, storageOpts); err != nil {
+		log.Errorf("Create workspace of container %s error : %v", containerId, err)
+		wp.Close()
+		return nil, nil
+	}
+	return cmd, wp
+}
+
+/* the init process of container on an existing workspace, also used to restart container. */
+func NewInitProcess(tty bool, containerId string, envSlice []string,
+	namespaces map[string]string) (*exec.Cmd, *os.File) {
 	rp, wp, err := NewPipe()
 	if err != nil {
 		log.Errorf("New pipe error %v", err)
 		return nil, nil
 	}
-	cmd := exec.Command("/proc/self/exe", "init", containerName)
+	cmd := exec.Command("/proc/self/exe", "init", containerId)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+		Cloneflags: cloneFlags(namespaces),
 	}
 	if tty {
 		cmd.Stdin = os.Stdin
@@ -52,7 +193,7 @@ func NewParentProcess(tty bool, volumeStr string, containerName string, imageNam
 		cmd.Stderr = os.Stderr
 	} else {
 		/* redirect ouput of init process to a temporary file. */
-		err, clf := createContainerLogFile(containerName)
+		err, clf := createContainerLogFile(containerId)
 		if err != nil {
 			log.Errorf("Create container log file error : %v", err)
 			// ...
@@ -63,8 +204,7 @@ func NewParentProcess(tty bool, volumeStr string, containerName string, imageNam
 
 	cmd.ExtraFiles = []*os.File{rp}
 	cmd.Env = append(os.Environ(), envSlice...)
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
-	NewWorkSpace(volumeStr, imageName, containerName)
+	cmd.Dir = fmt.Sprintf(MntUrl, containerId)
 	return cmd, wp
 }
 
@@ -76,15 +216,16 @@ func NewPipe() (*os.File, *os.File, error) {
 	}
 }
 
-func createContainerLogFile(containerName string) (error, *os.File) {
-	containerLogDir := fmt.Sprintf(DefaultInfoLocation, containerName)
+func createContainerLogFile(containerId string) (error, *os.File) {
+	containerLogDir := fmt.Sprintf(DefaultInfoLocation, containerId)
 	if err := os.MkdirAll(containerLogDir, 0622); err != nil {
-		return fmt.Errorf("create log directory for container %s error : %v", containerName, err), nil
+		return fmt.Errorf("create log directory for container %s error : %v", containerId, err), nil
 	}
 	containerLogFile := containerLogDir + LogName
-	clf, err := os.Create(containerLogFile)
+	/* append, so that output of a restarted container is kept. */
+	clf, err := os.OpenFile(containerLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return fmt.Errorf("create log file for container %s error : %v", containerName, err), nil
+		return fmt.Errorf("create log file for container %s error : %v", containerId, err), nil
 	}
 	return nil, clf
 }
\ No newline at end of file
@@ -0,0 +1,57 @@
+package container
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestParseStartTime(t *testing.T) {
+	cases := map[string]string{
+		"42 (sh) S 1 42 42 0 -1 4194560 100 0 0 0 0 0 0 0 20 0 1 0 12345 1000 100":        "12345",
+		"42 (my (odd) name) R 1 42 42 0 -1 4194560 100 0 0 0 0 0 0 0 20 0 1 0 678 1000 1": "678",
+		"42 (a) b) S 1 42 42 0 -1 4194560 100 0 0 0 0 0 0 0 20 0 1 0 9 1000 1":            "9",
+		"42 (sh) Z 1 42 42 0 -1 4194560 100 0 0 0 0 0 0 0 20 0 1 0 12345 0 0":             "",
+		"42 (sh) S 1 42": "",
+		"":               "",
+	}
+	for stat, expected := range cases {
+		if startTime := parseStartTime(stat); startTime != expected {
+			t.Errorf("start time of %q is %q, expect %q", stat, startTime, expected)
+		}
+	}
+	if startTime := ProcessStartTime(os.Getpid()); startTime == "" || startTime != ProcessStartTime(os.Getpid()) {
+		t.Errorf("start time of current process is %q", startTime)
+	}
+}
+
+/* a running container whose pid is gone or taken by another process is exited. */
+func TestRefreshStatus(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	startTime := ProcessStartTime(os.Getpid())
+	cases := []struct {
+		status        string
+		pid           string
+		initStartTime string
+		expected      string
+	}{
+		{RUNNING, pid, startTime, RUNNING},
+		{RUNNING, pid, "", RUNNING},
+		{RUNNING, pid, startTime + "0", EXIT},
+		{RUNNING, "", "", EXIT},
+		{RUNNING, "4194305", startTime, EXIT},
+		{STOP, pid, startTime + "0", STOP},
+		{EXIT, "", "", EXIT},
+	}
+	for _, c := range cases {
+		ci := &ContainerInfo{Status: c.status, Pid: c.pid, InitStartTime: c.initStartTime}
+		changed := ci.RefreshStatus()
+		if ci.Status != c.expected || changed != (c.status != c.expected) {
+			t.Errorf("%s container of pid %q started at %q is refreshed to %s, changed %v, expect %s",
+				c.status, c.pid, c.initStartTime, ci.Status, changed, c.expected)
+		}
+		if changed && (ci.Pid != "" || ci.ExitCode != -1 || ci.FinishedAt == "") {
+			t.Errorf("exited container keeps pid %q, exit code %d, finish time %q", ci.Pid, ci.ExitCode, ci.FinishedAt)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package container
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	ExecDirName      string = "exec"
+	ExecStatusSuffix string = ".status"
+)
+
+type ExecInfo struct {
+	Id        string `json:"id"`        /* the id of exec session */
+	Command   string `json:"command"`   /* the executing command of exec session */
+	Pid       string `json:"pid"`       /* the pid of executing command in host machine */
+	StartTime string `json:"startTime"` /* the start time of exec session */
+	ExitCode  int    `json:"exitCode"`  /* the exit code of executing command, -1 if unknown */
+	Running   bool   `json:"running"`   /* whether executing command is still running */
+	Tty       bool   `json:"tty"`       /* whether a pseudo tty is attached */
+	Detached  bool   `json:"detached"`  /* whether exec session runs in background */
+}
+
+/* generate a random 64 hex characters id. */
+func NewRandomId() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("read random bytes error : %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func execSessionDir(containerId string) string {
+	return path.Join(fmt.Sprintf(DefaultInfoLocation, containerId), ExecDirName)
+}
+
+/* the status file is appended by nsenter with `pid <pid>` and `exit <code>` lines. */
+func OpenExecStatusFile(containerId string, execId string) (*os.File, error) {
+	execDir := execSessionDir(containerId)
+	if err := os.MkdirAll(execDir, 0622); err != nil {
+		return nil, fmt.Errorf("create exec session directory %s error : %v", execDir, err)
+	}
+	statusFile := path.Join(execDir, execId+ExecStatusSuffix)
+	return os.OpenFile(statusFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0622)
+}
+
+func (ei *ExecInfo) Dump(containerId string) error {
+	execDir := execSessionDir(containerId)
+	if err := os.MkdirAll(execDir, 0622); err != nil {
+		return fmt.Errorf("create exec session directory %s error : %v", execDir, err)
+	}
+	execBytes, err := json.Marshal(ei)
+	if err != nil {
+		return fmt.Errorf("marshal exec session %s error : %v", ei.Id, err)
+	}
+	execFile := path.Join(execDir, ei.Id+".json")
+	if err := ioutil.WriteFile(execFile, execBytes, 0622); err != nil {
+		return fmt.Errorf("write exec session file %s error : %v", execFile, err)
+	}
+	return nil
+}
+
+/* load every exec session of container, with pid and running state refreshed from status file. */
+func LoadExecSessions(containerId string) ([]*ExecInfo, error) {
+	execDir := execSessionDir(containerId)
+	files, err := ioutil.ReadDir(execDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read exec session directory %s error : %v", execDir, err)
+	}
+	var sessions []*ExecInfo
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(execDir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read exec session file %s error : %v", f.Name(), err)
+		}
+		var execInfo ExecInfo
+		if err := json.Unmarshal(content, &execInfo); err != nil {
+			return nil, fmt.Errorf("unmarshal exec session %s error : %v", f.Name(), err)
+		}
+		if execInfo.Running {
+			if changed := execInfo.refresh(path.Join(execDir, execInfo.Id+ExecStatusSuffix)); changed {
+				execInfo.Dump(containerId)
+			}
+		}
+		sessions = append(sessions, &execInfo)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartTime < sessions[j].StartTime
+	})
+	return sessions, nil
+}
+
+/* sync exec session with its status file, report whether anything changed. */
+func (ei *ExecInfo) refresh(statusFile string) bool {
+	changed := false
+	pid, exitCode, exited := ReadExecStatus(statusFile)
+	if pid != "" && ei.Pid != pid {
+		ei.Pid = pid
+		changed = true
+	}
+	if exited {
+		ei.ExitCode = exitCode
+	} else if !processAlive(ei.Pid) {
+		/* killed without chance to report, the exit code is unknown. */
+		ei.ExitCode = -1
+		exited = true
+	}
+	if exited {
+		ei.Running = false
+		changed = true
+	}
+	return changed
+}
+
+/* the pid of command reported by nsenter, and its exit code once exited is true. */
+func ReadExecStatus(statusFile string) (string, int, bool) {
+	pid, exitCode, exited := "", 0, false
+	f, err := os.Open(statusFile)
+	if err != nil {
+		return pid, exitCode, exited
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "pid":
+			pid = fields[1]
+		case "exit":
+			exitCode, _ = strconv.Atoi(fields[1])
+			exited = true
+		}
+	}
+	return pid, exitCode, exited
+}
+
+func processAlive(pid string) bool {
+	pidInt, err := strconv.Atoi(pid)
+	if err != nil || pidInt <= 0 {
+		return false
+	}
+	return syscall.Kill(pidInt, 0) == nil
+}
+
+/* mark exec session finished with exit code observed by the foreground tinydocker process. */
+func (ei *ExecInfo) Finish(containerId string, exitCode int) error {
+	ei.refresh(path.Join(execSessionDir(containerId), ei.Id+ExecStatusSuffix))
+	ei.ExitCode = exitCode
+	ei.Running = false
+	return ei.Dump(containerId)
+}
@@ -0,0 +1,67 @@
+package container
+
+import (
+	"time"
+)
+
+const (
+	HealthStarting  string = "starting"
+	HealthHealthy   string = "healthy"
+	HealthUnhealthy string = "unhealthy"
+
+	/* actions taken by supervisor once container turns unhealthy. */
+	HealthActionNone    string = "none"
+	HealthActionKill    string = "kill"
+	HealthActionRestart string = "restart"
+
+	MaxHealthLogEntries = 5
+	/* the output of check kept in health log is truncated. */
+	MaxHealthOutputLength = 4096
+)
+
+type HealthConfig struct {
+	Command     string        `json:"command"`     /* the check command run by `sh -c` in container */
+	Interval    time.Duration `json:"interval"`    /* the time between two checks */
+	Timeout     time.Duration `json:"timeout"`     /* the time after which a check is considered failed */
+	Retries     int           `json:"retries"`     /* the consecutive failures needed to be unhealthy */
+	StartPeriod time.Duration `json:"startPeriod"` /* the time failures are not counted after start */
+	OnFailure   string        `json:"onFailure"`   /* the action taken once unhealthy */
+}
+
+type Health struct {
+	Status        string          `json:"status"`        /* starting, healthy or unhealthy */
+	FailingStreak int             `json:"failingStreak"` /* the number of consecutive failures */
+	Log           []*HealthResult `json:"log"`           /* the latest check results */
+}
+
+type HealthResult struct {
+	Start    string `json:"start"`    /* the start time of check */
+	End      string `json:"end"`      /* the end time of check */
+	ExitCode int    `json:"exitCode"` /* 0 for success, -1 for timeout */
+	Output   string `json:"output"`   /* the output of check command */
+}
+
+/*
+	record the result of a check and update health status. Failures in start period do not count,
+	unless the container has been healthy once. Report whether container just turns unhealthy.
+*/
+func (h *Health) Record(result *HealthResult, config *HealthConfig, inStartPeriod bool) bool {
+	h.Log = append(h.Log, result)
+	if len(h.Log) > MaxHealthLogEntries {
+		h.Log = h.Log[len(h.Log)-MaxHealthLogEntries:]
+	}
+	if result.ExitCode == 0 {
+		h.Status = HealthHealthy
+		h.FailingStreak = 0
+		return false
+	}
+	if inStartPeriod && h.Status == HealthStarting {
+		return false
+	}
+	h.FailingStreak++
+	if h.FailingStreak >= config.Retries && h.Status != HealthUnhealthy {
+		h.Status = HealthUnhealthy
+		return true
+	}
+	return false
+}
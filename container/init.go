@@ -1,6 +1,7 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	log "github.com/Sirupsen/logrus"
@@ -14,19 +15,53 @@ import (
 	"syscall"
 )
 
-func RunContainerInitProcess(containerName string) error {
+func RunContainerInitProcess(containerId string) error {
 	cmdArray := readUserCommand()
 	log.Infof("Init process executing command %s", strings.Join(cmdArray, " "))
 	if cmdArray == nil || len(cmdArray) == 0 {
 		return fmt.Errorf("run container get user command error, cmdArray is nil")
 	}
+	/* load before pivoting root, the container information lives in host filesystem. */
+	containerInfo, err := LoadContainerInfo(containerId)
+	if err != nil {
+		return err
+	}
+	if err := joinNamespaces(containerInfo.Namespaces); err != nil {
+		return err
+	}
+	if err := unshareNamespaces(containerInfo.Namespaces, containerInfo.TimeOffsets); err != nil {
+		return err
+	}
 	setupMount()
+	if err := setTimeOffsets(containerInfo.TimeOffsets); err != nil {
+		return err
+	}
+	if err := applySysctls(containerInfo.Sysctls); err != nil {
+		return err
+	}
+	if err := applyUlimits(containerInfo.Ulimits); err != nil {
+		return err
+	}
+	/* the working directory of image is created if missing, as docker does. */
+	if containerInfo.WorkingDir != "" {
+		if err := os.MkdirAll(containerInfo.WorkingDir, 0755); err != nil {
+			return fmt.Errorf("create working directory %s error : %v", containerInfo.WorkingDir, err)
+		}
+		if err := os.Chdir(containerInfo.WorkingDir); err != nil {
+			return fmt.Errorf("change working directory to %s error : %v", containerInfo.WorkingDir, err)
+		}
+	}
 	path, err := exec.LookPath(cmdArray[0])
 	if err != nil {
 		log.Errorf("Exec loop path error %v", err)
 		return err
 	}
-	hokOfProcessExit(containerName)
+	if containerInfo.User != "" {
+		if err := setUser(containerInfo.User); err != nil {
+			return err
+		}
+	}
+	hokOfProcessExit(containerId)
 	log.Infof("Find path %s", path)
 	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
 		logrus.Errorf(err.Error())
@@ -34,7 +69,7 @@ func RunContainerInitProcess(containerName string) error {
 	return nil
 }
 
-func hokOfProcessExit(containerName string) {
+func hokOfProcessExit(containerId string) {
 	var stopLock sync.Mutex
 	stop := false
 	signalChan := make(chan os.Signal, 1)
@@ -45,17 +80,17 @@ func hokOfProcessExit(containerName string) {
 			stop = true
 			stopLock.Unlock()
 			log.Info("Cleaning before stop...")
-			deleteContainerInfo(containerName)
+			deleteContainerInfo(containerId)
 			os.Exit(0)
 	}()
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 }
 
-func deleteContainerInfo(containerName string) {
-	containerSavedUrl := fmt.Sprintf(DefaultInfoLocation, containerName)
+func deleteContainerInfo(containerId string) {
+	containerSavedUrl := fmt.Sprintf(DefaultInfoLocation, containerId)
 	exists, _ := PathExists(containerSavedUrl)
 	if !exists {
-		log.Errorf("Container %s not found, abort delete operation", containerName)
+		log.Errorf("Container %s not found, abort delete operation", containerId)
 		return
 	}
 	if err := os.RemoveAll(containerSavedUrl); err != nil {
@@ -63,6 +98,7 @@ func deleteContainerInfo(containerName string) {
 	}
 }
 
+/* the command is sent as a JSON array, so that arguments keep their spaces. */
 func readUserCommand() []string {
 	pipe := os.NewFile(uintptr(3), "pipe")
 	msg, err := ioutil.ReadAll(pipe)
@@ -70,8 +106,30 @@ func readUserCommand() []string {
 		log.Errorf("init read pipe error %v", err)
 		return nil
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	var cmdArray []string
+	if err := json.Unmarshal(msg, &cmdArray); err != nil {
+		log.Errorf("init unmarshal command error %v", err)
+		return nil
+	}
+	return cmdArray
+}
+
+/* switch to user of image, resolved against /etc/passwd and /etc/group of container. */
+func setUser(userSpec string) error {
+	execUser, err := LookupUser("/", userSpec)
+	if err != nil {
+		return fmt.Errorf("lookup user %s error : %v", userSpec, err)
+	}
+	if err := syscall.Setgroups(execUser.Groups); err != nil {
+		return fmt.Errorf("set groups of user %s error : %v", userSpec, err)
+	}
+	if err := syscall.Setgid(execUser.Gid); err != nil {
+		return fmt.Errorf("set gid of user %s error : %v", userSpec, err)
+	}
+	if err := syscall.Setuid(execUser.Uid); err != nil {
+		return fmt.Errorf("set uid of user %s error : %v", userSpec, err)
+	}
+	return nil
 }
 
 func pivotRoot2(rootfs string) error {
@@ -134,7 +192,7 @@ func pivotRoot2(rootfs string) error {
 /**
   refer: http://man7.org/linux/man-pages/man2/pivot_root.2.html
  */
-func pivotRoot(root string) error {
+func PivotRoot(root string) error {
 
 	/* Ensure that 'new_root' is a mount point */
 	if err := syscall.Mount(root, root, "bind", uintptr(syscall.MS_BIND | syscall.MS_REC), ""); err != nil {
@@ -172,8 +230,59 @@ func setupMount()  {
 		log.Errorf("mount / error: %v", err)
 		return
 	}
-	pivotRoot(pwd)
+	PivotRoot(pwd)
 	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
 	syscall.Mount("tmpfs", "/dev", "tmpfs", uintptr(syscall.MS_NOSUID | syscall.MS_STRICTATIME), "mode=755")
+	if err := mountCgroups(); err != nil {
+		log.Errorf("Mount cgroup filesystem error : %v", err)
+	}
+}
+
+/*
+	mount sysfs and the cgroup hierarchies listed in /proc/self/cgroup read only, which shows the
+	cgroup of container as root in its cgroup namespace.
+*/
+func mountCgroups() error {
+	readonlyFlags := uintptr(syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY)
+	if err := os.MkdirAll("/sys", 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("sysfs", "/sys", "sysfs", readonlyFlags, ""); err != nil {
+		return fmt.Errorf("mount sysfs error : %v", err)
+	}
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	var controllers []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		/* each line is `hierarchy-id:controllers:path`, the controllers of cgroup v2 are empty. */
+		if fields := strings.SplitN(line, ":", 3); len(fields) == 3 && fields[1] != "" {
+			controllers = append(controllers, fields[1])
+		}
+	}
+	cgroupRoot := "/sys/fs/cgroup"
+	if len(controllers) == 0 {
+		return syscall.Mount("cgroup2", cgroupRoot, "cgroup2", readonlyFlags, "")
+	}
+	if err := syscall.Mount("tmpfs", cgroupRoot, "tmpfs", uintptr(syscall.MS_NOEXEC|syscall.MS_NOSUID|syscall.MS_NODEV), "mode=755"); err != nil {
+		return fmt.Errorf("mount tmpfs to %s error : %v", cgroupRoot, err)
+	}
+	for _, controller := range controllers {
+		dir := filepath.Join(cgroupRoot, strings.TrimPrefix(controller, "name="))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Mount("cgroup", dir, "cgroup", readonlyFlags, controller); err != nil {
+			return fmt.Errorf("mount cgroup %s error : %v", controller, err)
+		}
+		/* co-mounted controllers, such as cpu,cpuacct, are also reachable by each name. */
+		if names := strings.Split(controller, ","); len(names) > 1 {
+			for _, name := range names {
+				os.Symlink(controller, filepath.Join(cgroupRoot, name))
+			}
+		}
+	}
+	return syscall.Mount("", cgroupRoot, "", uintptr(syscall.MS_REMOUNT)|readonlyFlags, "")
 }
\ No newline at end of file
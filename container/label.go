@@ -0,0 +1,103 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+	parse labels given by files of `key=value` lines and by `--label key=value` flags, the
+	latter override the former. Blank lines and lines starting with `#` in files are ignored.
+*/
+func ParseLabels(labelSlice []string, labelFiles []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, labelFile := range labelFiles {
+		lines, err := readLabelFile(labelFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := addLabels(labels, lines); err != nil {
+			return nil, fmt.Errorf("label file %s : %v", labelFile, err)
+		}
+	}
+	if err := addLabels(labels, labelSlice); err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
+
+func readLabelFile(labelFile string) ([]string, error) {
+	f, err := os.Open(labelFile)
+	if err != nil {
+		return nil, fmt.Errorf("open label file %s error : %v", labelFile, err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read label file %s error : %v", labelFile, err)
+	}
+	return lines, nil
+}
+
+/* a label without `=` has an empty value, as docker does. */
+func addLabels(labels map[string]string, labelSlice []string) error {
+	for _, label := range labelSlice {
+		kv := strings.SplitN(label, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			return fmt.Errorf("bad format of label %s, expect key=value", label)
+		}
+		if len(kv) == 1 {
+			labels[key] = ""
+		} else {
+			labels[key] = kv[1]
+		}
+	}
+	return nil
+}
+
+/* label filter is either `key` or `key=value`. */
+func MatchLabel(labels map[string]string, filter string) bool {
+	kv := strings.SplitN(filter, "=", 2)
+	value, ok := labels[kv[0]]
+	if len(kv) == 1 {
+		return ok
+	}
+	return ok && value == kv[1]
+}
+
+/* parse `label=...` filters shared by list commands, other filter keys are rejected. */
+func ParseLabelFilters(filterSlice []string) ([]string, error) {
+	var labelFilters []string
+	for _, filter := range filterSlice {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[0] != "label" {
+			return nil, fmt.Errorf("unsupported filter %s, expect label=key or label=key=value", filter)
+		}
+		labelFilters = append(labelFilters, kv[1])
+	}
+	return labelFilters, nil
+}
+
+/* whether labels match every label filter. */
+func MatchLabels(labels map[string]string, labelFilters []string) bool {
+	for _, filter := range labelFilters {
+		if !MatchLabel(labels, filter) {
+			return false
+		}
+	}
+	return true
+}
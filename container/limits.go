@@ -0,0 +1,156 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type Ulimit struct {
+	Name string `json:"name"` /* the resource name without `RLIMIT_` prefix, e.g. nofile */
+	Soft uint64 `json:"soft"` /* the soft limit */
+	Hard uint64 `json:"hard"` /* the hard limit */
+}
+
+/* resources accepted by `--ulimit`, as numbered in linux. */
+var ulimitTypes = map[string]int{
+	"cpu":        0,
+	"fsize":      1,
+	"data":       2,
+	"stack":      3,
+	"core":       4,
+	"rss":        5,
+	"nproc":      6,
+	"nofile":     7,
+	"memlock":    8,
+	"as":         9,
+	"locks":      10,
+	"sigpending": 11,
+	"msgqueue":   12,
+	"nice":       13,
+	"rtprio":     14,
+	"rttime":     15,
+}
+
+/* sysctls isolated by namespaces of container, the ones ending with `.` are prefixes. */
+var namespacedSysctls = map[string]string{
+	"kernel.msgmax":          NamespaceIpc,
+	"kernel.msgmnb":          NamespaceIpc,
+	"kernel.msgmni":          NamespaceIpc,
+	"kernel.sem":             NamespaceIpc,
+	"kernel.shmall":          NamespaceIpc,
+	"kernel.shmmax":          NamespaceIpc,
+	"kernel.shmmni":          NamespaceIpc,
+	"kernel.shm_rmid_forced": NamespaceIpc,
+	"fs.mqueue.":             NamespaceIpc,
+	"net.":                   NamespaceNet,
+}
+
+/* parse `name=soft:hard` or `name=limit` for both soft and hard limit, `-1` means unlimited (RLIM_INFINITY). */
+func ParseUlimits(ulimitSlice []string) ([]Ulimit, error) {
+	var ulimits []Ulimit
+	seen := map[string]bool{}
+	for _, ulimitStr := range ulimitSlice {
+		kv := strings.SplitN(ulimitStr, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid ulimit %s, must be in form of name=soft:hard", ulimitStr)
+		}
+		if _, ok := ulimitTypes[kv[0]]; !ok {
+			return nil, fmt.Errorf("invalid ulimit type %s", kv[0])
+		}
+		if seen[kv[0]] {
+			return nil, fmt.Errorf("ulimit %s is given more than once", kv[0])
+		}
+		seen[kv[0]] = true
+		limits := strings.SplitN(kv[1], ":", 2)
+		soft, err := parseLimit(limits[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %s : %v", ulimitStr, err)
+		}
+		hard := soft
+		if len(limits) == 2 {
+			if hard, err = parseLimit(limits[1]); err != nil {
+				return nil, fmt.Errorf("invalid ulimit %s : %v", ulimitStr, err)
+			}
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("invalid ulimit %s, soft limit must not exceed hard limit", ulimitStr)
+		}
+		ulimits = append(ulimits, Ulimit{Name: kv[0], Soft: soft, Hard: hard})
+	}
+	return ulimits, nil
+}
+
+func parseLimit(limit string) (uint64, error) {
+	if limit == "-1" || limit == "unlimited" {
+		return ^uint64(0), nil
+	}
+	return strconv.ParseUint(limit, 10, 64)
+}
+
+/*
+	parse `key=value` sysctls, rejecting the ones not isolated by namespaces of container, which
+	would change the host or containers sharing the namespace.
+*/
+func ParseSysctls(sysctlSlice []string, namespaces map[string]string) (map[string]string, error) {
+	if len(sysctlSlice) == 0 {
+		return nil, nil
+	}
+	sysctls := map[string]string{}
+	for _, sysctlStr := range sysctlSlice {
+		kv := strings.SplitN(sysctlStr, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid sysctl %s, must be in form of key=value", sysctlStr)
+		}
+		/* `/` is accepted as separator as sysctl(8) does, and must not escape /proc/sys. */
+		key := strings.Replace(kv[0], "/", ".", -1)
+		if strings.Contains(key, "..") || strings.HasPrefix(key, ".") {
+			return nil, fmt.Errorf("invalid sysctl key %s", kv[0])
+		}
+		nsType, ok := sysctlNamespace(key)
+		if !ok {
+			return nil, fmt.Errorf("sysctl %s is not namespaced and would affect the host", kv[0])
+		}
+		if namespaces[nsType] != "" {
+			return nil, fmt.Errorf("sysctl %s is not allowed with %s namespace shared in mode %s",
+				kv[0], nsType, namespaces[nsType])
+		}
+		sysctls[key] = kv[1]
+	}
+	return sysctls, nil
+}
+
+/* the namespace isolating sysctl. */
+func sysctlNamespace(key string) (string, bool) {
+	for namespaced, nsType := range namespacedSysctls {
+		if strings.HasSuffix(namespaced, ".") && strings.HasPrefix(key, namespaced) || key == namespaced {
+			return nsType, true
+		}
+	}
+	return "", false
+}
+
+/* write sysctls through /proc/sys of container, only after proc of container is mounted. */
+func applySysctls(sysctls map[string]string) error {
+	for key, value := range sysctls {
+		sysctlFile := path.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
+		if err := ioutil.WriteFile(sysctlFile, []byte(value), 0644); err != nil {
+			return fmt.Errorf("set sysctl %s error : %v", key, err)
+		}
+	}
+	return nil
+}
+
+/* set resource limits of the init process, which are inherited over exec. */
+func applyUlimits(ulimits []Ulimit) error {
+	for _, ulimit := range ulimits {
+		rlimit := &syscall.Rlimit{Cur: ulimit.Soft, Max: ulimit.Hard}
+		if err := syscall.Setrlimit(ulimitTypes[ulimit.Name], rlimit); err != nil {
+			return fmt.Errorf("set ulimit %s error : %v", ulimit.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUlimits(t *testing.T) {
+	unlimited := ^uint64(0)
+	cases := map[string][]Ulimit{
+		"nofile=1024":           {{Name: "nofile", Soft: 1024, Hard: 1024}},
+		"nofile=1024:2048":      {{Name: "nofile", Soft: 1024, Hard: 2048}},
+		"core=0:-1":             {{Name: "core", Soft: 0, Hard: unlimited}},
+		"stack=unlimited":       {{Name: "stack", Soft: unlimited, Hard: unlimited}},
+		"nofile":                nil,
+		"files=1024":            nil,
+		"nofile=2048:1024":      nil,
+		"nofile=-2":             nil,
+		"nofile=ten":            nil,
+		"nproc=10:":             nil,
+		"RLIMIT_NOFILE=1024":    nil,
+		"nofile=1024:2048:4096": nil,
+	}
+	for ulimitStr, expected := range cases {
+		ulimits, err := ParseUlimits([]string{ulimitStr})
+		if expected == nil {
+			if err == nil {
+				t.Errorf("invalid ulimit %s is parsed as %v", ulimitStr, ulimits)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(ulimits, expected) {
+			t.Errorf("ulimit %s is parsed as %v, expect %v : %v", ulimitStr, ulimits, expected, err)
+		}
+	}
+	if _, err := ParseUlimits([]string{"nofile=1024", "nofile=2048"}); err == nil {
+		t.Errorf("ulimit given twice is parsed")
+	}
+}
+
+func TestParseSysctls(t *testing.T) {
+	cases := []struct {
+		sysctl     string
+		namespaces map[string]string
+		key        string /* the key parsed, empty if sysctl is refused */
+	}{
+		{"net.ipv4.ip_forward=1", nil, "net.ipv4.ip_forward"},
+		{"net/ipv4/ping_group_range=0 0", nil, "net.ipv4.ping_group_range"},
+		{"kernel.shmmax=1024", nil, "kernel.shmmax"},
+		{"fs.mqueue.msg_max=100", nil, "fs.mqueue.msg_max"},
+		{"kernel.hostname=web", nil, ""},
+		{"vm.swappiness=0", nil, ""},
+		{"kernel.shmmaxx=1", nil, ""},
+		{"net.ipv4.ip_forward", nil, ""},
+		{"=1", nil, ""},
+		{"net/../../kernel/panic=1", nil, ""},
+		{".net.core=1", nil, ""},
+		{"net.ipv4.ip_forward=1", map[string]string{NamespaceNet: NamespaceModeHost}, ""},
+		{"kernel.sem=1", map[string]string{NamespaceIpc: NamespaceModeContainer + "abc"}, ""},
+		{"kernel.sem=1", map[string]string{NamespaceNet: NamespaceModeHost}, "kernel.sem"},
+	}
+	for _, c := range cases {
+		sysctls, err := ParseSysctls([]string{c.sysctl}, c.namespaces)
+		if c.key == "" {
+			if err == nil {
+				t.Errorf("sysctl %s with namespaces %v is parsed as %v", c.sysctl, c.namespaces, sysctls)
+			}
+			continue
+		}
+		if err != nil || len(sysctls) != 1 || sysctls[c.key] == "" {
+			t.Errorf("sysctl %s is parsed as %v, expect key %s : %v", c.sysctl, sysctls, c.key, err)
+		}
+	}
+	if sysctls, err := ParseSysctls(nil, nil); err != nil || sysctls != nil {
+		t.Errorf("no sysctls are parsed as %v : %v", sysctls, err)
+	}
+}
@@ -0,0 +1,115 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+const (
+	VolumeInfoLocation string = "/var/run/tinydocker/volumes/%s/"
+	VolumeDataUrl      string = "/root/volumes/%s"
+)
+
+/* names of volume, which distinguishes them from host paths in `-v`. */
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+type VolumeInfo struct {
+	Name       string            `json:"name"`       /* the name of volume */
+	Mountpoint string            `json:"mountpoint"` /* the data directory of volume in host machine */
+	CreatedAt  string            `json:"createdAt"`  /* the create time of volume */
+	Labels     map[string]string `json:"labels"`     /* the user defined metadata of volume */
+}
+
+func IsVolumeName(name string) bool {
+	return volumeNamePattern.MatchString(name)
+}
+
+/* create a named volume with its data directory, an existing volume is an error. */
+func CreateVolume(volumeName string, labels map[string]string) (*VolumeInfo, error) {
+	if !IsVolumeName(volumeName) {
+		return nil, fmt.Errorf("invalid volume name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", volumeName)
+	}
+	if _, err := LoadVolume(volumeName); err == nil {
+		return nil, fmt.Errorf("volume %s already exists", volumeName)
+	}
+	volumeInfo := &VolumeInfo{
+		Name:       volumeName,
+		Mountpoint: fmt.Sprintf(VolumeDataUrl, volumeName),
+		CreatedAt:  time.Now().Format(TimeFormat),
+		Labels:     labels,
+	}
+	if err := os.MkdirAll(volumeInfo.Mountpoint, 0777); err != nil {
+		return nil, fmt.Errorf("create data directory of volume %s error : %v", volumeName, err)
+	}
+	if err := volumeInfo.Dump(); err != nil {
+		return nil, err
+	}
+	return volumeInfo, nil
+}
+
+func (vi *VolumeInfo) Dump() error {
+	volumeSavedUrl := fmt.Sprintf(VolumeInfoLocation, vi.Name)
+	if err := os.MkdirAll(volumeSavedUrl, 0622); err != nil {
+		return fmt.Errorf("create volume saved directory failed, %v", err)
+	}
+	volumeBytes, err := json.Marshal(vi)
+	if err != nil {
+		return fmt.Errorf("marshal volume %s information error %v", vi.Name, err)
+	}
+	if err := ioutil.WriteFile(volumeSavedUrl+ConfigName, volumeBytes, 0622); err != nil {
+		return fmt.Errorf("write volume %s information error %v", vi.Name, err)
+	}
+	return nil
+}
+
+func LoadVolume(volumeName string) (*VolumeInfo, error) {
+	if !IsVolumeName(volumeName) {
+		return nil, fmt.Errorf("invalid volume name %s", volumeName)
+	}
+	content, err := ioutil.ReadFile(fmt.Sprintf(VolumeInfoLocation, volumeName) + ConfigName)
+	if err != nil {
+		return nil, err
+	}
+	var volumeInfo VolumeInfo
+	if err := json.Unmarshal(content, &volumeInfo); err != nil {
+		return nil, fmt.Errorf("unmarshal volume content for volume %s error : %v", volumeName, err)
+	}
+	return &volumeInfo, nil
+}
+
+func ListVolumes() ([]*VolumeInfo, error) {
+	volumeSavedUrl := path.Dir(path.Clean(fmt.Sprintf(VolumeInfoLocation, "x")))
+	volumeDirs, err := ioutil.ReadDir(volumeSavedUrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var volumes []*VolumeInfo
+	for _, vd := range volumeDirs {
+		volumeInfo, err := LoadVolume(vd.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, volumeInfo)
+	}
+	return volumes, nil
+}
+
+/* remove a volume with its data. */
+func RemoveVolume(volumeName string) error {
+	volumeInfo, err := LoadVolume(volumeName)
+	if err != nil {
+		return fmt.Errorf("no such volume: %s", volumeName)
+	}
+	if err := os.RemoveAll(volumeInfo.Mountpoint); err != nil {
+		return fmt.Errorf("remove data directory of volume %s error : %v", volumeName, err)
+	}
+	return os.RemoveAll(fmt.Sprintf(VolumeInfoLocation, volumeName))
+}
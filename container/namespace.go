@@ -0,0 +1,196 @@
+package container
+
+import (
+	"fmt"
+	"github.com/vishvananda/netns"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+const (
+	NamespaceNet string = "net"
+	NamespacePid string = "pid"
+	NamespaceIpc string = "ipc"
+	NamespaceUts string = "uts"
+	/* cgroup namespace is unshared by init once it joins the cgroup, only host and private apply. */
+	NamespaceCgroup string = "cgroup"
+
+	/* modes of sharable namespaces, the container may also join namespace of another one. */
+	NamespaceModePrivate   string = "private"
+	NamespaceModeHost      string = "host"
+	NamespaceModeContainer string = "container:"
+)
+
+const (
+	/* missing in package syscall. */
+	cloneNewCgroup = 0x02000000
+	cloneNewTime   = 0x00000080
+)
+
+/*
+	namespaces joined or unshared by init process belong to the calling thread, while time namespace
+	offsets are set to the thread group leader. Keep init on the main thread from the very start.
+*/
+func init() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		runtime.LockOSThread()
+	}
+}
+
+/* clone flags of sharable namespaces, mount namespace is always private. */
+var namespaceCloneFlags = map[string]uintptr{
+	NamespaceNet: syscall.CLONE_NEWNET,
+	NamespacePid: syscall.CLONE_NEWPID,
+	NamespaceIpc: syscall.CLONE_NEWIPC,
+	NamespaceUts: syscall.CLONE_NEWUTS,
+}
+
+/*
+	parse `host`, `private` or `container:<name|id>` as mode of namespace, a referenced container is
+	resolved to its id. The private mode is returned as empty.
+*/
+func ParseNamespaceMode(nsType string, mode string) (string, error) {
+	switch {
+	case mode == "" || mode == NamespaceModePrivate:
+		return "", nil
+	case mode == NamespaceModeHost:
+		return mode, nil
+	case strings.HasPrefix(mode, NamespaceModeContainer) && nsType != NamespaceCgroup:
+		ref := strings.TrimPrefix(mode, NamespaceModeContainer)
+		target, err := LookupContainer(ref)
+		if err != nil {
+			return "", fmt.Errorf("lookup container %s to share %s namespace error : %v", ref, nsType, err)
+		}
+		if target.Status != RUNNING {
+			return "", fmt.Errorf("can not share %s namespace of container %s which is not running", nsType, ref)
+		}
+		return NamespaceModeContainer + target.Id, nil
+	}
+	if nsType == NamespaceCgroup {
+		return "", fmt.Errorf("invalid %s namespace mode %s, must be one of host and private", nsType, mode)
+	}
+	return "", fmt.Errorf("invalid %s namespace mode %s, must be one of host, private and container:<name|id>", nsType, mode)
+}
+
+/* the clone flags of init process, without namespaces shared with host or other containers. */
+func cloneFlags(namespaces map[string]string) uintptr {
+	flags := uintptr(syscall.CLONE_NEWNS)
+	for nsType, flag := range namespaceCloneFlags {
+		if namespaces[nsType] == "" {
+			flags |= flag
+		}
+	}
+	return flags
+}
+
+/* the namespace file of a container shared in `container:<id>` mode, empty for other modes. */
+func namespacePath(nsType string, mode string) (string, error) {
+	if !strings.HasPrefix(mode, NamespaceModeContainer) {
+		return "", nil
+	}
+	targetId := strings.TrimPrefix(mode, NamespaceModeContainer)
+	target, err := LoadContainerInfo(targetId)
+	if err != nil {
+		return "", err
+	}
+	/* init may be in a new pid namespace, check through proc of host rather than signals. */
+	nsPath := fmt.Sprintf("/proc/%s/ns/%s", target.Pid, nsType)
+	if _, err := os.Stat(nsPath); target.Status != RUNNING || err != nil {
+		return "", fmt.Errorf("container %s sharing %s namespace is not running", targetId, nsType)
+	}
+	return nsPath, nil
+}
+
+func setns(nsPath string) error {
+	fd, err := os.Open(nsPath)
+	if err != nil {
+		return fmt.Errorf("open namespace %s error : %v", nsPath, err)
+	}
+	defer fd.Close()
+	if err := netns.Setns(netns.NsHandle(fd.Fd()), 0); err != nil {
+		return fmt.Errorf("join namespace %s error : %v", nsPath, err)
+	}
+	return nil
+}
+
+/*
+	start init process of container. A pid namespace only applies to children of the joining
+	thread, so a shared one is joined by a dedicated thread starting the process, which is thrown
+	away afterwards by exiting without unlocking it.
+*/
+func StartInitProcess(cmd *exec.Cmd, namespaces map[string]string) error {
+	nsPath, err := namespacePath(NamespacePid, namespaces[NamespacePid])
+	if err != nil {
+		return err
+	}
+	if nsPath == "" {
+		return cmd.Start()
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := setns(nsPath); err != nil {
+			errChan <- err
+			return
+		}
+		errChan <- cmd.Start()
+	}()
+	return <-errChan
+}
+
+/*
+	join network, ipc and uts namespaces shared with other containers from init process, which is
+	locked to the thread executing user command.
+*/
+func joinNamespaces(namespaces map[string]string) error {
+	for _, nsType := range []string{NamespaceNet, NamespaceIpc, NamespaceUts} {
+		nsPath, err := namespacePath(nsType, namespaces[nsType])
+		if err != nil {
+			return err
+		}
+		if nsPath == "" {
+			continue
+		}
+		if err := setns(nsPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	unshare cgroup namespace, and time namespace when clock offsets are given, from init process
+	which is already in cgroup of container. A time namespace only applies to children of the
+	unsharing process, and the user command enters it by executing.
+*/
+func unshareNamespaces(namespaces map[string]string, timeOffsets *TimeOffsets) error {
+	var flags uintptr
+	if namespaces[NamespaceCgroup] == "" {
+		flags |= cloneNewCgroup
+	}
+	if timeOffsets != nil {
+		flags |= cloneNewTime
+	}
+	if flags == 0 {
+		return nil
+	}
+	if err := syscall.Unshare(int(flags)); err != nil {
+		return fmt.Errorf("unshare namespaces error : %v", err)
+	}
+	return nil
+}
+
+/* set clock offsets of the unshared time namespace, through proc of container. */
+func setTimeOffsets(timeOffsets *TimeOffsets) error {
+	if timeOffsets == nil {
+		return nil
+	}
+	if err := ioutil.WriteFile("/proc/self/timens_offsets", []byte(timeOffsets.String()), 0644); err != nil {
+		return fmt.Errorf("write time namespace offsets error : %v", err)
+	}
+	return nil
+}
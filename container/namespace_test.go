@@ -0,0 +1,36 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestParseNamespaceMode(t *testing.T) {
+	cases := []struct {
+		nsType string
+		mode   string
+		parsed string /* the mode parsed, or `error` if mode is refused */
+	}{
+		{NamespaceNet, "", ""},
+		{NamespaceNet, NamespaceModePrivate, ""},
+		{NamespaceNet, NamespaceModeHost, NamespaceModeHost},
+		{NamespaceCgroup, NamespaceModeHost, NamespaceModeHost},
+		{NamespaceCgroup, NamespaceModePrivate, ""},
+		{NamespacePid, "shared", "error"},
+		{NamespaceUts, "Host", "error"},
+		{NamespaceIpc, NamespaceModeContainer, "error"},
+		{NamespaceIpc, NamespaceModeContainer + "a/b", "error"},
+		{NamespaceCgroup, NamespaceModeContainer + "web", "error"},
+	}
+	for _, c := range cases {
+		parsed, err := ParseNamespaceMode(c.nsType, c.mode)
+		if c.parsed == "error" {
+			if err == nil {
+				t.Errorf("invalid %s namespace mode %q is parsed as %q", c.nsType, c.mode, parsed)
+			}
+			continue
+		}
+		if err != nil || parsed != c.parsed {
+			t.Errorf("%s namespace mode %q is parsed as %q, expect %q : %v", c.nsType, c.mode, parsed, c.parsed, err)
+		}
+	}
+}
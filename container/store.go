@@ -0,0 +1,153 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+/* a container name is a file name in the name index, so it can not hold `/` or start with `.`. */
+var containerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+func IsContainerName(name string) bool {
+	return containerNamePattern.MatchString(name)
+}
+
+/*
+	the name index is a directory of symbolic links from container name to container id, the
+	atomic creation of symbolic link keeps names unique among concurrent `run`s.
+*/
+func ReserveName(containerName string, containerId string) error {
+	if !IsContainerName(containerName) {
+		return fmt.Errorf("invalid container name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", containerName)
+	}
+	if err := os.MkdirAll(NameIndexLocation, 0622); err != nil {
+		return fmt.Errorf("create name index directory %s error : %v", NameIndexLocation, err)
+	}
+	if err := os.Symlink(containerId, path.Join(NameIndexLocation, containerName)); err != nil {
+		if os.IsExist(err) {
+			ownerId, _ := os.Readlink(path.Join(NameIndexLocation, containerName))
+			return fmt.Errorf("container name %s is already in use by container %s", containerName, ownerId)
+		}
+		return fmt.Errorf("reserve container name %s error : %v", containerName, err)
+	}
+	return nil
+}
+
+/* release a container name, only if it is still owned by given container. */
+func ReleaseName(containerName string, containerId string) error {
+	namePath := path.Join(NameIndexLocation, containerName)
+	ownerId, err := os.Readlink(namePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read name index of %s error : %v", containerName, err)
+	}
+	if ownerId != containerId {
+		return nil
+	}
+	return os.Remove(namePath)
+}
+
+/* load information of container with exactly the given id. */
+func LoadContainerInfo(containerId string) (*ContainerInfo, error) {
+	if containerId == "" || strings.Contains(containerId, "/") {
+		return nil, fmt.Errorf("invalid container id %s", containerId)
+	}
+	containerInfoFile := fmt.Sprintf(DefaultInfoLocation, containerId) + ConfigName
+	content, err := ioutil.ReadFile(containerInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	var containerInfo ContainerInfo
+	if err := json.Unmarshal(content, &containerInfo); err != nil {
+		return nil, fmt.Errorf("unmarshal container content for container %s error : %v", containerId, err)
+	}
+	return &containerInfo, nil
+}
+
+/*
+	change information of container while holding a lock on its directory, so that updates of
+	concurrent processes, e.g. status by stop and health by the supervisor, are not lost. update
+	is given the current information and reports whether it is changed and is to be written.
+*/
+func UpdateContainerInfo(containerId string, update func(*ContainerInfo) bool) error {
+	if containerId == "" || strings.Contains(containerId, "/") {
+		return fmt.Errorf("invalid container id %s", containerId)
+	}
+	containerDir := fmt.Sprintf(DefaultInfoLocation, containerId)
+	dir, err := os.Open(containerDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock container %s error : %v", containerId, err)
+	}
+	containerInfo, err := LoadContainerInfo(containerId)
+	if err != nil {
+		return err
+	}
+	if !update(containerInfo) {
+		return nil
+	}
+	return containerInfo.Dump()
+}
+
+/* list ids of every container. */
+func ListContainerIds() ([]string, error) {
+	containerSavedUrl := path.Dir(path.Clean(fmt.Sprintf(DefaultInfoLocation, "x")))
+	containerDirs, err := ioutil.ReadDir(containerSavedUrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, cd := range containerDirs {
+		if cd.IsDir() {
+			ids = append(ids, cd.Name())
+		}
+	}
+	return ids, nil
+}
+
+/*
+	find container referred by full id, name or unique prefix of id, which are tried in order as
+	docker does.
+*/
+func LookupContainer(ref string) (*ContainerInfo, error) {
+	if ref == "" || strings.Contains(ref, "/") {
+		return nil, fmt.Errorf("invalid container name or id %s", ref)
+	}
+	if containerInfo, err := LoadContainerInfo(ref); err == nil {
+		return containerInfo, nil
+	}
+	if containerId, err := os.Readlink(path.Join(NameIndexLocation, ref)); err == nil {
+		return LoadContainerInfo(containerId)
+	}
+	ids, err := ListContainerIds()
+	if err != nil {
+		return nil, fmt.Errorf("list containers error : %v", err)
+	}
+	var matched []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, ref) {
+			matched = append(matched, id)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("no such container: %s", ref)
+	case 1:
+		return LoadContainerInfo(matched[0])
+	}
+	return nil, fmt.Errorf("container id prefix %s is ambiguous, matches %d containers", ref, len(matched))
+}
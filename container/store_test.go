@@ -0,0 +1,24 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestIsContainerName(t *testing.T) {
+	cases := map[string]bool{
+		"web":       true,
+		"web-1.2_a": true,
+		"0db":       true,
+		"":          false,
+		".hidden":   false,
+		"-web":      false,
+		"../../x":   false,
+		"a/b":       false,
+		"a b":       false,
+	}
+	for name, valid := range cases {
+		if IsContainerName(name) != valid {
+			t.Errorf("name %q is valid is %v, expect %v", name, !valid, valid)
+		}
+	}
+}
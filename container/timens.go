@@ -0,0 +1,61 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* the offsets of clocks in time namespace of container, relative to host. */
+type TimeOffsets struct {
+	Monotonic time.Duration `json:"monotonic"` /* the offset of CLOCK_MONOTONIC */
+	Boottime  time.Duration `json:"boottime"`  /* the offset of CLOCK_BOOTTIME */
+}
+
+/*
+	parse `monotonic=<duration>,boottime=<duration>`, where either clock may be omitted and a
+	duration is as `1h`, `-30m` or plain seconds.
+*/
+func ParseTimeOffsets(offsetStr string) (*TimeOffsets, error) {
+	if offsetStr == "" {
+		return nil, nil
+	}
+	offsets := &TimeOffsets{}
+	for _, offset := range strings.Split(offsetStr, ",") {
+		kv := strings.SplitN(offset, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid time offset %s, must be in form of clock=duration", offset)
+		}
+		duration, err := time.ParseDuration(kv[1])
+		if err != nil {
+			if duration, err = time.ParseDuration(kv[1] + "s"); err != nil {
+				return nil, fmt.Errorf("invalid duration of time offset %s", offset)
+			}
+		}
+		switch kv[0] {
+		case "monotonic":
+			offsets.Monotonic = duration
+		case "boottime":
+			offsets.Boottime = duration
+		default:
+			return nil, fmt.Errorf("invalid clock %s of time offset, must be monotonic or boottime", kv[0])
+		}
+	}
+	return offsets, nil
+}
+
+/* the content written to /proc/<pid>/timens_offsets, in lines of `clock secs nanosecs`. */
+func (t *TimeOffsets) String() string {
+	return formatTimeOffset("monotonic", t.Monotonic) + formatTimeOffset("boottime", t.Boottime)
+}
+
+/* nanoseconds must not be negative, a negative offset borrows one second. */
+func formatTimeOffset(clock string, offset time.Duration) string {
+	secs := int64(offset / time.Second)
+	nsecs := int64(offset % time.Second)
+	if nsecs < 0 {
+		secs--
+		nsecs += int64(time.Second)
+	}
+	return fmt.Sprintf("%s %d %d\n", clock, secs, nsecs)
+}
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeOffsets(t *testing.T) {
+	cases := map[string]*TimeOffsets{
+		"":                          nil,
+		"monotonic=1h":              {Monotonic: time.Hour},
+		"boottime=-30m":             {Boottime: -30 * time.Minute},
+		"monotonic=90,boottime=1.5": {Monotonic: 90 * time.Second, Boottime: 1500 * time.Millisecond},
+		"boottime=-2":               {Boottime: -2 * time.Second},
+	}
+	for offsetStr, expected := range cases {
+		offsets, err := ParseTimeOffsets(offsetStr)
+		if err != nil {
+			t.Errorf("time offset %q is not parsed : %v", offsetStr, err)
+			continue
+		}
+		if (offsets == nil) != (expected == nil) || offsets != nil && *offsets != *expected {
+			t.Errorf("time offset %q is parsed as %v, expect %v", offsetStr, offsets, expected)
+		}
+	}
+	for _, offsetStr := range []string{"monotonic", "realtime=1h", "monotonic=1y", "monotonic=1h,", "=1h"} {
+		if offsets, err := ParseTimeOffsets(offsetStr); err == nil {
+			t.Errorf("invalid time offset %q is parsed as %v", offsetStr, offsets)
+		}
+	}
+}
+
+func TestTimeOffsetsString(t *testing.T) {
+	cases := map[TimeOffsets]string{
+		{}: "monotonic 0 0\nboottime 0 0\n",
+		{Monotonic: 90 * time.Second, Boottime: 1500 * time.Millisecond}: "monotonic 90 0\nboottime 1 500000000\n",
+		/* nanoseconds are kept positive by borrowing a second. */
+		{Boottime: -1500 * time.Millisecond}: "monotonic 0 0\nboottime -2 500000000\n",
+	}
+	for offsets, expected := range cases {
+		if content := offsets.String(); content != expected {
+			t.Errorf("time offsets %+v are written as %q, expect %q", offsets, content, expected)
+		}
+	}
+}
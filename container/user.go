@@ -0,0 +1,122 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type ExecUser struct {
+	Uid    int   /* user id of process */
+	Gid    int   /* primary group id of process */
+	Groups []int /* supplementary group ids of process */
+}
+
+/*
+	resolve user specification in form of `user[:group]` against /etc/passwd and /etc/group
+	under rootfs, both user and group can be either a name or a numeric id.
+*/
+func LookupUser(rootfs string, userSpec string) (*ExecUser, error) {
+	userStr, groupStr := userSpec, ""
+	if i := strings.Index(userSpec, ":"); i >= 0 {
+		userStr, groupStr = userSpec[:i], userSpec[i+1:]
+	}
+	passwd, _ := readColonFile(filepath.Join(rootfs, "etc/passwd"))
+	groups, _ := readColonFile(filepath.Join(rootfs, "etc/group"))
+
+	execUser := &ExecUser{}
+	userName := ""
+	if uid, err := strconv.Atoi(userStr); err == nil {
+		execUser.Uid = uid
+		for _, entry := range passwd {
+			if len(entry) >= 4 && entry[2] == userStr {
+				userName = entry[0]
+				execUser.Gid, _ = strconv.Atoi(entry[3])
+				break
+			}
+		}
+	} else {
+		found := false
+		for _, entry := range passwd {
+			if len(entry) >= 4 && entry[0] == userStr {
+				userName = entry[0]
+				execUser.Uid, _ = strconv.Atoi(entry[2])
+				execUser.Gid, _ = strconv.Atoi(entry[3])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unable to find user %s in container", userStr)
+		}
+	}
+
+	if groupStr != "" {
+		if gid, err := strconv.Atoi(groupStr); err == nil {
+			execUser.Gid = gid
+		} else {
+			found := false
+			for _, entry := range groups {
+				if len(entry) >= 3 && entry[0] == groupStr {
+					execUser.Gid, _ = strconv.Atoi(entry[2])
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unable to find group %s in container", groupStr)
+			}
+		}
+	}
+
+	/* supplementary groups are the ones listing the user as a member. */
+	execUser.Groups = []int{execUser.Gid}
+	if userName != "" {
+		for _, entry := range groups {
+			if len(entry) < 4 {
+				continue
+			}
+			for _, member := range strings.Split(entry[3], ",") {
+				if member != userName {
+					continue
+				}
+				if gid, err := strconv.Atoi(entry[2]); err == nil && gid != execUser.Gid {
+					execUser.Groups = append(execUser.Groups, gid)
+				}
+			}
+		}
+	}
+	return execUser, nil
+}
+
+func readColonFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, scanner.Err()
+}
+
+/* the user name of uid according to /etc/passwd under rootfs, the uid itself if not found. */
+func LookupUserName(rootfs string, uid string) string {
+	passwd, _ := readColonFile(filepath.Join(rootfs, "etc/passwd"))
+	for _, entry := range passwd {
+		if len(entry) >= 3 && entry[2] == uid {
+			return entry[0]
+		}
+	}
+	return uid
+}
@@ -3,22 +3,103 @@ package container
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/storage"
 	"os"
-	"os/exec"
 	"strings"
+	"syscall"
 )
 
-func NewWorkSpace(volumeStr string, imageName string, containerName string) {
-	CreateReadOnlyLayer(imageName)
-	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+/*
+	create root filesystem of container on the root filesystem of its image by storage driver, and
+	mount volume into it. The writable layer is limited to the size given in storage options.
+*/
+func NewWorkSpace(volumeStr string, imageDir string, containerId string, driverName string,
+	storageOpts map[string]string) error {
+	driver, err := storage.New(driverName)
+	if err != nil {
+		return err
+	}
+	if sizeStr, ok := storageOpts[storage.StorageOptSize]; ok {
+		size, err := storage.ParseSize(sizeStr)
+		if err != nil {
+			return err
+		}
+		if err := storage.CreateQuota(driver, containerId, size); err != nil {
+			return err
+		}
+	}
+	if err := driver.Create(containerId, imageDir); err != nil {
+		storage.RemoveQuota(driver, containerId)
+		return fmt.Errorf("create layer of container %s error : %v", containerId, err)
+	}
+	if err := driver.Mount(containerId, imageDir, fmt.Sprintf(MntUrl, containerId)); err != nil {
+		storage.RemoveQuota(driver, containerId)
+		driver.Remove(containerId)
+		return err
+	}
+	createMissingVolume(volumeStr)
 	valid, volumeUrls := ExtractVolumeParameter(volumeStr)
 	if valid {
-		MountVolume(volumeUrls, containerName)
+		MountVolume(volumeUrls, containerId)
 	}
+	return nil
 }
 
-func MountVolume(volumeUrls []string, containerName string) {
+/* describe the root filesystem and volume mounts of container. */
+func GetMounts(volumeStr string, imageDir string, containerId string, driverName string) []Mount {
+	mounts := []Mount{
+		{
+			Type:        driverName,
+			Source:      imageDir,
+			Destination: "/",
+		},
+	}
+	if valid, volumeUrls := ExtractVolumeParameter(volumeStr); valid {
+		mounts = append(mounts, Mount{
+			Type:        "bind",
+			Source:      volumeUrls[0],
+			Destination: volumeUrls[1],
+		})
+	}
+	return mounts
+}
+
+/* the storage driver holding root filesystem of container. */
+func (ci *ContainerInfo) Storage() (storage.Driver, error) {
+	if ci.Driver == "" {
+		return storage.New(storage.DefaultDriver)
+	}
+	return storage.New(ci.Driver)
+}
+
+/* the root filesystem of image under writable layer, recorded as source of root mount. */
+func (ci *ContainerInfo) ImageDir() string {
+	for _, mount := range ci.Mounts {
+		if mount.Destination == "/" {
+			return mount.Source
+		}
+	}
+	return RootUrl + "/" + ci.Image
+}
+
+/* usage of writable layer against its size limit, nil if it is not limited. */
+func (ci *ContainerInfo) StorageUsage() *storage.QuotaUsage {
+	if ci.StorageOpt[storage.StorageOptSize] == "" {
+		return nil
+	}
+	driver, err := ci.Storage()
+	if err != nil {
+		return nil
+	}
+	usage, err := storage.GetQuotaUsage(driver, ci.Id)
+	if err != nil {
+		log.Warnf("Get storage usage of container %s error : %v", ci.Id, err)
+	}
+	return usage
+}
+
+/* volumes are bind mounted, independent of storage driver. */
+func MountVolume(volumeUrls []string, containerId string) {
 	hostUrl := volumeUrls[0]
 	exist, _ := PathExists(hostUrl)
 	if !exist {
@@ -26,44 +107,42 @@ func MountVolume(volumeUrls []string, containerName string) {
 			log.Errorf("Mkdir host volume url %s error : %v", hostUrl, err)
 		}
 	}
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
+	mntUrl := fmt.Sprintf(MntUrl, containerId)
 	containerUrl := mntUrl + "/" +volumeUrls[1]
-	if err := os.Mkdir(containerUrl, 0777); err != nil {
+	if err := os.MkdirAll(containerUrl, 0777); err != nil {
 		log.Errorf("Mkdir container volume url %s error : %v", containerUrl, err)
 	}
-	dirs := "dirs=" + hostUrl
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := syscall.Mount(hostUrl, containerUrl, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
 		log.Errorf("Mount volume error : %v", err)
 	}
 }
 
+/* the source of volume is either a host path or a volume name, the latter is resolved to its data directory. */
 func ExtractVolumeParameter(volumeStr string) (bool, []string) {
 	var volumeUrls []string
 	volumeUrls = strings.Split(volumeStr, ":")
 	if volumeUrls != nil && len(volumeUrls) == 2 && volumeUrls[0] != "" && volumeUrls[1] != "" {
+		if IsVolumeName(volumeUrls[0]) {
+			if volumeInfo, err := LoadVolume(volumeUrls[0]); err == nil {
+				volumeUrls[0] = volumeInfo.Mountpoint
+			}
+		}
 		return true, volumeUrls
 	}
 	return false, volumeUrls
 }
 
-func CreateReadOnlyLayer(imageName string) {
-	imageUrl := RootUrl + "/" +  imageName + "/"
-	imageTarUrl := RootUrl + "/" +  imageName + ".tar"
-	exist, err := PathExists(imageUrl)
-	if err != nil {
-		log.Infof("Fail to judge whether directory %v exists: %v", imageUrl, err)
+/* a volume name not created yet is created on use, as docker does. */
+func createMissingVolume(volumeStr string) {
+	volumeUrls := strings.Split(volumeStr, ":")
+	if len(volumeUrls) != 2 || !IsVolumeName(volumeUrls[0]) {
+		return
 	}
-	if exist == false {
-		if err := os.MkdirAll(imageUrl, 0622); err != nil {
-			log.Errorf("Create directory %s error : %v", imageUrl, err)
-			return
-		}
-		if _, err := exec.Command("tar", "-xvf", imageTarUrl, "-C", imageUrl).CombinedOutput(); err != nil {
-			log.Errorf("Fail to untar %v", imageTarUrl)
-		}
+	if _, err := LoadVolume(volumeUrls[0]); err == nil {
+		return
+	}
+	if _, err := CreateVolume(volumeUrls[0], nil); err != nil {
+		log.Errorf("Create volume %s error : %v", volumeUrls[0], err)
 	}
 }
 
@@ -78,78 +157,32 @@ func PathExists(url string) (bool, error) {
 	return false, err
 }
 
-func CreateWriteLayer(containerName string) {
-	writeLayerUrl := fmt.Sprintf(WriteLayer, containerName)
-	exist, _ := PathExists(writeLayerUrl)
-	if exist == true {
-		if err := os.RemoveAll(writeLayerUrl); err != nil {
-			log.Errorf("Remove exists writeLayer %s error : %v", writeLayerUrl, err)
-			return
+func DeleteWorkSpace(volumeStr string, containerId string, driverName string) {
+	mntUrl := fmt.Sprintf(MntUrl, containerId)
+	if valid, volumeUrls := ExtractVolumeParameter(volumeStr); valid {
+		containerUrl := mntUrl + "/" + volumeUrls[1]
+		if err := syscall.Unmount(containerUrl, syscall.MNT_DETACH); err != nil {
+			log.Errorf("Unmount volume %s error : %v", containerUrl, err)
 		}
 	}
-	if err := os.MkdirAll(writeLayerUrl, 0777); err != nil {
-		log.Errorf("Fail to create directory %s : %v", writeLayerUrl, err)
+	if driverName == "" {
+		driverName = storage.DefaultDriver
 	}
-}
-
-func CreateMountPoint(containerName string, imageName string) {
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	if err := os.MkdirAll(mntUrl, 0777); err != nil {
-		log.Errorf("fail to create directory %s : %v", mntUrl, err)
+	driver, err := storage.New(driverName)
+	if err != nil {
+		log.Errorf("Get storage driver of container %s error : %v", containerId, err)
 		return
 	}
-	tmpWriteLayer := fmt.Sprintf(WriteLayer, containerName)
-	tmpImageUrl := RootUrl + "/" + imageName
-	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageUrl
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Errorf("mount readonlyLayer and writeLayer error: %v", err)
-	}
-}
-
-func DeleteWorkSpace(volumeStr string, containerName string) {
-	valid, volumeUrls := ExtractVolumeParameter(volumeStr)
-	if valid {
-		DeleteMountPointWithVolume(volumeUrls, containerName)
-	} else {
-		DeleteMountPoint(containerName)
-	}
-	DeleteWriteLayer(containerName)
-}
-
-func DeleteMountPointWithVolume(volumeUrls []string, containerName string) {
-	/* unmount container volume. */
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	containerUrl := mntUrl + volumeUrls[1]
-	if _, err := exec.Command("umount", containerUrl).CombinedOutput(); err != nil {
-		log.Errorf("Unmount volume %s error : %v", containerUrl, err)
-	}
-	/* unmount total mount point volume. */
-	if _, err := exec.Command("umount", mntUrl).CombinedOutput(); err != nil {
-		log.Errorf("Unmount volume %s error : %v", mntUrl, err)
-	}
-	/* remove total mount point directory. */
-	if err := os.RemoveAll(mntUrl); err != nil {
-		log.Errorf("Remove volume %s error : %v", mntUrl, err)
-	}
-}
-
-func DeleteMountPoint(containerName string) {
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	_, err := exec.Command("umount", mntUrl).CombinedOutput()
-	if err != nil {
-		log.Errorf("Umount volume %s error: %v", mntUrl, err)
+	if err := driver.Unmount(mntUrl); err != nil {
+		log.Errorf("Unmount root filesystem of container %s error : %v", containerId, err)
 	}
 	if err := os.RemoveAll(mntUrl); err != nil {
 		log.Errorf("Remove mount point %s error: %v", mntUrl, err)
 	}
-}
-
-func DeleteWriteLayer(containerName string) {
-	writeLayerUrl := fmt.Sprintf(WriteLayer, containerName)
-	if err := os.RemoveAll(writeLayerUrl); err != nil {
-		log.Errorf("remove writeLayer %s error: %v", writeLayerUrl, err)
+	if err := storage.RemoveQuota(driver, containerId); err != nil {
+		log.Errorf("Remove size limit of container %s error: %v", containerId, err)
+	}
+	if err := driver.Remove(containerId); err != nil {
+		log.Errorf("Remove layer of container %s error: %v", containerId, err)
 	}
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+)
+
+/*
+	print changes of container to the files of its image, one `A|C|D path` per line, or as a JSON
+	array of changes in form of docker API, whose kind is 0 for changed, 1 for added and 2 for
+	deleted.
+*/
+func DiffContainer(containerName string, format string) {
+	if format != "" && format != "json" {
+		log.Errorf("Unsupported format %s, only json is supported", format)
+		return
+	}
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		log.Errorf("Get container name %s error : %v", containerName, err)
+		return
+	}
+	driver, err := containerInfo.Storage()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	changes, err := driver.Diff(containerInfo.Id, containerInfo.ImageDir())
+	if err != nil {
+		log.Errorf("Get changes of container %s error : %v", containerName, err)
+		return
+	}
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		if changes == nil {
+			fmt.Println("[]")
+			return
+		}
+		if err := encoder.Encode(changes); err != nil {
+			log.Errorf("Marshal changes of container %s error : %v", containerName, err)
+		}
+		return
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+}
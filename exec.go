@@ -1,80 +1,316 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 	"github.com/qqzeng/tinydocker/container"
 	log "github.com/Sirupsen/logrus"
 	_ "github.com/qqzeng/tinydocker/nsenter"
 )
-/* TODO: why are there two `sh` process? */
-func ExecContainer(containerName string, comArray []string) {
-	cPid, err := getContainerPidByName(containerName)
-	if err != nil {
-		log.Error("Finding pid of container process error: %v", err)
-		return
-	}
+
+/*
+	execute command in the namespaces and cgroups of a running container, the exit code of
+	command is returned. The re-executed `/proc/self/exe exec -- command...` is captured by
+	the nsenter constructor before Go runtime starts, which enters the container and forks
+	the command with exact argv.
+*/
+func ExecContainer(containerName string, comArray []string, tty bool, detach bool,
+	envSlice []string, workDir string, userSpec string) int {
 	containerInfo, err := getContainerByName(containerName)
 	if err != nil {
 		log.Errorf("Get container name %s error : %v", containerName, err)
-		return
+		return 1
 	}
 	if containerInfo.Status != container.RUNNING {
 		log.Errorf("Can only exec running container name")
-		return
+		return 1
 	}
+	cPid := containerInfo.Pid
 	log.Infof("The pid of container process is %s", cPid)
-	if comArray == nil || len(comArray) == 0 {
-		log.Error("Invalid command array for executing : %v", err)
-		return
+	if len(comArray) == 0 {
+		log.Errorf("Invalid command array for executing")
+		return 1
 	}
-	comStr := strings.Join(comArray, " ")
-	log.Infof("The executing command of container process is %s", comStr)
+	log.Infof("The executing command of container process is %s", strings.Join(comArray, " "))
 
-	command := exec.Command("/proc/self/exe", "exec")
-	/* NOTE: this sounds like making no sense.*/
-	command.Stdin = os.Stdin
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
+	command := newExecCommand(cPid, comArray, envSlice)
+	if workDir != "" {
+		command.Env = append(command.Env, ENV_EXEC_WORKDIR+"="+workDir)
+	}
+	if userSpec != "" {
+		execUser, err := container.LookupUser(fmt.Sprintf("/proc/%s/root", cPid), userSpec)
+		if err != nil {
+			log.Errorf("Lookup user %s error : %v", userSpec, err)
+			return 1
+		}
+		var groups []string
+		for _, gid := range execUser.Groups {
+			groups = append(groups, strconv.Itoa(gid))
+		}
+		command.Env = append(command.Env,
+			ENV_EXEC_UID+"="+strconv.Itoa(execUser.Uid),
+			ENV_EXEC_GID+"="+strconv.Itoa(execUser.Gid),
+			ENV_EXEC_GROUPS+"="+strings.Join(groups, ","))
+	}
 
-	err1 := os.Setenv(ENV_EXEC_PID, cPid)
-	err2 := os.Setenv(ENV_EXEC_COMMAND, comStr)
-	if err1 != nil || err2 != nil {
-		log.Errorf("Setting environment command error : %v", err)
+	/* record exec session, nsenter reports pid and exit code of command through status file. */
+	execInfo := &container.ExecInfo{
+		Id:        container.NewRandomId(),
+		Command:   strings.Join(comArray, " "),
+		StartTime: time.Now().Format("2006-01-02 15:04:05"),
+		Running:   true,
+		Tty:       tty,
+		Detached:  detach,
+	}
+	statusFile, err := container.OpenExecStatusFile(containerInfo.Id, execInfo.Id)
+	if err != nil {
+		log.Errorf("Create exec session status file error : %v", err)
+		return 1
+	}
+	defer statusFile.Close()
+	command.ExtraFiles = []*os.File{statusFile}
+	command.Env = append(command.Env, ENV_EXEC_STATUS_FD+"=3")
+	recordSession := func() {
+		execInfo.Pid = waitCommandPid(statusFile.Name(), command.Process.Pid)
+		if err := execInfo.Dump(containerInfo.Id); err != nil {
+			log.Errorf("Record exec session error : %v", err)
+		}
 	}
 
-	containerEnvs := getEnvsByPid(cPid)
-	command.Env = append(os.Environ(), containerEnvs...)
+	if detach {
+		/* leave stdio to /dev/null and detach from our session. */
+		command.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := command.Start(); err != nil {
+			log.Errorf("Start command error : %v", err)
+			return 1
+		}
+		recordSession()
+		log.Infof("Pid of detached exec process is %s", execInfo.Pid)
+		fmt.Fprintln(os.Stdout, execInfo.Id)
+		command.Process.Release()
+		return 0
+	}
+	if tty {
+		err = runWithTerminal(command, recordSession)
+	} else {
+		command.Stdin = os.Stdin
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+		if err = command.Start(); err == nil {
+			recordSession()
+			err = command.Wait()
+		}
+	}
+	exitCode := exitCodeOf(err)
+	if command.Process != nil {
+		if err := execInfo.Finish(containerInfo.Id, exitCode); err != nil {
+			log.Errorf("Record exit code of exec session error : %v", err)
+		}
+	}
+	return exitCode
+}
+
+/*
+	the pid of command which nsenter forks and reports through status file, so that signals of
+	`exec kill` go to command instead of nsenter waiting for it. nsenter failing to enter the
+	container exits without report, and then its own pid is returned.
+*/
+func waitCommandPid(statusFile string, nsenterPid int) string {
+	for {
+		/* nsenter is left a zombie until we wait for it, read status once more after it exits. */
+		exited := container.ProcessStartTime(nsenterPid) == ""
+		if pid, _, _ := container.ReadExecStatus(statusFile); pid != "" {
+			return pid
+		}
+		if exited {
+			return strconv.Itoa(nsenterPid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
 
-	if err = command.Run(); err != nil {
-		log.Errorf("Run command error : %v", err)
+/* list exec sessions of a container. */
+func ListExecSessions(containerName string) {
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		log.Errorf("Get container name %s error : %v", containerName, err)
+		return
+	}
+	sessions, err := container.LoadExecSessions(containerInfo.Id)
+	if err != nil {
+		log.Errorf("Load exec sessions of container %s error : %v", containerName, err)
+		return
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(wr, "ID\tPID\tSTATUS\tEXITCODE\tSTARTTIME\tCOMMAND\n")
+	for _, item := range sessions {
+		status, exitCode := "running", ""
+		if !item.Running {
+			status, exitCode = "exited", strconv.Itoa(item.ExitCode)
+		}
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			item.Id[:12],
+			item.Pid,
+			status,
+			exitCode,
+			item.StartTime,
+			item.Command,
+		)
+	}
+	if err := wr.Flush(); err != nil {
+		log.Errorf("Flush exec session information to stdout error : %v", err)
 	}
 }
 
-func getContainerPidByName(containerName string) (string, error) {
-	if containerName == "" {
-		return "", fmt.Errorf("invalid container name %s", containerName)
+/* terminate the command of a running exec session, which is given by id or unique id prefix. */
+func KillExecSession(containerName string, execId string) {
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		log.Errorf("Get container name %s error : %v", containerName, err)
+		return
 	}
-	containerSavedDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	containerInfoFileDir := containerSavedDir + container.ConfigName
-	clf, err := os.Open(containerInfoFileDir)
+	sessions, err := container.LoadExecSessions(containerInfo.Id)
 	if err != nil {
-		return "", fmt.Errorf("open container saved file for container %s error : %v", containerName, err)
+		log.Errorf("Load exec sessions of container %s error : %v", containerName, err)
+		return
 	}
-	containerContent, err := ioutil.ReadAll(clf)
+	var target *container.ExecInfo
+	for _, item := range sessions {
+		if !strings.HasPrefix(item.Id, execId) {
+			continue
+		}
+		if target != nil {
+			log.Errorf("Exec session id prefix %s is ambiguous", execId)
+			return
+		}
+		target = item
+	}
+	if target == nil {
+		log.Errorf("Exec session %s not found in container %s", execId, containerName)
+		return
+	}
+	if !target.Running {
+		log.Errorf("Exec session %s is not running", execId)
+		return
+	}
+	pidInt, err := strconv.Atoi(target.Pid)
 	if err != nil {
-		return "", fmt.Errorf("read container saved file for container %s error : %v", containerName, err)
+		log.Errorf("Invalid exec session pid %s : %v", target.Pid, err)
+		return
 	}
-	var containerInfo container.ContainerInfo
-	err = json.Unmarshal(containerContent, &containerInfo)
+	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
+		log.Errorf("Kill exec session %s error : %v", execId, err)
+	}
+}
+
+/* a command entering the namespaces and cgroups of container process with its environment. */
+func newExecCommand(cPid string, comArray []string, envSlice []string) *exec.Cmd {
+	command := exec.Command("/proc/self/exe", append([]string{"exec", "--"}, comArray...)...)
+	command.Env = append(mergeEnvs(getEnvsByPid(cPid), envSlice), ENV_EXEC_PID+"="+cPid)
+	return command
+}
+
+/* run command attached to a newly allocated pseudo terminal, which is bridged to our stdio. */
+func runWithTerminal(command *exec.Cmd, started func()) error {
+	master, slavePath, err := container.NewPty()
 	if err != nil {
-		return "", fmt.Errorf("unmarshal container content for container %s error : %v", containerName, err)
+		return err
 	}
-	return string(containerInfo.Pid), nil
+	defer master.Close()
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("open pty slave %s error : %v", slavePath, err)
+	}
+	command.Stdin = slave
+	command.Stdout = slave
+	command.Stderr = slave
+	command.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	if container.IsTerminal(os.Stdin.Fd()) {
+		container.ResizeTerminal(os.Stdin.Fd(), master.Fd())
+		restore, err := container.SetRawTerminal(os.Stdin.Fd())
+		if err != nil {
+			slave.Close()
+			return err
+		}
+		defer restore()
+		winchChan := make(chan os.Signal, 1)
+		signal.Notify(winchChan, syscall.SIGWINCH)
+		defer signal.Stop(winchChan)
+		go func() {
+			for range winchChan {
+				container.ResizeTerminal(os.Stdin.Fd(), master.Fd())
+			}
+		}()
+	}
+	err = command.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+	started()
+	go io.Copy(master, os.Stdin)
+	outputDone := make(chan struct{})
+	go func() {
+		/* reading master ends with EIO once every holder of slave end exits. */
+		io.Copy(os.Stdout, master)
+		close(outputDone)
+	}()
+	err = command.Wait()
+	<-outputDone
+	return err
+}
+
+/* the exit code of a finished command, following the shell convention for signals. */
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	log.Errorf("Run command error : %v", err)
+	return 126
+}
+
+/* override environment variables of container with `KEY=VALUE`, a bare `KEY` takes value of ours. */
+func mergeEnvs(envs []string, overrides []string) []string {
+	var merged []string
+	index := map[string]int{}
+	for _, env := range append(envs, overrides...) {
+		if env == "" {
+			continue
+		}
+		key := env
+		if i := strings.Index(env, "="); i >= 0 {
+			key = env[:i]
+		} else if value, ok := os.LookupEnv(env); ok {
+			env = key + "=" + value
+		} else {
+			continue
+		}
+		if i, ok := index[key]; ok {
+			merged[i] = env
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, env)
+	}
+	return merged
 }
 
 func getEnvsByPid(pid string) []string {
@@ -86,4 +322,4 @@ func getEnvsByPid(pid string) []string {
 	}
 	envs := strings.Split(string(envBytes), "\u0000")
 	return envs
-}
\ No newline at end of file
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/qqzeng/tinydocker/container"
+	"github.com/qqzeng/tinydocker/image"
+	"github.com/qqzeng/tinydocker/storage"
+	"io"
+	"os"
+)
+
+/*
+	export the merged root filesystem of container as a tarball to output, to stdout if output is
+	empty. Volumes mounted in container are left out.
+*/
+func ExportContainer(containerName string, output string) error {
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container name %s error : %v", containerName, err)
+	}
+	mntUrl := fmt.Sprintf(container.MntUrl, containerInfo.Id)
+	if _, err := os.Stat(mntUrl); err != nil {
+		return fmt.Errorf("root filesystem of container %s is not mounted : %v", containerName, err)
+	}
+	err = writeOutput(output, func(writer io.Writer) error {
+		return storage.WriteTree(writer, mntUrl)
+	})
+	if err != nil {
+		return fmt.Errorf("export container %s error : %v", containerName, err)
+	}
+	return nil
+}
+
+/*
+	create an image of a single layer from a root filesystem tarball, which may be gzip or zstd
+	compressed, read from stdin if source is `-`. The image is tagged by imageName unless it is
+	empty, and its config is made of Dockerfile instructions of changes.
+*/
+func ImportImage(source string, imageName string, changes []string, message string) error {
+	var ref image.Reference
+	if imageName != "" {
+		var err error
+		if ref, err = image.ParseReference(imageName); err != nil {
+			return err
+		}
+	}
+	img := image.NewImage()
+	for _, change := range changes {
+		if err := img.Config.ApplyChange(change); err != nil {
+			return err
+		}
+	}
+	var reader io.Reader = os.Stdin
+	if source != "-" {
+		file, err := os.Open(source)
+		if err != nil {
+			return fmt.Errorf("open %s error : %v", source, err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+	diffID, err := store.ImportLayer(reader)
+	if err != nil {
+		return fmt.Errorf("import %s error : %v", source, err)
+	}
+	/* extract layer now, so that a broken tarball is refused instead of failing run. */
+	if _, err := store.ApplyLayer("", diffID); err != nil {
+		return fmt.Errorf("import %s error : %v", source, err)
+	}
+	img.RootFS.DiffIDs = []image.Digest{diffID}
+	img.History = []image.History{{
+		Created:   img.Created,
+		CreatedBy: "import " + source,
+		Comment:   message,
+	}}
+	if _, err := store.CreateImage(img); err != nil {
+		return fmt.Errorf("create image error : %v", err)
+	}
+	if imageName != "" {
+		if err := store.Tag(ref, img.ID); err != nil {
+			return fmt.Errorf("tag image %s error : %v", ref, err)
+		}
+	}
+	fmt.Println(img.ID)
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/cgroups"
+	"github.com/qqzeng/tinydocker/container"
+	"github.com/qqzeng/tinydocker/network"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/* runs health checks of a container in its supervisor, until the init process exits. */
+type healthMonitor struct {
+	containerId string
+	config      *container.HealthConfig
+	startedAt   time.Time
+	stop        chan struct{}
+	done        chan struct{}
+	mu          sync.Mutex
+	restart     bool /* whether the init process was killed to be restarted */
+}
+
+func startHealthMonitor(containerId string, config *container.HealthConfig) *healthMonitor {
+	m := &healthMonitor{
+		containerId: containerId,
+		config:      config,
+		startedAt:   time.Now(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+/* stop checking and report whether the container should be restarted. */
+func (m *healthMonitor) Stop() bool {
+	close(m.stop)
+	<-m.done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.restart
+}
+
+func (m *healthMonitor) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *healthMonitor) check() {
+	containerInfo, err := container.LoadContainerInfo(m.containerId)
+	if err != nil || containerInfo.Status != container.RUNNING {
+		return
+	}
+	result := runHealthCheck(containerInfo.Pid, m.config)
+	/*
+		only health is written, on information reloaded under lock, as the container may be
+		stopped or removed while checking.
+	*/
+	turnsUnhealthy := false
+	err = container.UpdateContainerInfo(m.containerId, func(current *container.ContainerInfo) bool {
+		if current.Status != container.RUNNING || current.Pid != containerInfo.Pid {
+			return false
+		}
+		if current.Health == nil {
+			current.Health = &container.Health{Status: container.HealthStarting}
+		}
+		inStartPeriod := time.Since(m.startedAt) < m.config.StartPeriod
+		turnsUnhealthy = current.Health.Record(result, m.config, inStartPeriod)
+		return true
+	})
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Record health of container %s error : %v", m.containerId, err)
+		}
+		return
+	}
+	if !turnsUnhealthy || m.config.OnFailure == container.HealthActionNone {
+		return
+	}
+	log.Warnf("Container %s is unhealthy, take action %s", m.containerId, m.config.OnFailure)
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	m.restart = m.config.OnFailure == container.HealthActionRestart
+	m.mu.Unlock()
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		log.Errorf("Kill unhealthy container %s error : %v", m.containerId, err)
+	}
+}
+
+/* run check command by `sh -c` in container through the exec path, killed once timed out. */
+func runHealthCheck(cPid string, config *container.HealthConfig) *container.HealthResult {
+	result := &container.HealthResult{Start: time.Now().Format(time.RFC3339Nano)}
+	var output bytes.Buffer
+	command := newExecCommand(cPid, []string{"sh", "-c", config.Command}, nil)
+	command.Stdout = &output
+	command.Stderr = &output
+	/* the command is forked in container pid namespace, kill the whole group on timeout. */
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := command.Start(); err != nil {
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("start health check error : %v", err)
+		result.End = time.Now().Format(time.RFC3339Nano)
+		return result
+	}
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- command.Wait()
+	}()
+	select {
+	case err := <-waitDone:
+		result.ExitCode = exitCodeOf(err)
+		result.Output = output.String()
+	case <-time.After(config.Timeout):
+		syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+		<-waitDone
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("health check exceeded timeout (%v)", config.Timeout)
+	}
+	if len(result.Output) > container.MaxHealthOutputLength {
+		result.Output = result.Output[:container.MaxHealthOutputLength]
+	}
+	result.End = time.Now().Format(time.RFC3339Nano)
+	return result
+}
+
+/*
+	wait for the init process of a detached container, restart it when killed for being unhealthy,
+	and record its exit otherwise.
+*/
+func superviseContainer(parent *exec.Cmd, containerId string, comArray []string,
+	cgroupManager *cgroups.CgroupManager, nw string) {
+	for {
+		containerInfo, err := container.LoadContainerInfo(containerId)
+		if err != nil || containerInfo.Healthcheck == nil {
+			waitContainerExit(parent, containerId)
+			return
+		}
+		monitor := startHealthMonitor(containerId, containerInfo.Healthcheck)
+		exitCode := exitCodeOf(parent.Wait())
+		if !monitor.Stop() {
+			recordContainerExit(containerId, exitCode)
+			return
+		}
+		log.Infof("Restart unhealthy container %s", containerId)
+		if parent = restartContainer(containerId, comArray, cgroupManager, nw); parent == nil {
+			recordContainerExit(containerId, exitCode)
+			return
+		}
+	}
+}
+
+/* start a new init process on the workspace of container. */
+func restartContainer(containerId string, comArray []string, cgroupManager *cgroups.CgroupManager,
+	nw string) *exec.Cmd {
+	containerInfo, err := container.LoadContainerInfo(containerId)
+	if err != nil || containerInfo.Status != container.RUNNING {
+		/* removed or stopped meanwhile. */
+		return nil
+	}
+	parent, wp := container.NewInitProcess(false, containerId, containerInfo.Env, containerInfo.Namespaces)
+	if parent == nil {
+		log.Errorf("New init process of container %s error", containerId)
+		return nil
+	}
+	if err := container.StartInitProcess(parent, containerInfo.Namespaces); err != nil {
+		log.Errorf("Restart container %s error : %v", containerId, err)
+		return nil
+	}
+	containerInfo.Pid = strconv.Itoa(parent.Process.Pid)
+	containerInfo.InitStartTime = container.ProcessStartTime(parent.Process.Pid)
+	containerInfo.StartedAt = time.Now().Format(container.TimeFormat)
+	containerInfo.RestartCount++
+	containerInfo.Health.Status = container.HealthStarting
+	containerInfo.Health.FailingStreak = 0
+	cgroupManager.Apply(parent.Process.Pid)
+	if nw != "" {
+		/* the address and port mappings of the previous init process are released first. */
+		if err := network.Disconnect(nw, containerInfo); err != nil {
+			log.Errorf("Fail to disconnect network : %v", err)
+		}
+		if err := network.Connect(nw, containerInfo); err != nil {
+			log.Errorf("Fail to connect network : %v", err)
+		}
+	}
+	/* only fields of restart are written, a container stopped meanwhile is not brought back. */
+	stopped := false
+	err = container.UpdateContainerInfo(containerId, func(current *container.ContainerInfo) bool {
+		if current.Status != container.RUNNING {
+			stopped = true
+			return false
+		}
+		current.Pid = containerInfo.Pid
+		current.InitStartTime = containerInfo.InitStartTime
+		current.StartedAt = containerInfo.StartedAt
+		current.RestartCount = containerInfo.RestartCount
+		current.Health = containerInfo.Health
+		current.NetworkSettings = containerInfo.NetworkSettings
+		return true
+	})
+	if err != nil || stopped {
+		if err != nil {
+			log.Errorf("Record restart of container %s error : %v", containerId, err)
+		}
+		wp.Close()
+		parent.Process.Kill()
+		parent.Wait()
+		return nil
+	}
+	sendInitCommand(comArray, wp)
+	return parent
+}
+
+/* parse health check flags of `run`, nil if no check command is given. */
+func parseHealthConfig(cmd string, interval, timeout, startPeriod string, retries int,
+	onFailure string) (*container.HealthConfig, error) {
+	if cmd == "" {
+		return nil, nil
+	}
+	config := &container.HealthConfig{Command: cmd, Retries: retries, OnFailure: onFailure}
+	durations := []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"health-interval", interval, &config.Interval},
+		{"health-timeout", timeout, &config.Timeout},
+		{"health-start-period", startPeriod, &config.StartPeriod},
+	}
+	for _, d := range durations {
+		duration, err := time.ParseDuration(d.value)
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid --%s %s", d.name, d.value)
+		}
+		*d.field = duration
+	}
+	if config.Interval == 0 || config.Timeout == 0 {
+		return nil, fmt.Errorf("--health-interval and --health-timeout must be positive")
+	}
+	if config.Retries < 1 {
+		return nil, fmt.Errorf("--health-retries must be at least 1")
+	}
+	switch onFailure {
+	case container.HealthActionNone, container.HealthActionKill, container.HealthActionRestart:
+	default:
+		return nil, fmt.Errorf("invalid --health-on-failure %s, must be one of none, kill and restart", onFailure)
+	}
+	return config, nil
+}
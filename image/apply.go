@@ -0,0 +1,347 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	/* a file `.wh.<name>` in layer deletes `<name>` of lower layers, refer to the OCI image spec. */
+	WhiteoutPrefix = ".wh."
+	/* a directory holding this file hides all entries of lower layers in it. */
+	WhiteoutOpaque = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+	/* the PAX record of an extended attribute, as GNU tar and docker write it. */
+	paxXattrPrefix = "SCHILY.xattr."
+	/* the most symlinks followed to resolve a path, as the kernel allows. */
+	maxSymlinks = 40
+)
+
+/*
+	extract uncompressed layer tarball onto root, which holds the root filesystem of lower layers.
+	Whiteouts remove files of lower layers, and existing files are replaced instead of written in
+	place, as their inodes may be shared with lower layers. Every path is resolved inside root,
+	so that a layer can not write outside of it by `..` or symlinks.
+*/
+func applyTar(reader io.Reader, root string) error {
+	tr := tar.NewReader(reader)
+	/* entries written by this layer, which an opaque directory keeps. */
+	created := map[string]bool{}
+	type dirEntry struct {
+		path string
+		hdr  *tar.Header
+	}
+	var dirs []dirEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read layer error : %v", err)
+		}
+		rel, err := entryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		path, err := resolveInRoot(root, rel)
+		if err != nil {
+			return fmt.Errorf("extract %s error : %v", hdr.Name, err)
+		}
+		name := filepath.Base(rel)
+		if name == WhiteoutOpaque {
+			if err := clearLower(filepath.Dir(path), created); err != nil {
+				return fmt.Errorf("apply opaque whiteout %s error : %v", hdr.Name, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(name, WhiteoutPrefix+WhiteoutPrefix) {
+			continue
+		}
+		if strings.HasPrefix(name, WhiteoutPrefix) {
+			/* a whiteout hides an entry of its own directory, never the directory or its parent. */
+			hiddenName := strings.TrimPrefix(name, WhiteoutPrefix)
+			if hiddenName == "" || hiddenName == "." || hiddenName == ".." || strings.Contains(hiddenName, "/") {
+				return fmt.Errorf("invalid whiteout %s", hdr.Name)
+			}
+			hidden, err := resolveInRoot(root, filepath.Join(filepath.Dir(rel), hiddenName))
+			if err != nil {
+				return fmt.Errorf("apply whiteout %s error : %v", hdr.Name, err)
+			}
+			if err := os.RemoveAll(hidden); err != nil {
+				return fmt.Errorf("apply whiteout %s error : %v", hdr.Name, err)
+			}
+			continue
+		}
+		/* root itself can only be a directory, which would be replaced otherwise. */
+		if path == root && hdr.Typeflag != tar.TypeDir {
+			return fmt.Errorf("extract %s error : root of layer must be a directory", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeEntry(tr, hdr, root, path); err != nil {
+			return fmt.Errorf("extract %s error : %v", hdr.Name, err)
+		}
+		created[path] = true
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirEntry{path: path, hdr: hdr})
+		}
+	}
+	/* times of directory change while its children are extracted, set them at last. */
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setTimes(dirs[i].path, dirs[i].hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	the path on host of rel under root, with symlinks of its parent directories resolved as if
+	root were `/`. The last element is not followed, as it is the entry to write or remove.
+*/
+func resolveInRoot(root string, rel string) (string, error) {
+	dir, err := followInRoot(root, filepath.Dir(rel))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, dir, filepath.Base(rel)), nil
+}
+
+/*
+	rel with all symlinks followed relative to root, which neither `..` nor an absolute symlink
+	can climb above. Elements not existing yet are kept, they are created as directories later.
+*/
+func followInRoot(root string, rel string) (string, error) {
+	resolved := ""
+	remaining := rel
+	links := 0
+	for remaining != "" {
+		elem := remaining
+		remaining = ""
+		if i := strings.IndexByte(elem, '/'); i >= 0 {
+			elem, remaining = elem[:i], elem[i+1:]
+		}
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if resolved = filepath.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+		next := filepath.Join(resolved, elem)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symlinks in %s", rel)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		remaining = target + "/" + remaining
+	}
+	return resolved, nil
+}
+
+/* the path of entry relative to root, `..` can not climb above root. */
+func entryPath(name string) (string, error) {
+	rel := filepath.Clean(string(os.PathSeparator) + name)[1:]
+	if rel == "" {
+		return ".", nil
+	}
+	return rel, nil
+}
+
+/* remove entries under dir which are not written by the current layer. */
+func clearLower(dir string, created map[string]bool) error {
+	dirFile, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names, err := dirFile.Readdirnames(-1)
+	dirFile.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if !created[path] {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if info, err := os.Lstat(path); err == nil && info.IsDir() {
+			if err := clearLower(path, created); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeEntry(tr *tar.Reader, hdr *tar.Header, root string, path string) error {
+	info, err := os.Lstat(path)
+	exists := err == nil
+	/* an existing directory is kept with its entries, anything else is replaced. */
+	if exists && !(hdr.Typeflag == tar.TypeDir && info.IsDir()) {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		exists = false
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if !exists {
+			if err := os.Mkdir(path, 0700); err != nil {
+				return err
+			}
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		/*
+			the target is kept as it is, even if absolute or out of root, since it is only followed
+			by processes in container, whose root is root of layer, or resolved inside root here.
+		*/
+		if hdr.Linkname == "" || strings.IndexByte(hdr.Linkname, 0) >= 0 {
+			return fmt.Errorf("invalid symlink target %q", hdr.Linkname)
+		}
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+		return setTimes(path, hdr)
+	case tar.TypeLink:
+		target, err := linkTarget(root, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		/* a hardlink shares inode with its target, whose metadata is set already. */
+		return os.Link(target, path)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(hdr.Mode & 07777)
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			mode |= unix.S_IFCHR
+		case tar.TypeBlock:
+			mode |= unix.S_IFBLK
+		default:
+			mode |= unix.S_IFIFO
+		}
+		if err := unix.Mknod(path, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported type %c", hdr.Typeflag)
+	}
+	if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	/* chown clears setuid bits, so mode is set after it. */
+	mode := hdr.FileInfo().Mode()
+	if err := os.Chmod(path, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	/* chown also clears file capabilities, so xattrs are set last. */
+	if err := setXattrs(path, hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return setTimes(path, hdr)
+}
+
+/*
+	the path on host of the target of hardlink, which must be a file extracted before inside root.
+	An absolute target is relative to root, as hardlinks in tarball are.
+*/
+func linkTarget(root string, linkname string) (string, error) {
+	rel, err := entryPath(linkname)
+	if err != nil {
+		return "", err
+	}
+	target, err := resolveInRoot(root, rel)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(target)
+	if err != nil {
+		return "", fmt.Errorf("hardlink target %s is not found", linkname)
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("hardlink target %s is a directory", linkname)
+	}
+	return target, nil
+}
+
+/* set extended attributes recorded in PAX records of entry. */
+func setXattrs(path string, hdr *tar.Header) error {
+	for key, record := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, paxXattrPrefix)
+		if err := unix.Lsetxattr(path, name, []byte(record), 0); err != nil {
+			/* filesystems without xattr support lose them, as copies by cp do. */
+			if err == unix.ENOTSUP {
+				continue
+			}
+			return fmt.Errorf("set xattr %s of %s error : %v", name, hdr.Name, err)
+		}
+	}
+	return nil
+}
+
+/* set access and modification time of entry, symlinks are not followed. */
+func setTimes(path string, hdr *tar.Header) error {
+	accessTime := hdr.AccessTime
+	if accessTime.IsZero() {
+		accessTime = hdr.ModTime
+	}
+	times := []unix.Timespec{timespec(accessTime), timespec(hdr.ModTime)}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func timespec(t time.Time) unix.Timespec {
+	if t.IsZero() {
+		t = time.Unix(0, 0)
+	}
+	return unix.NsecToTimespec(t.UnixNano())
+}
@@ -0,0 +1,206 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tarOf(t *testing.T, headers ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		/* a regular file has its name as content. */
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(hdr.Name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newTestRoots(t *testing.T) (string, string, func()) {
+	tmpDir, err := ioutil.TempDir("", "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, outside := filepath.Join(tmpDir, "root"), filepath.Join(tmpDir, "outside")
+	for _, dir := range []string{root, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "victim"), []byte("victim"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root, outside, func() { os.RemoveAll(tmpDir) }
+}
+
+/* neither `..` nor symlinks of layer lead writes, whiteouts or hardlinks outside of root. */
+func TestApplyConfined(t *testing.T) {
+	root, outside, cleanup := newTestRoots(t)
+	defer cleanup()
+	layer := tarOf(t,
+		&tar.Header{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: outside},
+		&tar.Header{Name: "abs/file", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "abs/.wh.victim", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "../../../.." + outside},
+		&tar.Header{Name: "up/sub/file", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "../../escaped", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "victim", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(outside, "victim")},
+		&tar.Header{Name: "victim", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "/abs/file"},
+	)
+	if err := applyTar(bytes.NewReader(layer), root); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "victim" {
+		t.Errorf("layer writes outside of root, which has %d entries", len(entries))
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(outside, "victim")); err != nil || string(content) != "victim" {
+		t.Errorf("file outside of root is changed to %q : %v", content, err)
+	}
+	/* symlinks are resolved as if root were `/`. */
+	for _, name := range []string{outside + "/file", outside + "/sub/file", "escaped", "victim", "link"} {
+		if info, err := os.Lstat(filepath.Join(root, name)); err != nil || !info.Mode().IsRegular() {
+			t.Errorf("%s is not extracted in root : %v", name, err)
+		}
+	}
+}
+
+/* whiteouts naming the directory of layer or its parent are refused, and remove nothing. */
+func TestApplyInvalidWhiteouts(t *testing.T) {
+	for _, name := range []string{".wh...", ".wh..", "dir/.wh...", ".wh."} {
+		root, outside, cleanup := newTestRoots(t)
+		layer := tarOf(t,
+			&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+			&tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0644},
+			&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644},
+		)
+		if err := applyTar(bytes.NewReader(layer), root); err == nil {
+			t.Errorf("layer with whiteout %s is applied", name)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "victim")); err != nil {
+			t.Errorf("whiteout %s removes file outside of root : %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "dir", "file")); err != nil {
+			t.Errorf("whiteout %s removes root of layer : %v", name, err)
+		}
+		cleanup()
+	}
+}
+
+func TestApplyInvalidLinks(t *testing.T) {
+	for name, layer := range map[string][]*tar.Header{
+		"hardlink to missing file": {
+			{Name: "link", Typeflag: tar.TypeLink, Linkname: "missing"},
+		},
+		"hardlink to directory": {
+			{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "link", Typeflag: tar.TypeLink, Linkname: "dir"},
+		},
+		"hardlink through symlink outside": {
+			{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: "/"},
+			{Name: "link", Typeflag: tar.TypeLink, Linkname: "abs/../../etc/passwd"},
+		},
+		"symlink as root": {
+			{Name: "./", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		},
+		"empty symlink": {
+			{Name: "empty", Typeflag: tar.TypeSymlink},
+		},
+		"symlink loop": {
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b"},
+			{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a"},
+			{Name: "a/file", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+	} {
+		root, _, cleanup := newTestRoots(t)
+		if err := applyTar(bytes.NewReader(tarOf(t, layer...)), root); err == nil {
+			t.Errorf("layer with %s is applied", name)
+		}
+		cleanup()
+	}
+}
+
+/* ownership and xattrs, such as file capabilities, are restored as recorded in layer. */
+func TestApplyXattrs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("chown and setting trusted xattrs need root")
+	}
+	root, _, cleanup := newTestRoots(t)
+	defer cleanup()
+	if err := unix.Lsetxattr(root, "trusted.probe", []byte("y"), 0); err != nil {
+		t.Skipf("filesystem has no xattr support : %v", err)
+	}
+	/* a version 2 capability of cap_net_raw, as set by `setcap cap_net_raw+ep`. */
+	capability := make([]byte, 20)
+	binary.LittleEndian.PutUint32(capability, 0x02000001)
+	binary.LittleEndian.PutUint32(capability[4:], 1<<13)
+	layer := tarOf(t, &tar.Header{
+		Name:     "ping",
+		Typeflag: tar.TypeReg,
+		Mode:     0755,
+		Uid:      1000,
+		Gid:      1001,
+		PAXRecords: map[string]string{
+			paxXattrPrefix + "trusted.comment":     "ping",
+			paxXattrPrefix + "security.capability": string(capability),
+		},
+	})
+	if err := applyTar(bytes.NewReader(layer), root); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "ping")
+	var stat unix.Stat_t
+	if err := unix.Lstat(path, &stat); err != nil {
+		t.Fatal(err)
+	}
+	if stat.Uid != 1000 || stat.Gid != 1001 {
+		t.Errorf("file is owned by %d:%d, expect 1000:1001", stat.Uid, stat.Gid)
+	}
+	value := make([]byte, 64)
+	if n, err := unix.Lgetxattr(path, "trusted.comment", value); err != nil || string(value[:n]) != "ping" {
+		t.Errorf("xattr is restored as %q : %v", value[:n], err)
+	}
+	if n, err := unix.Lgetxattr(path, "security.capability", value); err != nil || !bytes.Equal(value[:n], capability) {
+		t.Errorf("file capability is restored as %x, expect %x : %v", value[:n], capability, err)
+	}
+}
+
+/* files of a loaded archive are not written outside of its directory through its symlinks. */
+func TestUnpackArchiveConfined(t *testing.T) {
+	root, outside, cleanup := newTestRoots(t)
+	defer cleanup()
+	archive := tarOf(t,
+		&tar.Header{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: outside},
+		&tar.Header{Name: "dir/victim", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "victim", Typeflag: tar.TypeSymlink, Linkname: filepath.Join(outside, "victim")},
+		&tar.Header{Name: "victim", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	if err := unpackArchive(bytes.NewReader(archive), root); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(outside, "victim")); err != nil || string(content) != "victim" {
+		t.Errorf("file outside of archive is changed to %q : %v", content, err)
+	}
+}
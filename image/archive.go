@@ -0,0 +1,495 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	dockerManifestFile = "manifest.json"
+	ociIndexFile       = "index.json"
+	ociLayoutFile      = "oci-layout"
+	ociLayoutVersion   = "1.0.0"
+)
+
+/* an image with the references it is loaded or saved with. */
+type TaggedImage struct {
+	Image      *Image
+	References []Reference
+}
+
+/* an entry of manifest.json of Docker archive. */
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+/*
+	load images from a Docker archive made by `docker save`, or a tarball of OCI image layout.
+	Archives in both formats at once, as newer docker saves, are loaded as Docker archives.
+*/
+func (s *Store) Load(reader io.Reader) ([]TaggedImage, error) {
+	tmpDir, err := s.tempDir("load")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := unpackArchive(reader, tmpDir); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, dockerManifestFile)); err == nil {
+		return s.loadDockerArchive(tmpDir)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, ociIndexFile)); err == nil {
+		return s.loadOCILayout(tmpDir)
+	}
+	return nil, fmt.Errorf("archive has neither %s nor %s", dockerManifestFile, ociIndexFile)
+}
+
+/* unpack archive, which may be compressed, keeping only directories, files and symlinks. */
+func unpackArchive(reader io.Reader, dir string) error {
+	archiveReader, err := DecompressStream(reader)
+	if err != nil {
+		return fmt.Errorf("read archive error : %v", err)
+	}
+	defer archiveReader.Close()
+	tr := tar.NewReader(archiveReader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive error : %v", err)
+		}
+		rel, err := entryPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		path, err := resolveInRoot(dir, rel)
+		if err != nil {
+			return fmt.Errorf("unpack %s error : %v", hdr.Name, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		/* an existing file, maybe a symlink to anywhere, is replaced rather than written through. */
+		if info, err := os.Lstat(path); err == nil && !info.IsDir() && hdr.Typeflag != tar.TypeDir {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0700)
+		case tar.TypeReg, tar.TypeRegA:
+			var file *os.File
+			if file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600); err == nil {
+				_, err = io.Copy(file, tr)
+				file.Close()
+			}
+		case tar.TypeSymlink:
+			/* layers shared by images of old Docker archives are symlinks, resolved on open. */
+			err = os.Symlink(hdr.Linkname, path)
+		}
+		if err != nil {
+			return fmt.Errorf("unpack %s error : %v", hdr.Name, err)
+		}
+	}
+}
+
+/* open file of unpacked archive, symlinks must not point outside of archive. */
+func openArchiveFile(dir string, name string) (*os.File, error) {
+	rel, err := entryPath(name)
+	if err != nil {
+		return nil, err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(realDir, rel))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not found in archive", name)
+	}
+	if !strings.HasPrefix(path, realDir+string(os.PathSeparator)) {
+		return nil, fmt.Errorf("%s points outside of archive", name)
+	}
+	return os.Open(path)
+}
+
+func readArchiveFile(dir string, name string) ([]byte, error) {
+	file, err := openArchiveFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+func (s *Store) loadDockerArchive(dir string) ([]TaggedImage, error) {
+	content, err := readArchiveFile(dir, dockerManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []dockerManifest
+	if err := json.Unmarshal(content, &manifests); err != nil {
+		return nil, fmt.Errorf("unmarshal %s error : %v", dockerManifestFile, err)
+	}
+	var loaded []TaggedImage
+	for _, manifest := range manifests {
+		config, err := readArchiveFile(dir, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+		var layers []io.ReadCloser
+		for _, layer := range manifest.Layers {
+			file, err := openArchiveFile(dir, layer)
+			if err != nil {
+				closeAll(layers)
+				return nil, err
+			}
+			layers = append(layers, file)
+		}
+		img, err := s.importImage(config, layers)
+		if err != nil {
+			return nil, err
+		}
+		tagged := TaggedImage{Image: img}
+		for _, repoTag := range manifest.RepoTags {
+			ref, err := ParseReference(repoTag)
+			if err != nil {
+				return nil, err
+			}
+			tagged.References = append(tagged.References, ref)
+		}
+		if err := s.tagAll(tagged); err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, tagged)
+	}
+	return loaded, nil
+}
+
+func (s *Store) loadOCILayout(dir string) ([]TaggedImage, error) {
+	content, err := readArchiveFile(dir, ociIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("unmarshal %s error : %v", ociIndexFile, err)
+	}
+	var loaded []TaggedImage
+	for _, desc := range index.Manifests {
+		img, err := s.loadOCIManifest(dir, desc)
+		if err != nil {
+			return nil, err
+		}
+		tagged := TaggedImage{Image: img}
+		if ref, ok := annotatedReference(desc.Annotations); ok {
+			tagged.References = append(tagged.References, ref)
+		}
+		if err := s.tagAll(tagged); err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, tagged)
+	}
+	return loaded, nil
+}
+
+/* load image of manifest, an index is resolved to the manifest for host platform. */
+func (s *Store) loadOCIManifest(dir string, desc Descriptor) (*Image, error) {
+	content, err := readBlob(dir, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		if mediaType, err = ParseMediaType(content); err != nil {
+			return nil, err
+		}
+	}
+	if IsIndex(mediaType) {
+		var index Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, fmt.Errorf("unmarshal index %s error : %v", desc.Digest, err)
+		}
+		hostDesc, err := index.HostManifest()
+		if err != nil {
+			return nil, err
+		}
+		return s.loadOCIManifest(dir, *hostDesc)
+	}
+	if !IsManifest(mediaType) {
+		return nil, fmt.Errorf("unsupported manifest type %s", mediaType)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest %s error : %v", desc.Digest, err)
+	}
+	config, err := readBlob(dir, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var layers []io.ReadCloser
+	for _, layer := range manifest.Layers {
+		if !IsSupportedLayer(layer.MediaType) {
+			closeAll(layers)
+			return nil, fmt.Errorf("unsupported layer type %s", layer.MediaType)
+		}
+		if err := verifyBlob(dir, layer.Digest); err != nil {
+			closeAll(layers)
+			return nil, err
+		}
+		file, err := openArchiveFile(dir, blobName(layer.Digest))
+		if err != nil {
+			closeAll(layers)
+			return nil, err
+		}
+		layers = append(layers, file)
+	}
+	return s.importImage(config, layers)
+}
+
+/* the reference of image in index, a bare tag does not name an image. */
+func annotatedReference(annotations map[string]string) (Reference, bool) {
+	for _, key := range []string{AnnotationContainerName, AnnotationRefName} {
+		name := annotations[key]
+		if !strings.ContainsAny(name, ":/") {
+			continue
+		}
+		if ref, err := ParseReference(name); err == nil {
+			return ref, true
+		}
+	}
+	return Reference{}, false
+}
+
+func blobName(digest Digest) string {
+	return filepath.Join("blobs", "sha256", digest.Hex())
+}
+
+/* read blob of layout, which must match its digest. */
+func readBlob(dir string, digest Digest) ([]byte, error) {
+	if err := digest.Validate(); err != nil {
+		return nil, err
+	}
+	content, err := readArchiveFile(dir, blobName(digest))
+	if err != nil {
+		return nil, err
+	}
+	if actual := FromBytes(content); actual != digest {
+		return nil, fmt.Errorf("digest of blob %s is %s", digest, actual)
+	}
+	return content, nil
+}
+
+func verifyBlob(dir string, digest Digest) error {
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+	file, err := openArchiveFile(dir, blobName(digest))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if actual := FromHash(hash); actual != digest {
+		return fmt.Errorf("digest of blob %s is %s", digest, actual)
+	}
+	return nil
+}
+
+/*
+	import layers, which are closed after, and save config of image. Layers must match diff ids in
+	config in order.
+*/
+func (s *Store) importImage(config []byte, layers []io.ReadCloser) (*Image, error) {
+	defer closeAll(layers)
+	img, err := ParseImage(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != len(img.RootFS.DiffIDs) {
+		return nil, fmt.Errorf("image %s has %d layers, but config has %d", img.ID.Short(), len(layers), len(img.RootFS.DiffIDs))
+	}
+	for i, layer := range layers {
+		diffID, err := s.ImportLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		if diffID != img.RootFS.DiffIDs[i] {
+			return nil, fmt.Errorf("diff id of layer %d is %s, but config has %s", i, diffID, img.RootFS.DiffIDs[i])
+		}
+	}
+	return s.PutImageConfig(config)
+}
+
+func (s *Store) tagAll(tagged TaggedImage) error {
+	for _, ref := range tagged.References {
+		if err := s.Tag(ref, tagged.Image.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func closeAll(closers []io.ReadCloser) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
+
+/*
+	the OCI manifest of image and its config. Layers are referred to uncompressed by their diff
+	ids, as they are kept in store.
+*/
+func (s *Store) ImageManifest(img *Image) (*Manifest, []byte, error) {
+	config, err := s.ImageConfig(img.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        Descriptor{MediaType: MediaTypeImageConfig, Digest: img.ID, Size: int64(len(config))},
+		Layers:        []Descriptor{},
+	}
+	for _, diffID := range img.RootFS.DiffIDs {
+		if _, err := os.Stat(s.BlobPath(diffID)); err != nil {
+			return nil, nil, fmt.Errorf("layer %s of image %s is not found", diffID, img.ID.Short())
+		}
+		manifest.Layers = append(manifest.Layers, Descriptor{MediaType: MediaTypeImageLayer, Digest: diffID, Size: s.LayerSize(diffID)})
+	}
+	return manifest, config, nil
+}
+
+/*
+	save images as a tarball in both OCI image layout and Docker archive format, as newer docker
+	does, sharing blobs of layers. Layers are saved uncompressed.
+*/
+func (s *Store) Save(writer io.Writer, images []TaggedImage) error {
+	tw := tar.NewWriter(writer)
+	written := map[Digest]bool{}
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: []Descriptor{}}
+	var manifests []dockerManifest
+
+	if err := writeArchiveDir(tw, "blobs"); err != nil {
+		return err
+	}
+	if err := writeArchiveDir(tw, filepath.Join("blobs", "sha256")); err != nil {
+		return err
+	}
+	for _, tagged := range images {
+		img := tagged.Image
+		manifest, config, err := s.ImageManifest(img)
+		if err != nil {
+			return err
+		}
+		dockerEntry := dockerManifest{Config: blobName(img.ID), RepoTags: []string{}}
+		for _, layer := range manifest.Layers {
+			if !written[layer.Digest] {
+				if err := writeArchiveBlob(tw, s.BlobPath(layer.Digest), layer.Digest, layer.Size); err != nil {
+					return err
+				}
+				written[layer.Digest] = true
+			}
+			dockerEntry.Layers = append(dockerEntry.Layers, blobName(layer.Digest))
+		}
+		manifestContent, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		manifestDesc := Descriptor{
+			MediaType: MediaTypeImageManifest,
+			Digest:    FromBytes(manifestContent),
+			Size:      int64(len(manifestContent)),
+		}
+		for _, blob := range []struct {
+			digest  Digest
+			content []byte
+		}{{img.ID, config}, {manifestDesc.Digest, manifestContent}} {
+			if written[blob.digest] {
+				continue
+			}
+			if err := writeArchiveFile(tw, blobName(blob.digest), blob.content); err != nil {
+				return err
+			}
+			written[blob.digest] = true
+		}
+		/* an image is listed in index once per reference, or once without reference. */
+		if len(tagged.References) == 0 {
+			index.Manifests = append(index.Manifests, manifestDesc)
+		}
+		for _, ref := range tagged.References {
+			desc := manifestDesc
+			desc.Annotations = map[string]string{
+				AnnotationContainerName: ref.String(),
+				AnnotationRefName:       ref.Tag,
+			}
+			index.Manifests = append(index.Manifests, desc)
+			dockerEntry.RepoTags = append(dockerEntry.RepoTags, ref.String())
+		}
+		manifests = append(manifests, dockerEntry)
+	}
+	for _, file := range []struct {
+		name string
+		v    interface{}
+	}{
+		{ociLayoutFile, map[string]string{"imageLayoutVersion": ociLayoutVersion}},
+		{ociIndexFile, index},
+		{dockerManifestFile, manifests},
+	} {
+		content, err := json.Marshal(file.v)
+		if err != nil {
+			return err
+		}
+		if err := writeArchiveFile(tw, file.name, content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+/* entries of saved archive have a fixed time, so that saving the same images gives the same archive. */
+var archiveTime = time.Unix(0, 0)
+
+func writeArchiveDir(tw *tar.Writer, name string) error {
+	return tw.WriteHeader(&tar.Header{Name: name + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: archiveTime})
+}
+
+func writeArchiveFile(tw *tar.Writer, name string, content []byte) error {
+	hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content)), ModTime: archiveTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+func writeArchiveBlob(tw *tar.Writer, path string, digest Digest, size int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open layer %s error : %v", digest, err)
+	}
+	defer file.Close()
+	hdr := &tar.Header{Name: blobName(digest), Typeflag: tar.TypeReg, Mode: 0644, Size: size, ModTime: archiveTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
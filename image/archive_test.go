@@ -0,0 +1,256 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/* an archive of files whose contents are given. */
+func testArchive(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := writeArchiveFile(tw, name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testConfig(t *testing.T, layers ...[]byte) []byte {
+	img := NewImage()
+	img.Config.Cmd = []string{"sh"}
+	for _, layer := range layers {
+		img.RootFS.DiffIDs = append(img.RootFS.DiffIDs, FromBytes(layer))
+	}
+	content, err := json.Marshal(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestLoadDockerArchive(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	layer := testLayer(t, []testFile{{name: "bin", content: "busybox"}})
+	config := testConfig(t, layer)
+	manifest, _ := json.Marshal([]dockerManifest{{
+		Config:   "config.json",
+		RepoTags: []string{"app:v1"},
+		Layers:   []string{"0123/layer.tar"},
+	}})
+	archive := testArchive(t, map[string][]byte{
+		"manifest.json":  manifest,
+		"config.json":    config,
+		"0123/layer.tar": layer,
+	})
+	loaded, err := store.Load(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].Image.ID != FromBytes(config) {
+		t.Fatalf("loaded images are %v, expect image %s", loaded, FromBytes(config))
+	}
+	if img, err := store.Lookup("app:v1"); err != nil || img.ID != FromBytes(config) {
+		t.Errorf("loaded image is not tagged app:v1 : %v", err)
+	}
+	dir, err := store.RootfsDir(loaded[0].Image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, "bin")); string(content) != "busybox" {
+		t.Errorf("bin of loaded image is %q", content)
+	}
+
+	/* layers must match the config. */
+	other := testLayer(t, []testFile{{name: "bin", content: "other"}})
+	archive = testArchive(t, map[string][]byte{
+		"manifest.json":  manifest,
+		"config.json":    config,
+		"0123/layer.tar": other,
+	})
+	if _, err := store.Load(bytes.NewReader(archive)); err == nil {
+		t.Errorf("load archive with a layer not in config succeeds")
+	}
+}
+
+func TestLoadOCILayout(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	layer := testLayer(t, []testFile{{name: "bin", content: "busybox"}})
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write(layer)
+	gw.Close()
+	config := testConfig(t, layer)
+	manifest, _ := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        Descriptor{MediaType: MediaTypeImageConfig, Digest: FromBytes(config), Size: int64(len(config))},
+		Layers:        []Descriptor{{MediaType: MediaTypeImageLayerGzip, Digest: FromBytes(compressed.Bytes()), Size: int64(compressed.Len())}},
+	})
+	index, _ := json.Marshal(Index{SchemaVersion: 2, Manifests: []Descriptor{{
+		MediaType:   MediaTypeImageManifest,
+		Digest:      FromBytes(manifest),
+		Size:        int64(len(manifest)),
+		Annotations: map[string]string{AnnotationRefName: "localhost:5000/app:v2"},
+	}}})
+	files := map[string][]byte{
+		"oci-layout":                            []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		"index.json":                            index,
+		blobName(FromBytes(manifest)):           manifest,
+		blobName(FromBytes(config)):             config,
+		blobName(FromBytes(compressed.Bytes())): compressed.Bytes(),
+	}
+	loaded, err := store.Load(bytes.NewReader(testArchive(t, files)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || len(loaded[0].References) != 1 || loaded[0].References[0].String() != "localhost:5000/app:v2" {
+		t.Fatalf("loaded images are %v, expect localhost:5000/app:v2", loaded)
+	}
+	/* layers are kept uncompressed by their diff ids. */
+	if _, err := os.Stat(store.BlobPath(FromBytes(layer))); err != nil {
+		t.Errorf("layer of loaded image is not saved : %v", err)
+	}
+
+	/* blobs must match their digests. */
+	files[blobName(FromBytes(config))] = append(config, ' ')
+	if _, err := store.Load(bytes.NewReader(testArchive(t, files))); err == nil {
+		t.Errorf("load layout with a corrupted config succeeds")
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	base := testLayer(t, []testFile{{name: "etc", dir: true}, {name: "etc/passwd", content: "root"}})
+	top := testLayer(t, []testFile{{name: "etc", dir: true}, {name: "etc/passwd", content: "root\nuser"}})
+	var images []TaggedImage
+	for i, layers := range [][][]byte{{base}, {base, top}} {
+		for _, layer := range layers {
+			if _, err := store.ImportLayer(bytes.NewReader(layer)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		img, err := store.PutImageConfig(testConfig(t, layers...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tagged := TaggedImage{Image: img}
+		/* the base image is saved without reference. */
+		if i > 0 {
+			tagged.References = []Reference{{Repository: "app", Tag: "v1"}, {Repository: "app", Tag: "v2"}}
+		}
+		images = append(images, tagged)
+	}
+	var archive bytes.Buffer
+	if err := store.Save(&archive, images); err != nil {
+		t.Fatal(err)
+	}
+
+	other, otherCleanup := newTestStore(t)
+	defer otherCleanup()
+	loaded, err := other.Load(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].Image.ID != images[0].Image.ID || loaded[1].Image.ID != images[1].Image.ID {
+		t.Fatalf("loaded images are %v, expect the saved ones", loaded)
+	}
+	if refs, err := other.References(images[1].Image.ID); err != nil || len(refs) != 2 {
+		t.Errorf("references of loaded image are %v : %v", refs, err)
+	}
+	dir, err := other.RootfsDir(loaded[1].Image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, "etc", "passwd")); string(content) != "root\nuser" {
+		t.Errorf("passwd of loaded image is %q", content)
+	}
+
+	/* the saved archive is an OCI image layout as well, whose index names the tagged image. */
+	archive.Reset()
+	if err := store.Save(&archive, images[1:]); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("index.json is not saved : %v", err)
+		}
+		if hdr.Name != ociIndexFile {
+			continue
+		}
+		var index Index
+		if err := json.NewDecoder(tr).Decode(&index); err != nil {
+			t.Fatal(err)
+		}
+		if len(index.Manifests) != 2 || index.Manifests[1].Annotations[AnnotationContainerName] != "app:v2" {
+			t.Errorf("index of saved archive is %v", index)
+		}
+		break
+	}
+}
+
+func TestApplyWhiteouts(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	base := testLayer(t, []testFile{
+		{name: "a", content: "a"},
+		{name: "b", content: "b"},
+		{name: "dir", dir: true},
+		{name: "dir/old", content: "old"},
+		{name: "dir/sub", dir: true},
+		{name: "dir/sub/old", content: "old"},
+	})
+	top := testLayer(t, []testFile{
+		{name: ".wh.a"},
+		{name: "dir", dir: true},
+		{name: "dir/new", content: "new"},
+		{name: "dir/" + WhiteoutOpaque},
+	})
+	img := NewImage()
+	for _, layer := range [][]byte{base, top} {
+		diffID, err := store.ImportLayer(bytes.NewReader(layer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img.RootFS.DiffIDs = append(img.RootFS.DiffIDs, diffID)
+	}
+	if _, err := store.CreateImage(img); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := store.RootfsDir(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{
+		"a":                     false,
+		".wh.a":                 false,
+		"b":                     true,
+		"dir/new":               true,
+		"dir/old":               false,
+		"dir/sub":               false,
+		"dir/" + WhiteoutOpaque: false,
+	} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("%s exists is %v, expect %v", name, err == nil, exists)
+		}
+	}
+	/* the lower layer is not changed. */
+	if _, err := os.Stat(filepath.Join(store.LayerDir(img.RootFS.DiffIDs[0]), "a")); err != nil {
+		t.Errorf("file of lower layer is removed : %v", err)
+	}
+}
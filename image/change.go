@@ -0,0 +1,208 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/* the Dockerfile instructions which may change config of image on commit or import. */
+var changeInstructions = []string{"CMD", "ENTRYPOINT", "ENV", "EXPOSE", "LABEL", "USER", "VOLUME", "WORKDIR"}
+
+/*
+	apply a Dockerfile instruction to config, e.g. `CMD ["sh"]` or `ENV PATH=/bin`. A command in
+	shell form is run by /bin/sh -c.
+*/
+func (c *ContainerConfig) ApplyChange(change string) error {
+	fields := strings.SplitN(strings.TrimSpace(change), " ", 2)
+	instruction, args := strings.ToUpper(fields[0]), ""
+	if len(fields) == 2 {
+		args = strings.TrimSpace(fields[1])
+	}
+	if args == "" {
+		return fmt.Errorf("missing arguments of %s", change)
+	}
+	switch instruction {
+	case "CMD", "ENTRYPOINT":
+		command, err := parseCommand(args)
+		if err != nil {
+			return err
+		}
+		if instruction == "CMD" {
+			c.Cmd = command
+		} else {
+			c.Entrypoint = command
+		}
+	case "ENV":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			c.Env = setEnv(c.Env, pair[0], pair[1])
+		}
+	case "LABEL":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return err
+		}
+		if c.Labels == nil {
+			c.Labels = map[string]string{}
+		}
+		for _, pair := range pairs {
+			c.Labels[pair[0]] = pair[1]
+		}
+	case "EXPOSE":
+		words, err := splitWords(args)
+		if err != nil {
+			return err
+		}
+		if c.ExposedPorts == nil {
+			c.ExposedPorts = map[string]struct{}{}
+		}
+		for _, word := range words {
+			port, err := parseExposedPort(word)
+			if err != nil {
+				return err
+			}
+			c.ExposedPorts[port] = struct{}{}
+		}
+	case "USER":
+		c.User = args
+	case "VOLUME":
+		volumes, err := parseList(args)
+		if err != nil {
+			return err
+		}
+		if c.Volumes == nil {
+			c.Volumes = map[string]struct{}{}
+		}
+		for _, volume := range volumes {
+			c.Volumes[volume] = struct{}{}
+		}
+	case "WORKDIR":
+		/* a relative directory is relative to the previous one. */
+		if path.IsAbs(args) {
+			c.WorkingDir = path.Clean(args)
+		} else {
+			c.WorkingDir = path.Join("/", c.WorkingDir, args)
+		}
+	default:
+		return fmt.Errorf("unsupported change %s, must be one of %s", fields[0], strings.Join(changeInstructions, ", "))
+	}
+	return nil
+}
+
+/* a command in exec form of JSON array, or in shell form. */
+func parseCommand(args string) ([]string, error) {
+	if strings.HasPrefix(args, "[") {
+		var command []string
+		if err := json.Unmarshal([]byte(args), &command); err != nil {
+			return nil, fmt.Errorf("invalid command %s : %v", args, err)
+		}
+		return command, nil
+	}
+	return []string{"/bin/sh", "-c", args}, nil
+}
+
+/* a JSON array, or words separated by spaces. */
+func parseList(args string) ([]string, error) {
+	if strings.HasPrefix(args, "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(args), &list); err != nil {
+			return nil, fmt.Errorf("invalid list %s : %v", args, err)
+		}
+		return list, nil
+	}
+	return splitWords(args)
+}
+
+/* pairs in form of `key=value ...`, or a single `key value` as older Dockerfiles have. */
+func parsePairs(args string) ([][2]string, error) {
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(words[0], "=") {
+		value := strings.TrimSpace(strings.TrimPrefix(args, words[0]))
+		return [][2]string{{words[0], strings.Trim(value, `"`)}}, nil
+	}
+	var pairs [][2]string
+	for _, word := range words {
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid pair %s, must be in form of key=value", word)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+/* split by spaces, which are kept inside quotes or after a backslash. */
+func splitWords(args string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord, escaped := false, false
+	for _, r := range args {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %s", args)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+/* a port in form of port[/protocol], tcp by default. */
+func parseExposedPort(str string) (string, error) {
+	port, protocol := str, "tcp"
+	if index := strings.Index(str, "/"); index >= 0 {
+		port, protocol = str[:index], strings.ToLower(str[index+1:])
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %s", str)
+	}
+	if protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return "", fmt.Errorf("invalid protocol %s of port %s", protocol, str)
+	}
+	return port + "/" + protocol, nil
+}
+
+/* set variable in env of form key=value, replacing the previous value. */
+func setEnv(env []string, key string, value string) []string {
+	for i, kv := range env {
+		if strings.SplitN(kv, "=", 2)[0] == key {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
@@ -0,0 +1,43 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyChange(t *testing.T) {
+	config := ContainerConfig{Env: []string{"PATH=/bin", "HOME=/root"}, WorkingDir: "/srv"}
+	for _, change := range []string{
+		`CMD ["sh", "-c", "echo hi"]`,
+		`entrypoint /init --verbose`,
+		`ENV PATH=/usr/bin:/bin GREETING="hello world"`,
+		`ENV EDITOR vi`,
+		`LABEL team=infra "description=a b"`,
+		`EXPOSE 80 53/udp`,
+		`USER nobody`,
+		`VOLUME ["/data", "/logs"]`,
+		`WORKDIR app`,
+	} {
+		if err := config.ApplyChange(change); err != nil {
+			t.Fatalf("apply %s error : %v", change, err)
+		}
+	}
+	expected := ContainerConfig{
+		User:         "nobody",
+		ExposedPorts: map[string]struct{}{"80/tcp": {}, "53/udp": {}},
+		Env:          []string{"PATH=/usr/bin:/bin", "HOME=/root", "GREETING=hello world", "EDITOR=vi"},
+		Entrypoint:   []string{"/bin/sh", "-c", "/init --verbose"},
+		Cmd:          []string{"sh", "-c", "echo hi"},
+		Volumes:      map[string]struct{}{"/data": {}, "/logs": {}},
+		WorkingDir:   "/srv/app",
+		Labels:       map[string]string{"team": "infra", "description": "a b"},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("config is changed to %+v, expect %+v", config, expected)
+	}
+	for _, change := range []string{"RUN ls", "CMD", `CMD ["sh"`, "EXPOSE 70000", "EXPOSE 80/icmp", "LABEL a=b c", `ENV A="b`} {
+		if err := config.ApplyChange(change); err == nil {
+			t.Errorf("invalid change %s is applied", change)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/qqzeng/tinydocker/image/internal/zstd"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+/*
+	a reader of the stream decompressed if it is gzip or zstd compressed, as told by its magic
+	number. The standard library has no exported zstd decoder, so a copy of its internal one is
+	used, and layers are never piped through a program of host.
+*/
+func DecompressStream(reader io.Reader) (io.ReadCloser, error) {
+	bufReader := bufio.NewReader(reader)
+	magic, _ := bufReader.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, fmt.Errorf("read gzip stream error : %v", err)
+		}
+		return gzipReader, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return ioutil.NopCloser(zstd.NewReader(bufReader)), nil
+	}
+	return ioutil.NopCloser(bufReader), nil
+}
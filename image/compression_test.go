@@ -0,0 +1,120 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+/* `layer content\n` repeated 200 times, compressed by `zstd -19` with its checksum. */
+var zstdFixture = []byte{
+	0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x68, 0xb5, 0x00, 0x00, 0x70, 0x6c, 0x61,
+	0x79, 0x65, 0x72, 0x20, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x0a,
+	0x01, 0x00, 0xdf, 0x0a, 0x7f, 0x2a, 0x01, 0x50, 0xea, 0xc4, 0x8a,
+}
+
+/* compressed by the zstd program, which is only used to make test data. */
+func zstdCompress(t *testing.T, content []byte, level string) []byte {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	cmd := exec.Command("zstd", level, "-c", "-q")
+	cmd.Stdin = bytes.NewReader(content)
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed
+}
+
+func TestDecompressStream(t *testing.T) {
+	content := []byte("layer content")
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(content)
+	gw.Close()
+	for name, stream := range map[string][]byte{
+		"plain": content,
+		"gzip":  gzipped.Bytes(),
+	} {
+		reader, err := DecompressStream(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("decompress %s stream error : %v", name, err)
+		}
+		decompressed, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil || !bytes.Equal(decompressed, content) {
+			t.Errorf("%s stream is decompressed to %q : %v", name, decompressed, err)
+		}
+	}
+}
+
+/* zstd streams are decoded in process, a corrupt one fails instead of yielding garbage. */
+func TestDecompressZstd(t *testing.T) {
+	expected := bytes.Repeat([]byte("layer content\n"), 200)
+	if decompressed, err := readDecompressed(zstdFixture); err != nil || !bytes.Equal(decompressed, expected) {
+		t.Errorf("zstd stream is decompressed to %d bytes, expect %d : %v", len(decompressed), len(expected), err)
+	}
+	corrupt := append([]byte(nil), zstdFixture...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err := readDecompressed(corrupt); err == nil {
+		t.Errorf("zstd stream of wrong checksum is decompressed")
+	}
+	if _, err := readDecompressed(zstdFixture[:len(zstdFixture)/2]); err == nil {
+		t.Errorf("truncated zstd stream is decompressed")
+	}
+
+	/* larger content of several blocks, by levels using different kinds of blocks. */
+	content := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(content[:len(content)/2])
+	for i := len(content) / 2; i < len(content); i++ {
+		content[i] = content[i%4096]
+	}
+	for _, level := range []string{"-1", "-19", "--fast=5"} {
+		decompressed, err := readDecompressed(zstdCompress(t, content, level))
+		if err != nil || !bytes.Equal(decompressed, content) {
+			t.Errorf("content compressed at level %s is not decompressed : %v", level, err)
+		}
+	}
+}
+
+func readDecompressed(stream []byte) ([]byte, error) {
+	reader, err := DecompressStream(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+/* a zstd compressed layer has the diff id of its tarball, and is extracted as others. */
+func TestImportZstdLayer(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	layer := testLayer(t, []testFile{{name: "dir", dir: true}, {name: "dir/file", content: "zstd"}})
+	diffID, err := store.ImportLayer(bytes.NewReader(zstdCompress(t, layer, "-3")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := FromBytes(layer); diffID != expected {
+		t.Errorf("diff id of zstd layer is %s, expect %s", diffID, expected)
+	}
+	chainID, err := store.ApplyLayer("", diffID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(store.LayerDir(chainID), "dir", "file")); err != nil || string(content) != "zstd" {
+		t.Errorf("file of zstd layer is extracted as %q : %v", content, err)
+	}
+	if _, err := os.Stat(store.LayerDir(chainID)); err != nil {
+		t.Error(err)
+	}
+	if !IsSupportedLayer(MediaTypeImageLayerZstd) {
+		t.Errorf("zstd layers are not supported")
+	}
+}
@@ -0,0 +1,82 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+/* content address in form of `sha256:<hex>`, identifying blobs, layers and images. */
+type Digest string
+
+const (
+	sha256Prefix = "sha256:"
+	/* the length of image id shown by default, as docker does. */
+	ShortIdLength = 12
+)
+
+func FromBytes(content []byte) Digest {
+	sum := sha256.Sum256(content)
+	return Digest(sha256Prefix + hex.EncodeToString(sum[:]))
+}
+
+func FromHash(h hash.Hash) Digest {
+	return Digest(sha256Prefix + hex.EncodeToString(h.Sum(nil)))
+}
+
+/* parse digest with or without the algorithm, only sha256 is supported. */
+func ParseDigest(str string) (Digest, error) {
+	hexStr := strings.TrimPrefix(str, sha256Prefix)
+	if len(hexStr) != 2*sha256.Size {
+		return "", fmt.Errorf("invalid digest %s", str)
+	}
+	if _, err := hex.DecodeString(hexStr); err != nil || strings.ToLower(hexStr) != hexStr {
+		return "", fmt.Errorf("invalid digest %s", str)
+	}
+	return Digest(sha256Prefix + hexStr), nil
+}
+
+/*
+	a digest read from a manifest, config or index must be a full sha256 digest, since its hex
+	names files in store, e.g. `sha256:..` would name the directory holding them.
+*/
+func (d Digest) Validate() error {
+	if parsed, err := ParseDigest(string(d)); err != nil || parsed != d {
+		return fmt.Errorf("invalid digest %s", d)
+	}
+	return nil
+}
+
+/* the hex part of digest, used as file name. */
+func (d Digest) Hex() string {
+	return strings.TrimPrefix(string(d), sha256Prefix)
+}
+
+func (d Digest) String() string {
+	return string(d)
+}
+
+/* the truncated hex, e.g. in `images`. */
+func (d Digest) Short() string {
+	if hexStr := d.Hex(); len(hexStr) > ShortIdLength {
+		return hexStr[:ShortIdLength]
+	}
+	return d.Hex()
+}
+
+/*
+	chain id identifies a stack of layers by the diff ids of all layers in it, so that the same
+	layer applied on different parents is kept apart, refer to the OCI image spec.
+*/
+func ChainID(diffIDs []Digest) Digest {
+	if len(diffIDs) == 0 {
+		return ""
+	}
+	chainID := diffIDs[0]
+	for _, diffID := range diffIDs[1:] {
+		chainID = FromBytes([]byte(string(chainID) + " " + string(diffID)))
+	}
+	return chainID
+}
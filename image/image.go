@@ -0,0 +1,107 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+/* the default configuration of containers run from image. */
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+/* the layers of image by diff id, i.e. digest of uncompressed layer tarball, from bottom to top. */
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []Digest `json:"diff_ids"`
+}
+
+/* how a layer of image was made, layers without changes are marked empty. */
+type History struct {
+	Created    time.Time `json:"created,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+/*
+	the configuration of image in form of OCI image config, whose sha256 digest is the id of
+	image.
+*/
+type Image struct {
+	ID           Digest          `json:"-"`
+	Created      time.Time       `json:"created"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+/* an image of host platform without layers, to be filled by caller. */
+func NewImage() *Image {
+	return &Image{
+		Created:      time.Now().UTC(),
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		RootFS:       RootFS{Type: "layers"},
+	}
+}
+
+/* unmarshal image config, whose id is the digest of content. */
+func ParseImage(content []byte) (*Image, error) {
+	var img Image
+	if err := json.Unmarshal(content, &img); err != nil {
+		return nil, fmt.Errorf("unmarshal image config error : %v", err)
+	}
+	for _, diffID := range img.RootFS.DiffIDs {
+		if err := diffID.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid diff id of image config : %v", err)
+		}
+	}
+	img.ID = FromBytes(content)
+	return &img, nil
+}
+
+/* the chain id of top layer, which identifies the root filesystem of image. */
+func (img *Image) ChainID() Digest {
+	return ChainID(img.RootFS.DiffIDs)
+}
+
+/* a copy of config, whose maps and slices may be changed without affecting image. */
+func (c ContainerConfig) Copy() ContainerConfig {
+	copied := c
+	copied.Env = append([]string(nil), c.Env...)
+	copied.Entrypoint = append([]string(nil), c.Entrypoint...)
+	copied.Cmd = append([]string(nil), c.Cmd...)
+	if c.ExposedPorts != nil {
+		copied.ExposedPorts = map[string]struct{}{}
+		for port := range c.ExposedPorts {
+			copied.ExposedPorts[port] = struct{}{}
+		}
+	}
+	if c.Volumes != nil {
+		copied.Volumes = map[string]struct{}{}
+		for volume := range c.Volumes {
+			copied.Volumes[volume] = struct{}{}
+		}
+	}
+	if c.Labels != nil {
+		copied.Labels = map[string]string{}
+		for key, value := range c.Labels {
+			copied.Labels[key] = value
+		}
+	}
+	return copied
+}
@@ -0,0 +1,160 @@
+package image
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+/* save layer tarball as a blob, compressed ones are decompressed, and get its diff id. */
+func (s *Store) ImportLayer(reader io.Reader) (Digest, error) {
+	layerReader, err := DecompressStream(reader)
+	if err != nil {
+		return "", fmt.Errorf("read layer error : %v", err)
+	}
+	defer layerReader.Close()
+	tmpFile, err := s.TempFile("layer")
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpFile, hash), layerReader)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("save layer error : %v", err)
+	}
+	diffID := FromHash(hash)
+	if err := commitPath(tmpFile.Name(), s.BlobPath(diffID)); err != nil {
+		return "", fmt.Errorf("save layer %s error : %v", diffID, err)
+	}
+	return diffID, nil
+}
+
+/*
+	extract layer on top of the root filesystem of parent chain, which is empty for the bottom
+	layer, and get the chain id of result. Stacks extracted before are reused.
+*/
+func (s *Store) ApplyLayer(parent Digest, diffID Digest) (Digest, error) {
+	chainID := diffID
+	if parent != "" {
+		chainID = ChainID([]Digest{parent, diffID})
+	}
+	if _, err := os.Stat(s.LayerDir(chainID)); err == nil {
+		return chainID, nil
+	}
+	blob := s.BlobPath(diffID)
+	if _, err := os.Stat(blob); err != nil {
+		return "", fmt.Errorf("layer %s is not found : %v", diffID, err)
+	}
+	tmpDir, err := s.tempDir("rootfs")
+	if err != nil {
+		return "", err
+	}
+	if parent != "" {
+		err = linkTree(s.LayerDir(parent), tmpDir)
+	} else {
+		err = os.Chmod(tmpDir, 0755)
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("prepare layer %s error : %v", diffID, err)
+	}
+	layerFile, err := os.Open(blob)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	err = applyTar(layerFile, tmpDir)
+	layerFile.Close()
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("apply layer %s error : %v", diffID, err)
+	}
+	if err := commitPath(tmpDir, s.LayerDir(chainID)); err != nil {
+		return "", fmt.Errorf("save layer %s error : %v", diffID, err)
+	}
+	return chainID, nil
+}
+
+/*
+	make a tree of dst the same as src, with regular files hardlinked instead of copied. Files are
+	replaced instead of written in place when a layer above is applied, so that the shared inodes
+	are never changed.
+*/
+func linkTree(src string, dst string) error {
+	var dirs []string
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("stat %s is not supported", path)
+		}
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+				return err
+			}
+			dirs = append(dirs, rel)
+		case mode.IsRegular():
+			return os.Link(path, target)
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return os.Lchown(target, int(stat.Uid), int(stat.Gid))
+		default:
+			if err := syscall.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return fmt.Errorf("mknod %s error : %v", target, err)
+			}
+		}
+		if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+		/* chown clears setuid bits, so mode is set after it. */
+		return os.Chmod(target, permissionMode(stat))
+	})
+	if err != nil {
+		return err
+	}
+	/* times of directory change while its children are linked, set them at last. */
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Lstat(filepath.Join(src, dirs[i]))
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(filepath.Join(dst, dirs[i]), info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* permission bits of file including setuid, setgid and sticky in form of os.FileMode. */
+func permissionMode(stat *syscall.Stat_t) os.FileMode {
+	fileMode := os.FileMode(stat.Mode & 0777)
+	if stat.Mode&syscall.S_ISUID != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if stat.Mode&syscall.S_ISGID != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if stat.Mode&syscall.S_ISVTX != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
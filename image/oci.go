@@ -0,0 +1,107 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+)
+
+/* media types of OCI image spec and the Docker image format before it. */
+const (
+	MediaTypeImageManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageIndex     = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageConfig    = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayer     = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeImageLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeImageLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar"
+	MediaTypeDockerLayerGzip    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	/* annotations of index naming the image of a manifest. */
+	AnnotationRefName       = "org.opencontainers.image.ref.name"
+	AnnotationContainerName = "io.containerd.image.name"
+)
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+/* a reference to content by its digest and size. */
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      Digest            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+/* the config and layers of an image. */
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+/* manifests of an image for several platforms, or of several images in a layout. */
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+func IsIndex(mediaType string) bool {
+	return mediaType == MediaTypeImageIndex || mediaType == MediaTypeDockerManifestList
+}
+
+func IsManifest(mediaType string) bool {
+	return mediaType == MediaTypeImageManifest || mediaType == MediaTypeDockerManifest
+}
+
+/* whether layer can be imported. */
+func IsSupportedLayer(mediaType string) bool {
+	switch mediaType {
+	case MediaTypeImageLayer, MediaTypeImageLayerGzip, MediaTypeImageLayerZstd,
+		MediaTypeDockerLayer, MediaTypeDockerLayerGzip:
+		return true
+	}
+	return false
+}
+
+/* the manifest of index for host platform. */
+func (idx *Index) HostManifest() (*Descriptor, error) {
+	for i, desc := range idx.Manifests {
+		if desc.Platform == nil || !IsManifest(desc.MediaType) {
+			continue
+		}
+		if desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return &idx.Manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no manifest for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+/* the media type declared in content of a manifest or an index. */
+func ParseMediaType(content []byte) (string, error) {
+	var versioned struct {
+		MediaType string        `json:"mediaType"`
+		Manifests []interface{} `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &versioned); err != nil {
+		return "", fmt.Errorf("unmarshal manifest error : %v", err)
+	}
+	if versioned.MediaType != "" {
+		return versioned.MediaType, nil
+	}
+	/* the media type is optional in OCI, an index is told by its manifests. */
+	if versioned.Manifests != nil {
+		return MediaTypeImageIndex, nil
+	}
+	return MediaTypeImageManifest, nil
+}
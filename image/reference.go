@@ -0,0 +1,41 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const DefaultTag = "latest"
+
+var (
+	/* components of repository separated by `/`, the first may be a registry host with port. */
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?::[0-9]+)?(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRegexp        = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+/* a named reference to image, e.g. `busybox:latest`. */
+type Reference struct {
+	Repository string
+	Tag        string
+}
+
+/* parse reference in form of repository[:tag], the tag defaults to latest. */
+func ParseReference(str string) (Reference, error) {
+	repository, tag := str, DefaultTag
+	/* a colon after the last slash separates tag, others belong to registry host. */
+	if index := strings.LastIndex(str, ":"); index > strings.LastIndex(str, "/") {
+		repository, tag = str[:index], str[index+1:]
+	}
+	if !repositoryRegexp.MatchString(repository) {
+		return Reference{}, fmt.Errorf("invalid repository name %s, must be lowercase", repository)
+	}
+	if !tagRegexp.MatchString(tag) {
+		return Reference{}, fmt.Errorf("invalid tag %s", tag)
+	}
+	return Reference{Repository: repository, Tag: tag}, nil
+}
+
+func (r Reference) String() string {
+	return r.Repository + ":" + r.Tag
+}
@@ -0,0 +1,412 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const DefaultRoot = "/root/image"
+
+/*
+	a content addressed image store. Under its root:
+		blobs/sha256/<hex>      uncompressed layer tarballs by diff id
+		imagedb/sha256/<hex>    image configs by image id
+		layers/sha256/<hex>     root filesystem of a stack of layers by chain id
+		repositories.json       image id of each repository and tag
+	A layer shared by images is kept once, and files of a lower layer are hardlinked into the
+	root filesystems of layers above it.
+*/
+type Store struct {
+	root string
+}
+
+/* image ids of tags of each repository. */
+type repositories map[string]map[string]Digest
+
+func NewStore(root string) (*Store, error) {
+	for _, dir := range []string{"blobs/sha256", "imagedb/sha256", "layers/sha256", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			return nil, fmt.Errorf("create image store directory %s error : %v", dir, err)
+		}
+	}
+	return &Store{root: root}, nil
+}
+
+func (s *Store) BlobPath(digest Digest) string {
+	return filepath.Join(s.root, "blobs", "sha256", digest.Hex())
+}
+
+func (s *Store) imagePath(id Digest) string {
+	return filepath.Join(s.root, "imagedb", "sha256", id.Hex())
+}
+
+func (s *Store) layersDir() string {
+	return filepath.Join(s.root, "layers", "sha256")
+}
+
+/* the root filesystem of the stack of layers identified by chain id. */
+func (s *Store) LayerDir(chainID Digest) string {
+	return filepath.Join(s.layersDir(), chainID.Hex())
+}
+
+func (s *Store) tempDir(prefix string) (string, error) {
+	return ioutil.TempDir(filepath.Join(s.root, "tmp"), prefix)
+}
+
+/* a temporary file in store, e.g. for a layer being downloaded, which caller removes. */
+func (s *Store) TempFile(prefix string) (*os.File, error) {
+	return ioutil.TempFile(filepath.Join(s.root, "tmp"), prefix)
+}
+
+/* move a completed file or directory in place, losing to a concurrent writer of the same content. */
+func commitPath(tmpPath string, path string) error {
+	if err := os.Rename(tmpPath, path); err != nil {
+		if _, statErr := os.Stat(path); statErr == nil {
+			os.RemoveAll(tmpPath)
+			return nil
+		}
+		os.RemoveAll(tmpPath)
+		return err
+	}
+	return nil
+}
+
+/* save image config, whose digest becomes id of image. */
+func (s *Store) CreateImage(img *Image) (Digest, error) {
+	content, err := json.Marshal(img)
+	if err != nil {
+		return "", fmt.Errorf("marshal image config error : %v", err)
+	}
+	created, err := s.PutImageConfig(content)
+	if err != nil {
+		return "", err
+	}
+	img.ID = created.ID
+	return img.ID, nil
+}
+
+/*
+	save image config as it is, e.g. one loaded from archive or registry, so that id of image is
+	kept. Layers of image must be imported before.
+*/
+func (s *Store) PutImageConfig(content []byte) (*Image, error) {
+	img, err := ParseImage(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, diffID := range img.RootFS.DiffIDs {
+		if _, err := os.Stat(s.BlobPath(diffID)); err != nil {
+			return nil, fmt.Errorf("layer %s of image is not found : %v", diffID, err)
+		}
+	}
+	if _, err := os.Stat(s.imagePath(img.ID)); err == nil {
+		return img, nil
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Join(s.root, "tmp"), "image")
+	if err != nil {
+		return nil, err
+	}
+	_, err = tmpFile.Write(content)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return nil, fmt.Errorf("write image config error : %v", err)
+	}
+	if err := commitPath(tmpFile.Name(), s.imagePath(img.ID)); err != nil {
+		return nil, fmt.Errorf("save image config error : %v", err)
+	}
+	return img, nil
+}
+
+/* the image config as saved, whose digest is id of image. */
+func (s *Store) ImageConfig(id Digest) ([]byte, error) {
+	content, err := ioutil.ReadFile(s.imagePath(id))
+	if err != nil {
+		return nil, fmt.Errorf("no such image: %s", id)
+	}
+	return content, nil
+}
+
+func (s *Store) Get(id Digest) (*Image, error) {
+	content, err := s.ImageConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	return ParseImage(content)
+}
+
+/* all images in store, the newest first. */
+func (s *Store) Images() ([]*Image, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.root, "imagedb", "sha256"))
+	if err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, f := range files {
+		img, err := s.Get(Digest(sha256Prefix + f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+	return images, nil
+}
+
+/* the uncompressed size of layers of image in bytes. */
+func (s *Store) Size(img *Image) int64 {
+	var size int64
+	for _, diffID := range img.RootFS.DiffIDs {
+		size += s.LayerSize(diffID)
+	}
+	return size
+}
+
+/* the size of uncompressed layer tarball, 0 if layer is missing. */
+func (s *Store) LayerSize(diffID Digest) int64 {
+	if info, err := os.Stat(s.BlobPath(diffID)); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+/* the root filesystem of image, layers are extracted on first use. */
+func (s *Store) RootfsDir(img *Image) (string, error) {
+	layerDirs, err := s.LayerDirs(img)
+	if err != nil {
+		return "", err
+	}
+	return layerDirs[len(layerDirs)-1], nil
+}
+
+/* root filesystems of the stacks of layers of image from the bottom one, extracted if needed. */
+func (s *Store) LayerDirs(img *Image) ([]string, error) {
+	if len(img.RootFS.DiffIDs) == 0 {
+		return nil, fmt.Errorf("image %s has no layers", img.ID.Short())
+	}
+	var layerDirs []string
+	var chainID Digest
+	for _, diffID := range img.RootFS.DiffIDs {
+		var err error
+		if chainID, err = s.ApplyLayer(chainID, diffID); err != nil {
+			return nil, err
+		}
+		layerDirs = append(layerDirs, s.LayerDir(chainID))
+	}
+	return layerDirs, nil
+}
+
+/*
+	find image by repository[:tag], full id or a unique prefix of id. A name parsed as reference
+	is looked up as reference first.
+*/
+func (s *Store) Lookup(name string) (*Image, error) {
+	if ref, err := ParseReference(name); err == nil {
+		repos, err := s.loadRepositories()
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := repos[ref.Repository][ref.Tag]; ok {
+			return s.Get(id)
+		}
+	}
+	prefix := strings.TrimPrefix(name, sha256Prefix)
+	if len(prefix) == 0 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return nil, fmt.Errorf("no such image: %s", name)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(s.root, "imagedb", "sha256"))
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), prefix) {
+			found = append(found, f.Name())
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no such image: %s", name)
+	case 1:
+		return s.Get(Digest(sha256Prefix + found[0]))
+	default:
+		return nil, fmt.Errorf("image id prefix %s is ambiguous", name)
+	}
+}
+
+/* point reference at image, replacing the image it pointed to. */
+func (s *Store) Tag(ref Reference, id Digest) error {
+	if _, err := os.Stat(s.imagePath(id)); err != nil {
+		return fmt.Errorf("no such image: %s", id)
+	}
+	return s.updateRepositories(func(repos repositories) {
+		if repos[ref.Repository] == nil {
+			repos[ref.Repository] = map[string]Digest{}
+		}
+		repos[ref.Repository][ref.Tag] = id
+	})
+}
+
+/* references pointing at image, sorted. */
+func (s *Store) References(id Digest) ([]Reference, error) {
+	repos, err := s.loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var refs []Reference
+	for repository, tags := range repos {
+		for tag, tagged := range tags {
+			if tagged == id {
+				refs = append(refs, Reference{Repository: repository, Tag: tag})
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].String() < refs[j].String()
+	})
+	return refs, nil
+}
+
+func (s *Store) repositoriesFile() string {
+	return filepath.Join(s.root, "repositories.json")
+}
+
+func (s *Store) loadRepositories() (repositories, error) {
+	repos := repositories{}
+	content, err := ioutil.ReadFile(s.repositoriesFile())
+	if os.IsNotExist(err) {
+		return repos, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &repos); err != nil {
+		return nil, fmt.Errorf("unmarshal repositories error : %v", err)
+	}
+	return repos, nil
+}
+
+/* change repositories while holding a lock, so that concurrent tagging is not lost. */
+func (s *Store) updateRepositories(update func(repos repositories)) error {
+	lockFile, err := os.OpenFile(filepath.Join(s.root, "repositories.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock repositories error : %v", err)
+	}
+	repos, err := s.loadRepositories()
+	if err != nil {
+		return err
+	}
+	update(repos)
+	for repository, tags := range repos {
+		if len(tags) == 0 {
+			delete(repos, repository)
+		}
+	}
+	content, err := json.Marshal(repos)
+	if err != nil {
+		return err
+	}
+	tmpFile := s.repositoriesFile() + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.repositoriesFile())
+}
+
+/* remove reference, the image it pointed to is kept. */
+func (s *Store) Untag(ref Reference) error {
+	repos, err := s.loadRepositories()
+	if err != nil {
+		return err
+	}
+	if _, ok := repos[ref.Repository][ref.Tag]; !ok {
+		return fmt.Errorf("no such image: %s", ref)
+	}
+	return s.updateRepositories(func(repos repositories) {
+		delete(repos[ref.Repository], ref.Tag)
+	})
+}
+
+/* remove image and all references to it, its layers are left to Prune. */
+func (s *Store) Delete(id Digest) error {
+	if err := s.updateRepositories(func(repos repositories) {
+		for _, tags := range repos {
+			for tag, tagged := range tags {
+				if tagged == id {
+					delete(tags, tag)
+				}
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if err := os.Remove(s.imagePath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove image %s error : %v", id, err)
+	}
+	return nil
+}
+
+/*
+	remove layers no longer used by any image, except root filesystems in keepDirs, which are
+	still mounted by containers. removeLayer, unless nil, is called with the root filesystem of
+	each layer before it is removed, so that copies kept elsewhere are removed as well.
+*/
+func (s *Store) Prune(keepDirs map[string]bool, removeLayer func(layerDir string) error) error {
+	images, err := s.Images()
+	if err != nil {
+		return err
+	}
+	usedChains := map[string]bool{}
+	usedBlobs := map[string]bool{}
+	for _, img := range images {
+		for i, diffID := range img.RootFS.DiffIDs {
+			usedChains[ChainID(img.RootFS.DiffIDs[:i+1]).Hex()] = true
+			usedBlobs[diffID.Hex()] = true
+		}
+	}
+	chainDir := s.layersDir()
+	chains, err := ioutil.ReadDir(chainDir)
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		dir := filepath.Join(chainDir, chain.Name())
+		if usedChains[chain.Name()] || keepDirs[dir] {
+			continue
+		}
+		if removeLayer != nil {
+			if err := removeLayer(dir); err != nil {
+				return fmt.Errorf("remove layer %s error : %v", chain.Name(), err)
+			}
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("remove layer %s error : %v", chain.Name(), err)
+		}
+	}
+	blobDir := filepath.Join(s.root, "blobs", "sha256")
+	blobs, err := ioutil.ReadDir(blobDir)
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if usedBlobs[blob.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(blobDir, blob.Name())); err != nil {
+			return fmt.Errorf("remove blob %s error : %v", blob.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testFile struct {
+	name    string
+	content string
+	dir     bool
+}
+
+func testLayer(t *testing.T, files []testFile) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}
+		if f.dir {
+			hdr = &tar.Header{Name: f.name + "/", Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newTestStore(t *testing.T) (*Store, func()) {
+	root, err := ioutil.TempDir("", "image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, func() { os.RemoveAll(root) }
+}
+
+func TestParseReference(t *testing.T) {
+	cases := map[string]string{
+		"busybox":                   "busybox:latest",
+		"busybox:1.36":              "busybox:1.36",
+		"library/busybox":           "library/busybox:latest",
+		"localhost:5000/app":        "localhost:5000/app:latest",
+		"localhost:5000/app:v1.0-a": "localhost:5000/app:v1.0-a",
+	}
+	for str, expected := range cases {
+		ref, err := ParseReference(str)
+		if err != nil || ref.String() != expected {
+			t.Errorf("reference %s is parsed as %s, expect %s : %v", str, ref, expected, err)
+		}
+	}
+	for _, str := range []string{"", "BusyBox", "busybox:", "busybox:-tag", "/busybox", "busybox//a"} {
+		if ref, err := ParseReference(str); err == nil {
+			t.Errorf("invalid reference %s is parsed as %s", str, ref)
+		}
+	}
+}
+
+func TestChainID(t *testing.T) {
+	a, b := FromBytes([]byte("a")), FromBytes([]byte("b"))
+	if ChainID([]Digest{a}) != a {
+		t.Errorf("chain id of a single layer is not its diff id")
+	}
+	if expected := FromBytes([]byte(string(a) + " " + string(b))); ChainID([]Digest{a, b}) != expected {
+		t.Errorf("chain id of a, b is %s, expect %s", ChainID([]Digest{a, b}), expected)
+	}
+	if ChainID([]Digest{a, b}) == ChainID([]Digest{b, a}) {
+		t.Errorf("chain id does not depend on order of layers")
+	}
+}
+
+func TestStoreSharedLayers(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	base := testLayer(t, []testFile{{name: "etc", dir: true}, {name: "etc/passwd", content: "root"}, {name: "bin", content: "busybox"}})
+	top := testLayer(t, []testFile{{name: "etc", dir: true}, {name: "etc/passwd", content: "root\nuser"}})
+
+	baseID, err := store.ImportLayer(bytes.NewReader(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* the same layer compressed is the same layer. */
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write(base)
+	gw.Close()
+	if diffID, err := store.ImportLayer(&compressed); err != nil || diffID != baseID {
+		t.Errorf("diff id of compressed layer is %s, expect %s : %v", diffID, baseID, err)
+	}
+	if diffID := FromBytes(base); diffID != baseID {
+		t.Errorf("diff id of layer is %s, expect %s", baseID, diffID)
+	}
+	topID, err := store.ImportLayer(bytes.NewReader(top))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseImage := NewImage()
+	baseImage.RootFS.DiffIDs = []Digest{baseID}
+	baseImage.Config.Env = []string{"PATH=/bin"}
+	if _, err := store.CreateImage(baseImage); err != nil {
+		t.Fatal(err)
+	}
+	topImage := NewImage()
+	topImage.RootFS.DiffIDs = []Digest{baseID, topID}
+	if _, err := store.CreateImage(topImage); err != nil {
+		t.Fatal(err)
+	}
+	baseDir, err := store.RootfsDir(baseImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topDir, err := store.RootfsDir(topImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseDir != store.LayerDir(baseID) || topDir != store.LayerDir(topImage.ChainID()) {
+		t.Errorf("root filesystems are %s and %s, expect directories of chain ids", baseDir, topDir)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(baseDir, "etc", "passwd")); string(content) != "root" {
+		t.Errorf("passwd of base image is changed to %q", content)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(topDir, "etc", "passwd")); string(content) != "root\nuser" {
+		t.Errorf("passwd of top image is %q", content)
+	}
+	/* files not changed by top layer are kept once on disk. */
+	baseBin, err := os.Stat(filepath.Join(baseDir, "bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topBin, err := os.Stat(filepath.Join(topDir, "bin")); err != nil || !os.SameFile(baseBin, topBin) {
+		t.Errorf("bin of base layer is not shared by top image : %v", err)
+	}
+	if size := store.Size(topImage); size != int64(len(base)+len(top)) {
+		t.Errorf("size of top image is %d, expect %d", size, len(base)+len(top))
+	}
+
+	images, err := store.Images()
+	if err != nil || len(images) != 2 {
+		t.Fatalf("store has images %v, expect 2 : %v", images, err)
+	}
+	if loaded, err := store.Get(baseImage.ID); err != nil || loaded.Config.Env[0] != "PATH=/bin" {
+		t.Errorf("config of base image is not kept : %v", err)
+	}
+}
+
+func TestStoreLookup(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	diffID, err := store.ImportLayer(bytes.NewReader(testLayer(t, []testFile{{name: "a", content: "a"}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := NewImage()
+	img.RootFS.DiffIDs = []Digest{diffID}
+	if _, err := store.CreateImage(img); err != nil {
+		t.Fatal(err)
+	}
+	for _, str := range []string{"app", "app:v1", "localhost:5000/app:v1"} {
+		ref, _ := ParseReference(str)
+		if err := store.Tag(ref, img.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"app", "app:latest", "app:v1", "localhost:5000/app:v1", img.ID.String(), img.ID.Hex(), img.ID.Short()} {
+		if found, err := store.Lookup(name); err != nil || found.ID != img.ID {
+			t.Errorf("lookup %s error : %v", name, err)
+		}
+	}
+	for _, name := range []string{"app:v2", "other", "0123456789abcdef"} {
+		if _, err := store.Lookup(name); err == nil {
+			t.Errorf("lookup %s succeeds", name)
+		}
+	}
+	refs, err := store.References(img.ID)
+	if err != nil || len(refs) != 3 || refs[0].String() != "app:latest" {
+		t.Errorf("references of image are %v : %v", refs, err)
+	}
+	if err := store.Tag(Reference{Repository: "app", Tag: "v1"}, FromBytes([]byte("missing"))); err == nil {
+		t.Errorf("tag missing image succeeds")
+	}
+}
+
+func TestStoreDeleteAndPrune(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	baseID, err := store.ImportLayer(bytes.NewReader(testLayer(t, []testFile{{name: "a", content: "a"}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	topID, err := store.ImportLayer(bytes.NewReader(testLayer(t, []testFile{{name: "b", content: "b"}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseImage := NewImage()
+	baseImage.RootFS.DiffIDs = []Digest{baseID}
+	topImage := NewImage()
+	topImage.RootFS.DiffIDs = []Digest{baseID, topID}
+	for _, img := range []*Image{baseImage, topImage} {
+		if _, err := store.CreateImage(img); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.RootfsDir(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ref := Reference{Repository: "top", Tag: DefaultTag}
+	if err := store.Tag(ref, topImage.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Untag(ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Untag(ref); err == nil {
+		t.Errorf("untag missing reference succeeds")
+	}
+	if _, err := store.Get(topImage.ID); err != nil {
+		t.Errorf("untagged image is removed : %v", err)
+	}
+
+	/* the root filesystem of top image is kept as if a container uses it. */
+	topDir := store.LayerDir(topImage.ChainID())
+	if err := store.Delete(topImage.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Prune(map[string]bool{topDir: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(topDir); err != nil {
+		t.Errorf("root filesystem in use is removed : %v", err)
+	}
+	if _, err := os.Stat(store.BlobPath(topID)); !os.IsNotExist(err) {
+		t.Errorf("layer only used by removed image is kept : %v", err)
+	}
+	if _, err := os.Stat(store.LayerDir(baseID)); err != nil {
+		t.Errorf("layer of remaining image is removed : %v", err)
+	}
+	var removed []string
+	removeLayer := func(layerDir string) error {
+		removed = append(removed, layerDir)
+		return nil
+	}
+	if err := store.Prune(nil, removeLayer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(topDir); !os.IsNotExist(err) {
+		t.Errorf("unused root filesystem is kept : %v", err)
+	}
+	if len(removed) != 1 || removed[0] != topDir {
+		t.Errorf("removed layers are %v, expect %s", removed, topDir)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/container"
+	"github.com/qqzeng/tinydocker/image"
+	"github.com/qqzeng/tinydocker/storage"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/* the length of command shown by `history` unless --no-trunc is given. */
+const TruncatedCreatedByLength = 45
+
+func openImageStore() (*image.Store, error) {
+	return image.NewStore(image.DefaultRoot)
+}
+
+/*
+	find image by reference or id. An image only kept as `<name>.tar` under root url, as before
+	the image store, is imported as `<name>:latest` on first use.
+*/
+func resolveImage(store *image.Store, name string) (*image.Image, error) {
+	img, err := store.Lookup(name)
+	if err == nil {
+		return img, nil
+	}
+	if _, statErr := os.Stat(container.RootUrl + "/" + name + ".tar"); statErr != nil {
+		return nil, err
+	}
+	return importLegacyImage(store, name)
+}
+
+func importLegacyImage(store *image.Store, name string) (*image.Image, error) {
+	ref, err := image.ParseReference(name)
+	if err != nil {
+		return nil, err
+	}
+	imageTar := container.RootUrl + "/" + name + ".tar"
+	file, err := os.Open(imageTar)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	log.Infof("import image %s from %s", ref, imageTar)
+	diffID, err := store.ImportLayer(file)
+	if err != nil {
+		return nil, fmt.Errorf("import image %s error : %v", imageTar, err)
+	}
+	img := image.NewImage()
+	if stat, err := file.Stat(); err == nil {
+		img.Created = stat.ModTime().UTC()
+	}
+	/* labels of images committed before the image store were saved beside tarball. */
+	if ii, err := loadImageInfo(name); err == nil {
+		img.Config.Labels = ii.Labels
+	}
+	img.RootFS.DiffIDs = []image.Digest{diffID}
+	img.History = []image.History{{Created: img.Created, CreatedBy: "import " + imageTar}}
+	if _, err := store.CreateImage(img); err != nil {
+		return nil, err
+	}
+	if err := store.Tag(ref, img.ID); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+/* filters of `images`, an image is listed if it matches every given filter. */
+type imageFilters struct {
+	dangling   string   /* true for untagged images only, false for tagged ones */
+	labels     []string /* in form of key or key=value */
+	references []string /* glob patterns of repository or repository:tag */
+	before     string   /* only images created before this one */
+	since      string   /* only images created after this one */
+}
+
+func parseImageFilters(filterSlice []string) (*imageFilters, error) {
+	filters := &imageFilters{}
+	for _, filter := range filterSlice {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid filter %s, must be in form of key=value", filter)
+		}
+		switch kv[0] {
+		case "dangling":
+			if kv[1] != "true" && kv[1] != "false" {
+				return nil, fmt.Errorf("invalid filter %s, dangling must be true or false", filter)
+			}
+			filters.dangling = kv[1]
+		case "label":
+			filters.labels = append(filters.labels, kv[1])
+		case "reference":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return nil, fmt.Errorf("invalid reference pattern %s", kv[1])
+			}
+			filters.references = append(filters.references, kv[1])
+		case "before":
+			filters.before = kv[1]
+		case "since":
+			filters.since = kv[1]
+		default:
+			return nil, fmt.Errorf("unsupported filter %s, expect dangling, label, reference, before or since", filter)
+		}
+	}
+	return filters, nil
+}
+
+/* references matching any reference pattern, all of them if there is no pattern. */
+func (f *imageFilters) matchReferences(refs []image.Reference) []image.Reference {
+	if len(f.references) == 0 {
+		return refs
+	}
+	var matched []image.Reference
+	for _, ref := range refs {
+		for _, pattern := range f.references {
+			if ok, _ := path.Match(pattern, ref.Repository); ok {
+				matched = append(matched, ref)
+				break
+			}
+			if ok, _ := path.Match(pattern, ref.String()); ok {
+				matched = append(matched, ref)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+/* list images in store matching filters, once per reference. */
+func ListImages(quiet bool, noTrunc bool, filters *imageFilters) {
+	store, err := openImageStore()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	images, err := store.Images()
+	if err != nil {
+		log.Errorf("List images error : %v", err)
+		return
+	}
+	var before, since *image.Image
+	if filters.before != "" {
+		if before, err = store.Lookup(filters.before); err != nil {
+			log.Errorf("Filter images before %s error : %v", filters.before, err)
+			return
+		}
+	}
+	if filters.since != "" {
+		if since, err = store.Lookup(filters.since); err != nil {
+			log.Errorf("Filter images since %s error : %v", filters.since, err)
+			return
+		}
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if !quiet {
+		fmt.Fprintln(wr, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\tLABELS")
+	}
+	for _, img := range images {
+		if !container.MatchLabels(img.Config.Labels, filters.labels) {
+			continue
+		}
+		if before != nil && !img.Created.Before(before.Created) {
+			continue
+		}
+		if since != nil && !img.Created.After(since.Created) {
+			continue
+		}
+		refs, err := store.References(img.ID)
+		if err != nil {
+			log.Errorf("Get references of image %s error : %v", img.ID.Short(), err)
+			continue
+		}
+		if (filters.dangling == "true" && len(refs) > 0) || (filters.dangling == "false" && len(refs) == 0) {
+			continue
+		}
+		refs = filters.matchReferences(refs)
+		if len(filters.references) > 0 && len(refs) == 0 {
+			continue
+		}
+		id := img.ID.Short()
+		if noTrunc {
+			id = img.ID.String()
+		}
+		if quiet {
+			fmt.Fprintln(wr, id)
+			continue
+		}
+		/* an untagged image is shown once as <none>. */
+		if len(refs) == 0 {
+			refs = []image.Reference{{Repository: "<none>", Tag: "<none>"}}
+		}
+		for _, ref := range refs {
+			fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				ref.Repository,
+				ref.Tag,
+				id,
+				humanDuration(time.Since(img.Created))+" ago",
+				humanSize(store.Size(img)),
+				formatLabels(img.Config.Labels),
+			)
+		}
+	}
+	if err := wr.Flush(); err != nil {
+		log.Errorf("Flush image information to stdout error : %v", err)
+	}
+}
+
+/*
+	remove images by reference or id, the number of images failed to remove is returned. Layers
+	no longer used by any image or container are removed too.
+*/
+func RemoveImages(names []string, force bool) int {
+	store, err := openImageStore()
+	if err != nil {
+		log.Error(err)
+		return len(names)
+	}
+	containers, err := getAllContainers()
+	if err != nil {
+		log.Errorf("List containers error : %v", err)
+		return len(names)
+	}
+	failed := 0
+	for _, name := range names {
+		if err := removeImage(store, name, force, containers); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed++
+		}
+	}
+	/* root filesystems of containers are kept until the containers are removed. */
+	keepDirs := map[string]bool{}
+	for _, item := range containers {
+		keepDirs[item.ImageDir()] = true
+	}
+	if err := store.Prune(keepDirs, storage.RemoveLayer); err != nil {
+		log.Errorf("Remove unused layers error : %v", err)
+	}
+	return failed
+}
+
+/*
+	remove a reference of image, and the image itself once it has no references. An image used
+	by containers or referenced by several repositories is only removed if forced.
+*/
+func removeImage(store *image.Store, name string, force bool, containers []*container.ContainerInfo) error {
+	img, err := store.Lookup(name)
+	if err != nil {
+		return err
+	}
+	refs, err := store.References(img.ID)
+	if err != nil {
+		return err
+	}
+	var byRef *image.Reference
+	if ref, err := image.ParseReference(name); err == nil {
+		for _, tagged := range refs {
+			if tagged == ref {
+				byRef = &ref
+			}
+		}
+	}
+	if byRef != nil && len(refs) > 1 {
+		if err := store.Untag(*byRef); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", byRef)
+		return nil
+	}
+	if byRef == nil && len(refs) > 1 && !force {
+		return fmt.Errorf("unable to delete %s (must be forced) - image is referenced in multiple repositories", name)
+	}
+	if !force {
+		for _, item := range containers {
+			if item.ImageID == img.ID.String() {
+				return fmt.Errorf("unable to remove image %s (must be forced) - container %s is using it",
+					name, item.Id[:TruncatedIdLength])
+			}
+		}
+	}
+	if err := store.Delete(img.ID); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		fmt.Printf("Untagged: %s\n", ref)
+	}
+	fmt.Printf("Deleted: %s\n", img.ID)
+	return nil
+}
+
+/* create reference target pointing at the image of source. */
+func TagImage(source string, target string) error {
+	ref, err := image.ParseReference(target)
+	if err != nil {
+		return err
+	}
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+	img, err := resolveImage(store, source)
+	if err != nil {
+		return err
+	}
+	return store.Tag(ref, img.ID)
+}
+
+/* show how each layer of image was made, the newest first. */
+func ImageHistory(name string, quiet bool, noTrunc bool) error {
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+	img, err := resolveImage(store, name)
+	if err != nil {
+		return err
+	}
+	/* the non empty entries of history match layers in order. */
+	sizes := make([]int64, len(img.History))
+	layer := 0
+	for i, history := range img.History {
+		if !history.EmptyLayer && layer < len(img.RootFS.DiffIDs) {
+			sizes[i] = store.LayerSize(img.RootFS.DiffIDs[layer])
+			layer++
+		}
+	}
+	wr := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if !quiet {
+		fmt.Fprintln(wr, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT")
+	}
+	for i := len(img.History) - 1; i >= 0; i-- {
+		history := img.History[i]
+		/* only the top layer is an image of its own. */
+		id := "<missing>"
+		if i == len(img.History)-1 {
+			id = img.ID.Short()
+			if noTrunc {
+				id = img.ID.String()
+			}
+		}
+		if quiet {
+			fmt.Fprintln(wr, id)
+			continue
+		}
+		createdBy := strings.Replace(history.CreatedBy, "\t", " ", -1)
+		if !noTrunc && len(createdBy) > TruncatedCreatedByLength {
+			createdBy = createdBy[:TruncatedCreatedByLength-3] + "..."
+		}
+		created := "Unknown"
+		if !history.Created.IsZero() {
+			created = humanDuration(time.Since(history.Created)) + " ago"
+		}
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\n", id, created, createdBy, humanSize(sizes[i]), history.Comment)
+	}
+	return wr.Flush()
+}
+
+/* references of image in form of repository:tag, e.g. `busybox:latest`. */
+func imageRepoTags(store *image.Store, img *image.Image) []string {
+	repoTags := []string{}
+	refs, err := store.References(img.ID)
+	if err != nil {
+		return repoTags
+	}
+	for _, ref := range refs {
+		repoTags = append(repoTags, ref.String())
+	}
+	return repoTags
+}
+
+/* the command of container run from image, the command given replaces cmd of image. */
+func imageCommand(config image.ContainerConfig, comArray []string) []string {
+	if len(comArray) == 0 {
+		comArray = config.Cmd
+	}
+	return append(append([]string(nil), config.Entrypoint...), comArray...)
+}
+
+/* environment variables of image come first, so that those given override them. */
+func imageEnv(config image.ContainerConfig, envSlice []string) []string {
+	return append(append([]string(nil), config.Env...), envSlice...)
+}
+
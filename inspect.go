@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/container"
+	"github.com/qqzeng/tinydocker/image"
+	"github.com/qqzeng/tinydocker/network"
+	"github.com/qqzeng/tinydocker/storage"
+	"os"
+	"strings"
+	"text/template"
+)
+
+const (
+	InspectTypeContainer = "container"
+	InspectTypeNetwork   = "network"
+	InspectTypeImage     = "image"
+)
+
+type containerInspect struct {
+	*container.ContainerInfo
+	ExecSessions []*container.ExecInfo `json:"execSessions"` /* exec sessions started in container */
+	StorageUsage *storage.QuotaUsage    `json:"storageUsage"` /* usage of size limited writable layer */
+}
+
+type networkInspect struct {
+	*network.Network
+	Containers map[string]*container.NetworkSettings `json:"containers"` /* endpoints of connected containers */
+}
+
+type imageInspect struct {
+	*image.Image
+	Id         string            `json:"id"`         /* the digest of image config */
+	RepoTags   []string          `json:"repoTags"`   /* references of image */
+	RootDir    string            `json:"rootDir"`    /* the extracted root filesystem of image */
+	Size       int64             `json:"size"`       /* the uncompressed size of layers in bytes */
+	Containers []string          `json:"containers"` /* names of containers using image */
+	Labels     map[string]string `json:"labels"`     /* the user defined metadata of image */
+}
+
+/* template functions available to `inspect --format`. */
+var inspectFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+/*
+	print low-level information of containers, networks or images as json, or formatted with
+	a Go template. Objects are looked up as container, network and image in order unless the
+	type is given. The number of objects failed to inspect is returned.
+*/
+func Inspect(names []string, objectType string, format string) int {
+	var tmpl *template.Template
+	if format != "" {
+		var err error
+		if tmpl, err = template.New("inspect").Funcs(inspectFuncs).Parse(format); err != nil {
+			log.Errorf("Parse format %s error : %v", format, err)
+			return len(names)
+		}
+	}
+	network.Init()
+	failed := 0
+	var objects []interface{}
+	for _, name := range names {
+		object, err := inspectObject(name, objectType)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed++
+			continue
+		}
+		objects = append(objects, object)
+	}
+
+	if tmpl == nil {
+		if objects == nil {
+			objects = []interface{}{}
+		}
+		content, err := json.MarshalIndent(objects, "", "    ")
+		if err != nil {
+			log.Errorf("Marshal inspect result error : %v", err)
+			return len(names)
+		}
+		fmt.Fprintln(os.Stdout, string(content))
+		return failed
+	}
+	for _, object := range objects {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, object); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: execute format template error : %v\n", err)
+			failed++
+			continue
+		}
+		fmt.Fprintln(os.Stdout, buf.String())
+	}
+	return failed
+}
+
+func inspectObject(name string, objectType string) (interface{}, error) {
+	if objectType == "" || objectType == InspectTypeContainer {
+		if object, err := inspectContainer(name); err == nil || objectType != "" {
+			return object, err
+		}
+	}
+	if objectType == "" || objectType == InspectTypeNetwork {
+		if object, err := inspectNetwork(name); err == nil || objectType != "" {
+			return object, err
+		}
+	}
+	if objectType == "" || objectType == InspectTypeImage {
+		if object, err := inspectImage(name); err == nil || objectType != "" {
+			return object, err
+		}
+	}
+	if objectType != "" {
+		return nil, fmt.Errorf("unknown object type %s", objectType)
+	}
+	return nil, fmt.Errorf("no such object: %s", name)
+}
+
+func inspectContainer(containerName string) (*containerInspect, error) {
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("no such container: %s", containerName)
+	}
+	if containerInfo.RefreshStatus() {
+		container.UpdateContainerInfo(containerInfo.Id, (*container.ContainerInfo).RefreshStatus)
+	}
+	sessions, err := container.LoadExecSessions(containerInfo.Id)
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []*container.ExecInfo{}
+	}
+	return &containerInspect{
+		ContainerInfo: containerInfo,
+		ExecSessions:  sessions,
+		StorageUsage:  containerInfo.StorageUsage(),
+	}, nil
+}
+
+func inspectNetwork(nwName string) (*networkInspect, error) {
+	nw, ok := network.GetNetwork(nwName)
+	if !ok {
+		return nil, fmt.Errorf("no such network: %s", nwName)
+	}
+	result := &networkInspect{
+		Network:    nw,
+		Containers: map[string]*container.NetworkSettings{},
+	}
+	containers, err := getAllContainers()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range containers {
+		if item.NetworkSettings != nil && item.NetworkSettings.Network == nwName {
+			result.Containers[item.Name] = item.NetworkSettings
+		}
+	}
+	return result, nil
+}
+
+func inspectImage(imageName string) (*imageInspect, error) {
+	store, err := openImageStore()
+	if err != nil {
+		return nil, err
+	}
+	img, err := resolveImage(store, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("no such image: %s", imageName)
+	}
+	result := &imageInspect{
+		Image:      img,
+		Id:         img.ID.String(),
+		RepoTags:   imageRepoTags(store, img),
+		Size:       store.Size(img),
+		Containers: []string{},
+		Labels:     img.Config.Labels,
+	}
+	if chainID := img.ChainID(); chainID != "" {
+		if exists, _ := PathExists(store.LayerDir(chainID)); exists {
+			result.RootDir = store.LayerDir(chainID)
+		}
+	}
+	containers, err := getAllContainers()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range containers {
+		if item.ImageID == result.Id {
+			result.Containers = append(result.Containers, item.Name)
+		}
+	}
+	return result, nil
+}
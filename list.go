@@ -1,47 +1,112 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/qqzeng/tinydocker/container"
 	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
 )
 
-/* TODO: `./tinydocker ps` does not update the status of container process.   */
-func ListContainers() {
-	/* load container list information from specific directory. */
-	containerSavedUrl := fmt.Sprintf(container.DefaultInfoLocation, "")
-	containerSavedUrl = containerSavedUrl[:len(containerSavedUrl)-1]
-	containerFiles, err := ioutil.ReadDir(containerSavedUrl)
+const (
+	TruncatedIdLength      = 12
+	TruncatedCommandLength = 20
+)
+
+/* a row of `ps`, which is also the data of `ps --format` template. */
+type psRow struct {
+	ID         string
+	Names      string
+	Image      string
+	Pid        string
+	Command    string
+	CreatedAt  string
+	RunningFor string
+	Status     string
+	State      string
+	Ports      string
+	Networks   string
+	Labels     string
+	Size       string
+	info       *container.ContainerInfo
+}
+
+/* the value of given label, used as `{{.Label "team"}}` in format template. */
+func (r *psRow) Label(key string) string {
+	return r.info.Labels[key]
+}
+
+func ListContainers(all bool, quiet bool, filterSlice []string, format string, noTrunc bool, size bool) {
+	containerInfoList, err := getAllContainers()
 	if err != nil {
 		log.Errorf("Read container information directory error: %s", err)
 		return
 	}
-	var containerInfoList []*container.ContainerInfo
-	for _, cf := range containerFiles {
-		tmpC, err := extractContainerInfo(cf)
-		if err != nil {
-			log.Errorf("Read container information error: %s", err)
+	filters, err := parseFilters(filterSlice)
+	if err != nil {
+		log.Errorf("Parse filter error : %v", err)
+		return
+	}
+	/* containers listed most recently created first. */
+	sort.Slice(containerInfoList, func(i, j int) bool {
+		return containerInfoList[i].CreateTime > containerInfoList[j].CreateTime
+	})
+
+	var rows []*psRow
+	for _, item := range containerInfoList {
+		if item.RefreshStatus() {
+			if err := container.UpdateContainerInfo(item.Id, (*container.ContainerInfo).RefreshStatus); err != nil {
+				log.Errorf("Update status of container %s error : %v", item.Name, err)
+			}
+		}
+		if !all && item.Status != container.RUNNING && !filters.has("status") {
 			continue
 		}
-		containerInfoList = append(containerInfoList, tmpC)
+		if !filters.match(item) {
+			continue
+		}
+		rows = append(rows, newPsRow(item, noTrunc, size))
 	}
 
+	if quiet {
+		for _, row := range rows {
+			fmt.Fprintln(os.Stdout, row.ID)
+		}
+		return
+	}
 	/* output container information to stdout */
 	wr := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprintf(wr, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATETIME\n")
-	for _, item := range containerInfoList {
-		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			item.Id,
-			item.Name,
-			item.Pid,
-			item.Status,
-			item.Command,
-			item.CreateTime,
-		)
+	if format != "" {
+		printFormattedRows(wr, rows, format)
+	} else {
+		header := "ID\tNAME\tIMAGE\tPID\tSTATUS\tCOMMAND\tCREATED"
+		if size {
+			header += "\tSIZE"
+		}
+		fmt.Fprintln(wr, header)
+		for _, item := range rows {
+			line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s",
+				item.ID,
+				item.Names,
+				item.Image,
+				item.Pid,
+				item.Status,
+				item.Command,
+				item.RunningFor,
+			)
+			if size {
+				line += "\t" + item.Size
+			}
+			fmt.Fprintln(wr, line)
+		}
 	}
 	if err := wr.Flush(); err != nil {
 		log.Errorf("Flush container information to stdout error : %v", err)
@@ -49,6 +114,250 @@ func ListContainers() {
 	}
 }
 
+/* execute format template on each row, a `table ` prefix prints a header of field names. */
+func printFormattedRows(wr *tabwriter.Writer, rows []*psRow, format string) {
+	table := strings.HasPrefix(format, "table ")
+	format = strings.TrimPrefix(format, "table ")
+	/* `\t` given in shell arguments is not a tab yet. */
+	format = strings.Replace(format, `\t`, "\t", -1)
+	tmpl, err := template.New("ps").Funcs(inspectFuncs).Parse(format)
+	if err != nil {
+		log.Errorf("Parse format %s error : %v", format, err)
+		return
+	}
+	if table {
+		headerTmpl := template.Must(template.New("header").Funcs(inspectFuncs).Parse(format))
+		var buf bytes.Buffer
+		headerTmpl.Execute(&buf, psHeader())
+		fmt.Fprintln(wr, buf.String())
+	}
+	for _, row := range rows {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, row); err != nil {
+			log.Errorf("Execute format template error : %v", err)
+			return
+		}
+		fmt.Fprintln(wr, buf.String())
+	}
+}
+
+func psHeader() *psRow {
+	return &psRow{
+		ID:         "ID",
+		Names:      "NAME",
+		Image:      "IMAGE",
+		Pid:        "PID",
+		Command:    "COMMAND",
+		CreatedAt:  "CREATED AT",
+		RunningFor: "CREATED",
+		Status:     "STATUS",
+		State:      "STATE",
+		Ports:      "PORTS",
+		Networks:   "NETWORKS",
+		Labels:     "LABELS",
+		Size:       "SIZE",
+		info:       &container.ContainerInfo{},
+	}
+}
+
+func newPsRow(item *container.ContainerInfo, noTrunc bool, size bool) *psRow {
+	row := &psRow{
+		ID:         item.Id,
+		Names:      item.Name,
+		Image:      item.Image,
+		Pid:        item.Pid,
+		Command:    item.Command,
+		CreatedAt:  item.CreateTime,
+		RunningFor: humanDurationSince(item.CreateTime) + " ago",
+		Status:     describeStatus(item),
+		State:      item.Status,
+		Ports:      strings.Join(item.PortMapping, ","),
+		info:       item,
+	}
+	if item.NetworkSettings != nil {
+		row.Networks = item.NetworkSettings.Network
+	}
+	row.Labels = formatLabels(item.Labels)
+	if !noTrunc {
+		if len(row.ID) > TruncatedIdLength {
+			row.ID = row.ID[:TruncatedIdLength]
+		}
+		if len(row.Command) > TruncatedCommandLength {
+			row.Command = row.Command[:TruncatedCommandLength-3] + "..."
+		}
+	}
+	if size {
+		var writeLayerSize int64
+		if driver, err := item.Storage(); err == nil {
+			writeLayerSize, _ = driver.Size(item.Id)
+		}
+		imageSize := dirSize(item.ImageDir())
+		row.Size = fmt.Sprintf("%s (virtual %s)", humanSize(writeLayerSize), humanSize(writeLayerSize+imageSize))
+		/* a size limited layer also shows its usage of filesystem, which counts directories too. */
+		if usage := item.StorageUsage(); usage != nil {
+			row.Size = fmt.Sprintf("%s (virtual %s, %s of %s used)", humanSize(writeLayerSize),
+				humanSize(writeLayerSize+imageSize), humanSize(usage.Used), humanSize(usage.Limit))
+		}
+	}
+	return row
+}
+
+/* status in docker style, e.g. `Up 3 minutes` and `Exited (1) 2 hours ago`. */
+func describeStatus(item *container.ContainerInfo) string {
+	switch item.Status {
+	case container.RUNNING:
+		if item.Health != nil {
+			return fmt.Sprintf("Up %s (%s)", humanDurationSince(item.StartedAt), item.Health.Status)
+		}
+		return "Up " + humanDurationSince(item.StartedAt)
+	case container.STOP, container.EXIT:
+		prefix := "Exited"
+		if item.Status == container.STOP {
+			prefix = "Stopped"
+		}
+		if item.FinishedAt == "" {
+			return prefix
+		}
+		return fmt.Sprintf("%s (%d) %s ago", prefix, item.ExitCode, humanDurationSince(item.FinishedAt))
+	}
+	return strings.Title(item.Status)
+}
+
+func humanDurationSince(timeStr string) string {
+	t, err := time.ParseInLocation(container.TimeFormat, timeStr, time.Local)
+	if err != nil {
+		return "Unknown"
+	}
+	return humanDuration(time.Since(t))
+}
+
+/* human readable approximation of duration, as docker does. */
+func humanDuration(d time.Duration) string {
+	if seconds := int(d.Seconds()); seconds < 1 {
+		return "Less than a second"
+	} else if seconds == 1 {
+		return "1 second"
+	} else if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	} else if minutes := int(d.Minutes()); minutes == 1 {
+		return "About a minute"
+	} else if minutes < 60 {
+		return fmt.Sprintf("%d minutes", minutes)
+	} else if hours := int(d.Hours() + 0.5); hours == 1 {
+		return "About an hour"
+	} else if hours < 48 {
+		return fmt.Sprintf("%d hours", hours)
+	} else if hours < 24*7*2 {
+		return fmt.Sprintf("%d days", hours/24)
+	} else if hours < 24*30*2 {
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	} else if hours < 24*365*2 {
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+	return fmt.Sprintf("%d years", int(d.Hours())/24/365)
+}
+
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
+
+/* total size of regular files under directory. */
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+/* filters of ps in form of key=value, values of the same key are ORed and different keys ANDed. */
+type psFilters map[string][]string
+
+func parseFilters(filterSlice []string) (psFilters, error) {
+	filters := psFilters{}
+	for _, filter := range filterSlice {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad format of filter %s, expect key=value", filter)
+		}
+		switch kv[0] {
+		case "status", "name", "label", "network":
+		default:
+			return nil, fmt.Errorf("unsupported filter %s, expect one of status, name, label and network", kv[0])
+		}
+		filters[kv[0]] = append(filters[kv[0]], kv[1])
+	}
+	return filters, nil
+}
+
+func (filters psFilters) has(key string) bool {
+	_, ok := filters[key]
+	return ok
+}
+
+func (filters psFilters) match(item *container.ContainerInfo) bool {
+	for key, values := range filters {
+		matched := false
+		for _, value := range values {
+			switch key {
+			case "status":
+				matched = item.Status == value
+			case "name":
+				matched = strings.Contains(item.Name, value)
+			case "label":
+				matched = container.MatchLabel(item.Labels, value)
+			case "network":
+				matched = item.NetworkSettings != nil && item.NetworkSettings.Network == value
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+/* load container list information from specific directory. */
+func getAllContainers() ([]*container.ContainerInfo, error) {
+	containerSavedUrl := fmt.Sprintf(container.DefaultInfoLocation, "")
+	containerSavedUrl = containerSavedUrl[:len(containerSavedUrl)-1]
+	containerFiles, err := ioutil.ReadDir(containerSavedUrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var containerInfoList []*container.ContainerInfo
+	for _, cf := range containerFiles {
+		/* skip half created containers without config file. */
+		configFile := fmt.Sprintf(container.DefaultInfoLocation, cf.Name()) + container.ConfigName
+		if exists, _ := PathExists(configFile); !exists {
+			continue
+		}
+		tmpC, err := extractContainerInfo(cf)
+		if err != nil {
+			log.Errorf("Read container information error: %s", err)
+			continue
+		}
+		containerInfoList = append(containerInfoList, tmpC)
+	}
+	return containerInfoList, nil
+}
+
 func extractContainerInfo(cf os.FileInfo) (*container.ContainerInfo, error) {
 	containerLocation := fmt.Sprintf(container.DefaultInfoLocation, cf.Name())
 	containerFile := containerLocation + container.ConfigName
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"github.com/qqzeng/tinydocker/container"
+	"testing"
+	"time"
+)
+
+func TestHumanDuration(t *testing.T) {
+	cases := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{0, "Less than a second"},
+		{999 * time.Millisecond, "Less than a second"},
+		{time.Second, "1 second"},
+		{59 * time.Second, "59 seconds"},
+		{time.Minute, "About a minute"},
+		{119 * time.Second, "About a minute"},
+		{2 * time.Minute, "2 minutes"},
+		{59 * time.Minute, "59 minutes"},
+		{time.Hour, "About an hour"},
+		{89 * time.Minute, "About an hour"},
+		{90 * time.Minute, "2 hours"},
+		{47 * time.Hour, "47 hours"},
+		{48 * time.Hour, "2 days"},
+		{13 * 24 * time.Hour, "13 days"},
+		{14 * 24 * time.Hour, "2 weeks"},
+		{59 * 24 * time.Hour, "8 weeks"},
+		{60 * 24 * time.Hour, "2 months"},
+		{729 * 24 * time.Hour, "24 months"},
+		{730 * 24 * time.Hour, "2 years"},
+	}
+	for _, c := range cases {
+		if human := humanDuration(c.duration); human != c.expected {
+			t.Errorf("duration %v is %q, expect %q", c.duration, human, c.expected)
+		}
+	}
+}
+
+func TestHumanDurationSince(t *testing.T) {
+	cases := map[string]string{
+		time.Now().Add(-3 * time.Minute).Format(container.TimeFormat): "3 minutes",
+		time.Now().Add(-5 * time.Hour).Format(container.TimeFormat):   "5 hours",
+		"":                              "Unknown",
+		time.Now().Format(time.RFC3339): "Unknown",
+	}
+	for timeStr, expected := range cases {
+		if human := humanDurationSince(timeStr); human != expected {
+			t.Errorf("duration since %q is %q, expect %q", timeStr, human, expected)
+		}
+	}
+}
+
+func TestDescribeStatus(t *testing.T) {
+	ago := func(d time.Duration) string {
+		return time.Now().Add(-d).Format(container.TimeFormat)
+	}
+	cases := []struct {
+		info     *container.ContainerInfo
+		expected string
+	}{
+		{&container.ContainerInfo{Status: container.RUNNING, StartedAt: ago(3 * time.Minute)}, "Up 3 minutes"},
+		{&container.ContainerInfo{Status: container.RUNNING, StartedAt: ago(2 * time.Hour),
+			Health: &container.Health{Status: container.HealthUnhealthy}}, "Up 2 hours (unhealthy)"},
+		{&container.ContainerInfo{Status: container.EXIT, ExitCode: 1, FinishedAt: ago(5 * time.Minute)}, "Exited (1) 5 minutes ago"},
+		{&container.ContainerInfo{Status: container.STOP, ExitCode: 143, FinishedAt: ago(3 * 24 * time.Hour)}, "Stopped (143) 3 days ago"},
+		{&container.ContainerInfo{Status: container.EXIT, ExitCode: -1}, "Exited"},
+		{&container.ContainerInfo{Status: container.STOP}, "Stopped"},
+		{&container.ContainerInfo{Status: "paused"}, "Paused"},
+	}
+	for _, c := range cases {
+		if status := describeStatus(c.info); status != c.expected {
+			t.Errorf("status of %s container is %q, expect %q", c.info.Status, status, c.expected)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	cases := []struct {
+		filterSlice []string
+		expected    psFilters
+	}{
+		{nil, psFilters{}},
+		{[]string{"status=running", "status=exited", "name=web"},
+			psFilters{"status": {"running", "exited"}, "name": {"web"}}},
+		{[]string{"label=team=infra", "label=debug", "network=br0"},
+			psFilters{"label": {"team=infra", "debug"}, "network": {"br0"}}},
+		{[]string{"name="}, psFilters{"name": {""}}},
+		{[]string{"status"}, nil},
+		{[]string{"id=abc"}, nil},
+		{[]string{"=web"}, nil},
+	}
+	for _, c := range cases {
+		filters, err := parseFilters(c.filterSlice)
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("filters %v are parsed as %v", c.filterSlice, filters)
+			}
+			continue
+		}
+		if err != nil || len(filters) != len(c.expected) {
+			t.Errorf("filters %v are parsed as %v, expect %v : %v", c.filterSlice, filters, c.expected, err)
+			continue
+		}
+		for key, values := range c.expected {
+			if len(filters[key]) != len(values) {
+				t.Errorf("filters %v are parsed as %v, expect %v", c.filterSlice, filters, c.expected)
+				continue
+			}
+			for i := range values {
+				if filters[key][i] != values[i] {
+					t.Errorf("filters %v are parsed as %v, expect %v", c.filterSlice, filters, c.expected)
+				}
+			}
+		}
+	}
+}
+
+/* values of the same key are ORed, and different keys ANDed. */
+func TestFiltersMatch(t *testing.T) {
+	item := &container.ContainerInfo{
+		Name:            "web-1",
+		Status:          container.RUNNING,
+		Labels:          map[string]string{"team": "infra", "debug": ""},
+		NetworkSettings: &container.NetworkSettings{Network: "br0"},
+	}
+	cases := map[string]struct {
+		filterSlice []string
+		matched     bool
+	}{
+		"none":              {nil, true},
+		"status":            {[]string{"status=running"}, true},
+		"other status":      {[]string{"status=exited"}, false},
+		"any status":        {[]string{"status=exited", "status=running"}, true},
+		"name substring":    {[]string{"name=web"}, true},
+		"other name":        {[]string{"name=db"}, false},
+		"label key":         {[]string{"label=debug"}, true},
+		"label value":       {[]string{"label=team=infra"}, true},
+		"other label value": {[]string{"label=team=web"}, false},
+		"network":           {[]string{"network=br0"}, true},
+		"other network":     {[]string{"network=host"}, false},
+		"all keys":          {[]string{"status=running", "name=web", "label=team=infra", "network=br0"}, true},
+		"one key fails":     {[]string{"status=running", "name=db"}, false},
+	}
+	for name, c := range cases {
+		filters, err := parseFilters(c.filterSlice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched := filters.match(item); matched != c.matched {
+			t.Errorf("%s filters %v match container is %v, expect %v", name, c.filterSlice, matched, c.matched)
+		}
+	}
+	if filters, _ := parseFilters([]string{"network=br0"}); filters.match(&container.ContainerInfo{}) {
+		t.Errorf("network filter matches container without network")
+	}
+}
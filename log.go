@@ -9,7 +9,12 @@ import (
 )
 
 func LogContainer(containerName string) {
-	containerLogDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		log.Errorf("Get container name %s error : %v", containerName, err)
+		return
+	}
+	containerLogDir := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
 	containerLogFileDir := containerLogDir + container.LogName
 	clf, err := os.Open(containerLogFileDir)
 	if err != nil {
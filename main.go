@@ -17,9 +17,38 @@ func main() {
 		listCommand,
 		logCommand,
 		execCommand,
+		topCommand,
+		diffCommand,
+		exportCommand,
+		inspectCommand,
 		stopCommand,
 		removeCommand,
 		networkCommand,
+		imagesCommand,
+		rmiCommand,
+		tagCommand,
+		historyCommand,
+		loadCommand,
+		saveCommand,
+		importCommand,
+		pullCommand,
+		pushCommand,
+		loginCommand,
+		volumeCommand,
+		ociInitCommand,
+		createCommand,
+		startCommand,
+		stateCommand,
+		killCommand,
+		deleteCommand,
+		specCommand,
+	}
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "storage-driver",
+			Usage:  "storage driver of new containers, detected from kernel if not given",
+			EnvVar: "TINYDOCKER_STORAGE_DRIVER",
+		},
 	}
 	app.Before = func(context *cli.Context) error {
 		log.SetFormatter(&log.JSONFormatter{})
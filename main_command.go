@@ -6,14 +6,21 @@ import (
 	"github.com/qqzeng/tinydocker/cgroups/subsystems"
 	"github.com/qqzeng/tinydocker/container"
 	"github.com/qqzeng/tinydocker/network"
+	"github.com/qqzeng/tinydocker/oci"
+	"github.com/qqzeng/tinydocker/storage"
+	"strings"
 	log "github.com/Sirupsen/logrus"
-	"os"
 )
 
 const (
 	Usage = "tinydocker is a simple container runtime implementation for learning purpose."
 	ENV_EXEC_PID = "tinydocker_pid"
-	ENV_EXEC_COMMAND = "tinydocker_command"
+	ENV_EXEC_WORKDIR = "tinydocker_workdir"
+	ENV_EXEC_UID = "tinydocker_uid"
+	ENV_EXEC_GID = "tinydocker_gid"
+	ENV_EXEC_GROUPS = "tinydocker_groups"
+	ENV_EXEC_STATUS_FD = "tinydocker_exec_status_fd"
+	ENV_SUPERVISOR = "tinydocker_supervisor"
 )
 
 var runCommand = cli.Command {
@@ -45,7 +52,69 @@ var runCommand = cli.Command {
 		if tty == detached {
 			return fmt.Errorf("option it and d can not be identical")
 		}
-		Run(tty, cmdArray, res, volumeStr, containerName, imageName, envSlice, network, portmapping)
+		labels, err := container.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+		if err != nil {
+			return err
+		}
+		healthConfig, err := parseHealthConfig(context.String("health-cmd"), context.String("health-interval"),
+			context.String("health-timeout"), context.String("health-start-period"),
+			context.Int("health-retries"), context.String("health-on-failure"))
+		if err != nil {
+			return err
+		}
+		if healthConfig != nil && tty && healthConfig.OnFailure == container.HealthActionRestart {
+			return fmt.Errorf("--health-on-failure restart requires a detached container")
+		}
+		ulimits, err := container.ParseUlimits(context.StringSlice("ulimit"))
+		if err != nil {
+			return err
+		}
+		/* besides namespace modes, a network name given by `--net` is joined in a private namespace. */
+		namespaces := map[string]string{}
+		for _, nsType := range []string{container.NamespaceNet, container.NamespacePid,
+			container.NamespaceIpc, container.NamespaceUts} {
+			mode := context.String(nsType)
+			if nsType == container.NamespaceNet && !isNamespaceMode(mode) {
+				continue
+			}
+			if namespaces[nsType], err = container.ParseNamespaceMode(nsType, mode); err != nil {
+				return err
+			}
+			if namespaces[nsType] == "" {
+				delete(namespaces, nsType)
+			}
+		}
+		if isNamespaceMode(network) {
+			network = ""
+		}
+		if namespaces[container.NamespaceCgroup], err = container.ParseNamespaceMode(container.NamespaceCgroup,
+			context.String("cgroupns")); err != nil {
+			return err
+		}
+		if namespaces[container.NamespaceCgroup] == "" {
+			delete(namespaces, container.NamespaceCgroup)
+		}
+		timeOffsets, err := container.ParseTimeOffsets(context.String("time-offset"))
+		if err != nil {
+			return err
+		}
+		driver, err := storage.New(context.GlobalString("storage-driver"))
+		if err != nil {
+			return err
+		}
+		if namespaces[container.NamespaceNet] != "" && len(portmapping) > 0 {
+			return fmt.Errorf("port mapping requires a private network namespace")
+		}
+		sysctls, err := container.ParseSysctls(context.StringSlice("sysctl"), namespaces)
+		if err != nil {
+			return err
+		}
+		storageOpts, err := storage.ParseStorageOpts(context.StringSlice("storage-opt"))
+		if err != nil {
+			return err
+		}
+		Run(tty, cmdArray, res, volumeStr, containerName, imageName, envSlice, network, portmapping, labels,
+			healthConfig, ulimits, sysctls, namespaces, timeOffsets, driver.String(), storageOpts)
 		return nil
 	},
 	Flags: [] cli.Flag {
@@ -85,15 +154,91 @@ var runCommand = cli.Command {
 		},
 		cli.StringFlag{
 			Name:  "net",
-			Usage: "container network",
+			Usage: "container network, or network namespace mode of host, private or container:<name|id>",
+		},
+		cli.StringFlag{
+			Name:  "pid",
+			Usage: "pid namespace mode of host, private or container:<name|id>",
+		},
+		cli.StringFlag{
+			Name:  "ipc",
+			Usage: "ipc namespace mode of host, private or container:<name|id>",
+		},
+		cli.StringFlag{
+			Name:  "uts",
+			Usage: "uts namespace mode of host, private or container:<name|id>",
+		},
+		cli.StringFlag{
+			Name:  "cgroupns",
+			Value: "private",
+			Usage: "cgroup namespace mode of host or private",
+		},
+		cli.StringSliceFlag{
+			Name:  "storage-opt",
+			Usage: "options of writable layer, e.g. size=10G to limit its size, which covers the copy of image with vfs",
+		},
+		cli.StringFlag{
+			Name:  "time-offset",
+			Usage: "run container in a time namespace with clock offsets, e.g. monotonic=1h,boottime=-30m",
 		},
 		cli.StringSliceFlag{
 			Name: "p",
 			Usage: "port mapping",
 		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "set metadata of container in form of key=value",
+		},
+		cli.StringSliceFlag{
+			Name:  "label-file",
+			Usage: "read metadata of container from a file of key=value lines",
+		},
+		cli.StringFlag{
+			Name:  "health-cmd",
+			Usage: "command run in container to check its health",
+		},
+		cli.StringFlag{
+			Name:  "health-interval",
+			Value: "30s",
+			Usage: "time between running the health check",
+		},
+		cli.StringFlag{
+			Name:  "health-timeout",
+			Value: "30s",
+			Usage: "maximum time to allow one health check to run",
+		},
+		cli.IntFlag{
+			Name:  "health-retries",
+			Value: 3,
+			Usage: "consecutive failures needed to report unhealthy",
+		},
+		cli.StringFlag{
+			Name:  "health-start-period",
+			Value: "0s",
+			Usage: "start period for container to initialize before counting failures",
+		},
+		cli.StringFlag{
+			Name:  "health-on-failure",
+			Value: "none",
+			Usage: "action taken once container is unhealthy, one of none, kill and restart",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "set resource limit of container in form of name=soft:hard, e.g. nofile=1024:4096",
+		},
+		cli.StringSliceFlag{
+			Name:  "sysctl",
+			Usage: "set namespaced kernel parameter of container in form of key=value",
+		},
 	},
 }
 
+/* whether value of `--net` is a namespace mode rather than a network name. */
+func isNamespaceMode(mode string) bool {
+	return mode == container.NamespaceModeHost || mode == container.NamespaceModePrivate ||
+		strings.HasPrefix(mode, container.NamespaceModeContainer)
+}
+
 var initCommand = cli.Command{
 	Name:                   "init",
 	Usage:                  "Init container process run user’s process in container.  Do not call it outside",
@@ -110,26 +255,89 @@ var initCommand = cli.Command{
 
 var commitCommand = cli.Command {
 	Name:                   "commit",
-	Usage:                  "Commit current running container into a image",
+	Usage:                  "Commit changes of container as a new layer of its image",
 	Action: func(context *cli.Context) error {
 		if context.NArg() < 2 {
 			return fmt.Errorf("missing container name and image name")
 		}
 		containerName := context.Args().Get (0)
 		imageName := context.Args().Get (1)
-		commitContainer(containerName, imageName)
+		labels, err := container.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+		if err != nil {
+			return err
+		}
+		options := commitOptions{
+			message: context.String("message"),
+			author:  context.String("author"),
+			changes: context.StringSlice("change"),
+			pause:   context.Bool("pause"),
+		}
+		commitContainer(containerName, imageName, labels, options)
 		return nil
 	},
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "set metadata of image in form of key=value",
+		},
+		cli.StringSliceFlag{
+			Name:  "label-file",
+			Usage: "read metadata of image from a file of key=value lines",
+		},
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "commit message",
+		},
+		cli.StringFlag{
+			Name:  "author, a",
+			Usage: "author of image, e.g. \"name <email>\"",
+		},
+		cli.StringSliceFlag{
+			Name:  "change, c",
+			Usage: "apply a Dockerfile instruction to image, one of CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, USER, VOLUME and WORKDIR",
+		},
+		cli.BoolFlag{
+			Name:  "pause, p",
+			Usage: "freeze container while committing",
+		},
+	},
 
 }
 
 var listCommand = cli.Command{
 	Name:                   "ps",
-	Usage:                  "List all containers in any status",
+	Usage:                  "List containers, only running ones unless -a is given",
 	Action: func(context *cli.Context) error {
-		ListContainers()
+		ListContainers(context.Bool("a"), context.Bool("q"), context.StringSlice("filter"),
+			context.String("format"), context.Bool("no-trunc"), context.Bool("size"))
 		return nil
 	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "show all containers in any status",
+		},
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "only display container ids",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "filter output by status=, name=, label= or network=",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "format output using a Go template, prefixed with `table ` to print headers",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate id and command",
+		},
+		cli.BoolFlag{
+			Name:  "size",
+			Usage: "display size of writable layer and image",
+		},
+	},
 }
 
 var logCommand = cli.Command{
@@ -147,12 +355,35 @@ var logCommand = cli.Command{
 
 var execCommand = cli.Command{
 	Name:                   "exec",
-	Usage:                  "Execute a command in given container",
+	Usage:                  "Execute a command in given container tinydocker exec [-it|-d] container command [args...]",
+	Description: "Use `exec ls container` to list exec sessions of container and " +
+		"`exec kill container exec-id` to terminate a running one, any other command is executed.",
+	/* the flags of executing command, e.g. `ls -l`, follow container name and are left to it. */
+	Subcommands: []cli.Command{
+		{
+			Name:  "ls",
+			Usage: "List exec sessions of a container",
+			Action: func(context *cli.Context) error {
+				if context.NArg() != 1 {
+					return fmt.Errorf("exec ls takes exactly one container name")
+				}
+				ListExecSessions(context.Args().Get(0))
+				return nil
+			},
+		},
+		{
+			Name:  "kill",
+			Usage: "Terminate a running exec session of a container by its id or unique id prefix",
+			Action: func(context *cli.Context) error {
+				if context.NArg() != 2 {
+					return fmt.Errorf("exec kill takes exactly a container name and an exec id")
+				}
+				KillExecSession(context.Args().Get(0), context.Args().Get(1))
+				return nil
+			},
+		},
+	},
 	Action: func(context *cli.Context) error {
-		if os.Getenv(ENV_EXEC_PID) != "" {
-			log.Infof("pid callback %v", os.Getgid())
-			return nil
-		}
 		if context.NArg() < 2 {
 			return fmt.Errorf("missing container name or command")
 		}
@@ -161,9 +392,102 @@ var execCommand = cli.Command{
 		for _, arg := range context.Args().Tail() {
 			comArray = append(comArray, arg)
 		}
-		ExecContainer(containerName, comArray)
+		tty := context.Bool("it")
+		detached := context.Bool("d")
+		if tty && detached {
+			return fmt.Errorf("option it and d can not be both set")
+		}
+		exitCode := ExecContainer(containerName, comArray, tty, detached,
+			context.StringSlice("e"), context.String("w"), context.String("u"))
+		if exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
+		return nil
+	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "it",
+			Usage: "keep STDIN open and allocate a pseudo tty",
+		},
+		cli.BoolFlag{
+			Name:  "d",
+			Usage: "detach command and run it in background",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment variables",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "username or uid, in form of <name|uid>[:<group|gid>]",
+		},
+	},
+}
+
+var topCommand = cli.Command{
+	Name:                   "top",
+	Usage:                  "Display the running processes of a container tinydocker top container [-o columns]",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get (0)
+		TopContainer(containerName, context.String("o"))
+		return nil
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Value: DefaultTopColumns,
+			Usage: "comma separated columns among pid, cpid, ppid, uid, user, stat, time, comm and args",
+		},
+	},
+}
+
+var diffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "Show files added (A), changed (C) or deleted (D) by a container, tinydocker diff container",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		DiffContainer(context.Args().Get(0), context.String("format"))
+		return nil
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "print changes as a json array with --format json",
+		},
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name:                   "inspect",
+	Usage:                  "Display detailed information of containers, networks or images",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container, network or image name")
+		}
+		if failed := Inspect(context.Args(), context.String("type"), context.String("format")); failed > 0 {
+			return cli.NewExitError("", 1)
+		}
 		return nil
 	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Usage: "format the output using the given Go template, e.g. '{{.NetworkSettings.IPAddress}}'",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "only inspect objects of given type, one of container, network and image",
+		},
+	},
 }
 
 var stopCommand = cli.Command{
@@ -208,6 +532,14 @@ var networkCommand = cli.Command{
 					Name:  "subnet",
 					Usage: "subnet cidr",
 				},
+				cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "set metadata of network in form of key=value",
+				},
+				cli.StringSliceFlag{
+					Name:  "label-file",
+					Usage: "read metadata of network from a file of key=value lines",
+				},
 			},
 			Action:func(context *cli.Context) error {
 				if context.NArg() < 1 {
@@ -217,16 +549,29 @@ var networkCommand = cli.Command{
 				driver := context.String("driver")
 				subnet := context.String("subnet")
 				name := context.Args().Get (0)
-				err := network.CreateNetwork(driver, subnet, name)
-				return err
+				labels, err := container.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+				if err != nil {
+					return err
+				}
+				return network.CreateNetwork(driver, subnet, name, labels)
 			},
 		},
 		{
 			Name: "list",
 			Usage: "Display container network list",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "filter",
+					Usage: "filter networks by label, e.g. label=team or label=team=infra",
+				},
+			},
 			Action:func(context *cli.Context) error {
+				labelFilters, err := container.ParseLabelFilters(context.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
 				network.Init()
-				network.ListNetwork()
+				network.ListNetwork(labelFilters)
 				return nil
 			},
 		},
@@ -244,4 +589,388 @@ var networkCommand = cli.Command{
 			},
 		},
 	},
-}
\ No newline at end of file
+}
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "List images",
+	Action: func(context *cli.Context) error {
+		filters, err := parseImageFilters(context.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		ListImages(context.Bool("q"), context.Bool("no-trunc"), filters)
+		return nil
+	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "only display image ids",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate image ids",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "filter images by dangling=true|false, label=key[=value], reference=pattern, before=image or since=image",
+		},
+	},
+}
+
+var rmiCommand = cli.Command{
+	Name:  "rmi",
+	Usage: "Remove images by reference or id, tinydocker rmi [-f] image...",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		if failed := RemoveImages(context.Args(), context.Bool("f")); failed > 0 {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "remove image even if it is used by containers or referenced by several repositories",
+		},
+	},
+}
+
+var tagCommand = cli.Command{
+	Name:  "tag",
+	Usage: "Create a reference to an image, tinydocker tag source target[:tag]",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 2 {
+			return fmt.Errorf("missing source image and target reference")
+		}
+		return TagImage(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+var historyCommand = cli.Command{
+	Name:  "history",
+	Usage: "Show the layers of an image and the commands created them",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return ImageHistory(context.Args().Get(0), context.Bool("q"), context.Bool("no-trunc"))
+	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "only display image ids",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate output",
+		},
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "Load images from a Docker archive or OCI image layout tarball",
+	Action: func(context *cli.Context) error {
+		return LoadImages(context.String("i"), context.Bool("q"))
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "input, i",
+			Usage: "read from tarball instead of stdin",
+		},
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "suppress the loaded images",
+		},
+	},
+}
+
+var saveCommand = cli.Command{
+	Name:  "save",
+	Usage: "Save images to a tarball, tinydocker save -o file.tar image...",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		keepStdoutForOutput(context.String("o"))
+		return SaveImages(context.Args(), context.String("o"))
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "write to file instead of stdout",
+		},
+	},
+}
+
+var exportCommand = cli.Command{
+	Name:  "export",
+	Usage: "Export the root filesystem of container to a tarball, tinydocker export -o rootfs.tar container",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		keepStdoutForOutput(context.String("o"))
+		return ExportContainer(context.Args().Get(0), context.String("o"))
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "write to file instead of stdout",
+		},
+	},
+}
+
+var importCommand = cli.Command{
+	Name:  "import",
+	Usage: "Create an image from a root filesystem tarball, tinydocker import file|- [name[:tag]]",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing tarball, use - to read from stdin")
+		}
+		return ImportImage(context.Args().Get(0), context.Args().Get(1),
+			context.StringSlice("change"), context.String("message"))
+	},
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "change, c",
+			Usage: "apply a Dockerfile instruction to image, one of CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, USER, VOLUME and WORKDIR",
+		},
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "commit message of imported image",
+		},
+	},
+}
+
+var pullCommand = cli.Command{
+	Name:  "pull",
+	Usage: "Pull an image from a registry, tinydocker pull [host/]name[:tag|@digest]",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return PullImage(context.Args().Get(0), context.Bool("q"))
+	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "suppress the progress",
+		},
+	},
+}
+
+var pushCommand = cli.Command{
+	Name:  "push",
+	Usage: "Push an image to a registry, tinydocker push [host/]name[:tag]",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing image name")
+		}
+		return PushImage(context.Args().Get(0))
+	},
+}
+
+var loginCommand = cli.Command{
+	Name:  "login",
+	Usage: "Log in to a registry, Docker Hub by default, tinydocker login -u user [server]",
+	Action: func(context *cli.Context) error {
+		return Login(context.Args().Get(0), context.String("u"), context.String("p"), context.Bool("password-stdin"))
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "username, u",
+			Usage: "username of registry",
+		},
+		cli.StringFlag{
+			Name:  "password, p",
+			Usage: "password of registry",
+		},
+		cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "read password from stdin",
+		},
+	},
+}
+
+var volumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "Container volume commands",
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",
+			Usage: "Create a named volume",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "set metadata of volume in form of key=value",
+				},
+				cli.StringSliceFlag{
+					Name:  "label-file",
+					Usage: "read metadata of volume from a file of key=value lines",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if context.NArg() < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				labels, err := container.ParseLabels(context.StringSlice("label"), context.StringSlice("label-file"))
+				if err != nil {
+					return err
+				}
+				return CreateVolume(context.Args().Get(0), labels)
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "Display named volume list",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "q",
+					Usage: "only display volume names",
+				},
+				cli.StringSliceFlag{
+					Name:  "filter",
+					Usage: "filter volumes by label, e.g. label=team or label=team=infra",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				labelFilters, err := container.ParseLabelFilters(context.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
+				ListVolumes(context.Bool("q"), labelFilters)
+				return nil
+			},
+		},
+		{
+			Name:  "remove",
+			Usage: "Remove a named volume and its data",
+			Action: func(context *cli.Context) error {
+				if context.NArg() < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				return RemoveVolume(context.Args().Get(0))
+			},
+		},
+	},
+}
+
+var ociInitCommand = cli.Command{
+	Name:  "oci-init",
+	Usage: "Init process of OCI container. Do not call it outside",
+	Action: func(context *cli.Context) error {
+		/* errors are reported to `create` through sync pipe, stdout belongs to container. */
+		if err := oci.InitProcess(); err != nil {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	},
+}
+
+var createCommand = cli.Command{
+	Name:      "create",
+	Usage:     "Create a container from an OCI bundle, which waits for `start` to run its process",
+	ArgsUsage: "<container-id>",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return oci.Create(context.Args().Get(0), context.String("bundle"),
+			context.String("console-socket"), context.String("pid-file"))
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: ".",
+			Usage: "path to the bundle directory containing config.json and root filesystem",
+		},
+		cli.StringFlag{
+			Name:  "console-socket",
+			Usage: "path to a unix socket receiving the pty master, required when process.terminal is true",
+		},
+		cli.StringFlag{
+			Name:  "pid-file",
+			Usage: "file to write the pid of container process to",
+		},
+	},
+}
+
+var startCommand = cli.Command{
+	Name:      "start",
+	Usage:     "Run the user process of a created OCI container",
+	ArgsUsage: "<container-id>",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return oci.Start(context.Args().Get(0))
+	},
+}
+
+var stateCommand = cli.Command{
+	Name:      "state",
+	Usage:     "Output the state of an OCI container",
+	ArgsUsage: "<container-id>",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return oci.PrintState(context.Args().Get(0))
+	},
+}
+
+var killCommand = cli.Command{
+	Name:      "kill",
+	Usage:     "Send a signal to the process of an OCI container, SIGTERM by default",
+	ArgsUsage: "<container-id> [signal]",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		sigStr := "SIGTERM"
+		if context.NArg() > 1 {
+			sigStr = context.Args().Get(1)
+		}
+		sig, err := oci.ParseSignal(sigStr)
+		if err != nil {
+			return err
+		}
+		return oci.Kill(context.Args().Get(0), sig)
+	},
+}
+
+var deleteCommand = cli.Command{
+	Name:      "delete",
+	Usage:     "Delete a stopped OCI container",
+	ArgsUsage: "<container-id>",
+	Action: func(context *cli.Context) error {
+		if context.NArg() < 1 {
+			return fmt.Errorf("missing container id")
+		}
+		return oci.Delete(context.Args().Get(0), context.Bool("force"))
+	},
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "kill the container first if it is not stopped",
+		},
+	},
+}
+
+var specCommand = cli.Command{
+	Name:  "spec",
+	Usage: "Generate a default OCI config.json in the bundle directory",
+	Action: func(context *cli.Context) error {
+		return oci.WriteDefaultSpec(context.String("bundle"))
+	},
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: ".",
+			Usage: "path to the bundle directory",
+		},
+	},
+}
@@ -70,7 +70,15 @@ func (bnd *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) er
 	return nil
 }
 
+/* the veth of endpoint goes with the network namespace of container, unless it is still left. */
 func (bnd *BridgeNetworkDriver) Disconnect(network *Network, endpoint *Endpoint) error {
+	iface, err := netlink.LinkByName(endpoint.Id[:5])
+	if err != nil {
+		return nil
+	}
+	if err := netlink.LinkDel(iface); err != nil {
+		return fmt.Errorf("fail to remove endpoint interface %s : %v", endpoint.Id[:5], err)
+	}
 	return nil
 }
 
@@ -28,6 +28,7 @@ type Network struct {
 	Name string			/* name of network */
 	IpRange *net.IPNet	/* the range of ip address of network */
 	Driver string 		/* driver name of network */
+	Labels map[string]string	/* user defined metadata of network */
 }
 
 type Endpoint struct {
@@ -53,7 +54,7 @@ type NetworkDriver interface {
 }
 
 /* create a network */
-func CreateNetwork(driver, subnet, name string) error {
+func CreateNetwork(driver, subnet, name string, labels map[string]string) error {
 	_, ipNet, err := net.ParseCIDR(subnet)
 	if err != nil {
 		return fmt.Errorf("fail to parse cidr ip address %s : %v", subnet, err)
@@ -67,6 +68,7 @@ func CreateNetwork(driver, subnet, name string) error {
 	if err != nil {
 		return fmt.Errorf("fail to create network for cidr ip address %s : %v", subnet, err)
 	}
+	nw.Labels = labels
 	return nw.dump(defaultNetworkPath)
 }
 
@@ -94,10 +96,52 @@ func Connect(nwName string, cInfo *container.ContainerInfo) error {
 	if err := configPortMapping(ep, cInfo); err != nil {
 		return fmt.Errorf("fail to configure port mapping for endpoint and network : %v", err)
 	}
+	cInfo.NetworkSettings = &container.NetworkSettings{
+		Network:     nwName,
+		EndpointId:  ep.Id,
+		IPAddress:   ep.IPAddress.String(),
+		Gateway:     nw.IpRange.IP.String(),
+		MacAddress:  ep.MacAddress.String(),
+		PortMapping: ep.PortMapping,
+	}
 	return nil
 }
 
+/*
+	release the ip address recorded in network settings of container and remove its port mappings,
+	e.g. before it is connected again on restart.
+*/
 func Disconnect(nwName string, cInfo *container.ContainerInfo) error {
+	nw, ok := networks[nwName]
+	if !ok {
+		return fmt.Errorf("fail to retrive network %s", nwName)
+	}
+	settings := cInfo.NetworkSettings
+	if settings == nil || settings.Network != nwName {
+		return nil
+	}
+	ip := net.ParseIP(settings.IPAddress)
+	if ip == nil {
+		return fmt.Errorf("invalid ip address %s of container %s", settings.IPAddress, cInfo.Id)
+	}
+	ep := &Endpoint{
+		Id:          settings.EndpointId,
+		IPAddress:   ip,
+		PortMapping: settings.PortMapping,
+		Network:     nw,
+	}
+	if err := drivers[nw.Driver].Disconnect(nw, ep); err != nil {
+		return fmt.Errorf("fail to disconnect endpoint from network %s : %v", nwName, err)
+	}
+	if err := setPortMapping("-D", ep); err != nil {
+		return fmt.Errorf("fail to remove port mapping of endpoint : %v", err)
+	}
+	/* Release changes the address given, so it gets a copy. */
+	releasedIp := append(net.IP(nil), ip.To4()...)
+	if err := ipAllocator.Release(nw.IpRange, &releasedIp); err != nil {
+		return fmt.Errorf("fail to release ip address %s : %v", settings.IPAddress, err)
+	}
+	cInfo.NetworkSettings = nil
 	return nil
 }
 
@@ -131,10 +175,20 @@ func Init() error {
 	return nil
 }
 
-func ListNetwork() {
+/* get a loaded network by name. */
+func GetNetwork(nwName string) (*Network, bool) {
+	nw, ok := networks[nwName]
+	return nw, ok
+}
+
+/* list networks whose labels match every label filter. */
+func ListNetwork(labelFilters []string) {
 	w := tabwriter.NewWriter(os.Stdout, 12 ,  1,  3,' ', 0)
 	fmt.Fprintf(w, "Name\tIpRange\tDriver\n")
 	for _, nw := range networks {
+		if !container.MatchLabels(nw.Labels, labelFilters) {
+			continue
+		}
 		fmt.Fprintf(w, "%s\t%s\t%s\n", nw.Name, nw.IpRange.String(), nw.Driver)
 	}
 	if err := w.Flush(); err != nil {
@@ -251,6 +305,10 @@ func configEndpointIpAddressAndRoute(ep *Endpoint,  cInfo *container.ContainerIn
 	if err = setInterfaceUp("lo"); err != nil {
 		return fmt.Errorf("fail to enable endpoint loopback : %v", err)
 	}
+	/* the link is moved into container network namespace, look it up again for its mac address. */
+	if link, err := netlink.LinkByName(peerName); err == nil {
+		ep.MacAddress = link.Attrs().HardwareAddr
+	}
 	_, ipNet, _ := net.ParseCIDR("0.0.0.0/0")
 	defaultRoute := &netlink.Route{
 		LinkIndex: peerLink.Attrs().Index,
@@ -289,13 +347,18 @@ func enterContainerNetNs(enLink *netlink.Link,  cInfo *container.ContainerInfo)
 }
 
 func configPortMapping(ep *Endpoint, cInfo *container.ContainerInfo) error {
+	return setPortMapping("-A", ep)
+}
+
+/* add or delete, by action -A or -D, the DNAT rules of port mappings of endpoint. */
+func setPortMapping(action string, ep *Endpoint) error {
 	for _, pm := range ep.PortMapping {
 		portMapping := strings.Split(pm, ":")
 		if len(portMapping) != 2 {
 			return fmt.Errorf("fail to parse portmapping array : %s\n", pm)
 		}
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			action, portMapping[0], ep.IPAddress.String(), portMapping[1])
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		output, err := cmd.Output()
 		if err != nil {
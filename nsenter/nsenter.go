@@ -5,41 +5,281 @@ package nsenter
 #include <unistd.h>
 #include <errno.h>
 #include <sched.h>
+#include <signal.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/stat.h>
+#include <sys/types.h>
+#include <sys/wait.h>
 
-__attribute__((constructor)) void  enter_namespace(void)  {
-	char *tinydocker_pid = "";
-	tinydocker_pid = getenv("tinydocker_pid");
-	//int log_fd = open("/var/run/tinydocker/nsenter.log", O_WRONLY | O_CREAT | O_TRUNC, 0644);
-	if (!tinydocker_pid) {
-	    fprintf(stdout, "fail to get pid of container \n");
-		return;
+#define NS_PATH_MAX 1024
+#define CMDLINE_MAX 131072
+
+static pid_t child_pid = -1;
+
+static void forward_signal(int sig) {
+	if (child_pid > 0) {
+		kill(child_pid, sig);
+	}
+}
+
+// read the argv of the executing command, which follows the first "--" of /proc/self/cmdline.
+static char **read_command(void) {
+	static char buf[CMDLINE_MAX];
+	int fd = open("/proc/self/cmdline", O_RDONLY);
+	if (fd < 0) {
+		return NULL;
+	}
+	ssize_t total = 0, n;
+	while ((n = read(fd, buf + total, sizeof(buf) - 1 - total)) > 0) {
+		total += n;
+	}
+	close(fd);
+	buf[total] = '\0';
+
+	char **argv = calloc(total + 1, sizeof(char *));
+	int argc = 0, found = 0;
+	char *p = buf;
+	while (p < buf + total) {
+		if (found) {
+			argv[argc++] = p;
+		} else if (strcmp(p, "--") == 0) {
+			found = 1;
+		}
+		p += strlen(p) + 1;
+	}
+	if (argc == 0) {
+		free(argv);
+		return NULL;
+	}
+	argv[argc] = NULL;
+	return argv;
+}
+
+// whether buf holds the given line as a whole.
+static int contains_line(const char *buf, const char *line) {
+	const char *p = buf;
+	while ((p = strstr(p, line)) != NULL) {
+		if (p == buf || p[-1] == '\n') {
+			return 1;
+		}
+		p++;
+	}
+	return 0;
+}
+
+// move current process into every cgroup hierarchy the container process belongs to.
+static int join_cgroups(const char *pid) {
+	char path[NS_PATH_MAX], line[NS_PATH_MAX], self[NS_PATH_MAX * 4];
+	snprintf(path, sizeof(path), "/proc/%s/cgroup", pid);
+	FILE *cf = fopen(path, "r");
+	if (!cf) {
+		fprintf(stderr, "open cgroup file %s error : %s\n", path, strerror(errno));
+		return -1;
+	}
+	self[0] = '\0';
+	FILE *sf = fopen("/proc/self/cgroup", "r");
+	if (sf) {
+		size_t n = fread(self, 1, sizeof(self) - 1, sf);
+		self[n] = '\0';
+		fclose(sf);
+	}
+	while (fgets(line, sizeof(line), cf)) {
+		// skip hierarchies we are already a member of.
+		if (contains_line(self, line)) {
+			continue;
+		}
+		line[strcspn(line, "\n")] = '\0';
+		char *controllers = strchr(line, ':');
+		if (!controllers) {
+			continue;
+		}
+		controllers++;
+		char *cgpath = strchr(controllers, ':');
+		if (!cgpath) {
+			continue;
+		}
+		*cgpath++ = '\0';
+		char procs[NS_PATH_MAX * 2];
+		if (controllers[0] == '\0') {
+			snprintf(procs, sizeof(procs), "/sys/fs/cgroup%s/cgroup.procs", cgpath);
+		} else {
+			if (strncmp(controllers, "name=", 5) == 0) {
+				controllers += 5;
+			}
+			snprintf(procs, sizeof(procs), "/sys/fs/cgroup/%s%s/cgroup.procs", controllers, cgpath);
+		}
+		int fd = open(procs, O_WRONLY);
+		if (fd < 0) {
+			// the hierarchy is not mounted at the conventional place, nothing to join.
+			if (errno == ENOENT) {
+				continue;
+			}
+			fprintf(stderr, "open cgroup %s error : %s\n", procs, strerror(errno));
+			fclose(cf);
+			return -1;
+		}
+		char self_pid[32];
+		int len = snprintf(self_pid, sizeof(self_pid), "%d", getpid());
+		if (write(fd, self_pid, len) != len) {
+			fprintf(stderr, "join cgroup %s error : %s\n", procs, strerror(errno));
+			close(fd);
+			fclose(cf);
+			return -1;
+		}
+		close(fd);
+	}
+	fclose(cf);
+	return 0;
+}
+
+// join a namespace of the container process, skip it if we are already a member of it.
+static int join_namespace(const char *pid, const char *ns) {
+	char nspath[NS_PATH_MAX], selfpath[NS_PATH_MAX];
+	struct stat target, self;
+	snprintf(nspath, sizeof(nspath), "/proc/%s/ns/%s", pid, ns);
+	snprintf(selfpath, sizeof(selfpath), "/proc/self/ns/%s", ns);
+	// cgroup and time namespaces are missing in old kernels.
+	if (stat(selfpath, &self) == -1 && errno == ENOENT) {
+		return 0;
+	}
+	if (stat(nspath, &target) == -1) {
+		fprintf(stderr, "stat %s namespace error : %s\n", nspath, strerror(errno));
+		return -1;
+	}
+	if (stat(selfpath, &self) == 0 && self.st_ino == target.st_ino && self.st_dev == target.st_dev) {
+		return 0;
+	}
+	int fd = open(nspath, O_RDONLY);
+	if (fd < 0) {
+		fprintf(stderr, "open %s namespace error : %s\n", nspath, strerror(errno));
+		return -1;
 	}
+	if (setns(fd, 0) == -1) {
+		fprintf(stderr, "setns on %s namespace error : %s\n", ns, strerror(errno));
+		close(fd);
+		return -1;
+	}
+	close(fd);
+	return 0;
+}
 
-	char *tinydocker_command = "";
-	tinydocker_command = getenv("tinydocker_command");
-	if (!tinydocker_command) {
-		fprintf(stdout, "fail to get command of container \n");
+// parse a comma separated gid list such as "10,20".
+static int parse_groups(const char *str, gid_t *groups, int max) {
+	int n = 0;
+	while (str && *str && n < max) {
+		groups[n++] = (gid_t)strtoul(str, (char **)&str, 10);
+		if (*str == ',') {
+			str++;
+		}
+	}
+	return n;
+}
+
+// record state of executing command to the status file handed over by tinydocker.
+static void write_status(int fd, const char *key, int value) {
+	if (fd >= 0) {
+		dprintf(fd, "%s %d\n", key, value);
+	}
+}
+
+__attribute__((constructor)) void enter_namespace(void) {
+	char *tinydocker_pid = getenv("tinydocker_pid");
+	if (!tinydocker_pid) {
 		return;
 	}
+	char **argv = read_command();
+	if (!argv) {
+		fprintf(stderr, "fail to get command of container\n");
+		exit(126);
+	}
+	char *workdir = getenv("tinydocker_workdir");
+	char *uid = getenv("tinydocker_uid");
+	char *gid = getenv("tinydocker_gid");
+	char *groups = getenv("tinydocker_groups");
+	char *status_fd_str = getenv("tinydocker_exec_status_fd");
+	int status_fd = status_fd_str ? atoi(status_fd_str) : -1;
+	if (status_fd >= 0) {
+		fcntl(status_fd, F_SETFD, FD_CLOEXEC);
+	}
 
+	// cgroup files are only reachable before we enter the mount namespace of container.
+	if (join_cgroups(tinydocker_pid) == -1) {
+		exit(126);
+	}
 	int i = 0;
-	char nspath[1024];
-	char *namespace[] = {"ipc", "uts", "pid", "net", "mnt"};
-	for (i = 0; i < 5; i++) {
-		sprintf(nspath, "/proc/%s/ns/%s", tinydocker_pid, namespace[i]);
-		int fd = open(nspath, O_RDONLY);
-		if (setns(fd, 0) == -1) {
-			fprintf(stderr, "setns on %s namespace error : %s\n", namespace[i], strerror(errno));
+	char *namespace[] = {"user", "ipc", "uts", "net", "pid", "cgroup", "time", "mnt"};
+	for (i = 0; i < 8; i++) {
+		if (join_namespace(tinydocker_pid, namespace[i]) == -1) {
+			exit(126);
+		}
+	}
+	if (chdir(workdir && *workdir ? workdir : "/") == -1) {
+		fprintf(stderr, "chdir to %s error : %s\n", workdir, strerror(errno));
+		exit(126);
+	}
+
+	// the user command gets terminal signals by itself, forward the others.
+	signal(SIGINT, SIG_IGN);
+	signal(SIGQUIT, SIG_IGN);
+	signal(SIGTERM, forward_signal);
+	signal(SIGHUP, forward_signal);
+	signal(SIGUSR1, forward_signal);
+	signal(SIGUSR2, forward_signal);
+
+	// setns on pid namespace only takes effect on children, so fork before executing.
+	child_pid = fork();
+	if (child_pid == -1) {
+		fprintf(stderr, "fork error : %s\n", strerror(errno));
+		exit(126);
+	}
+	if (child_pid == 0) {
+		int sig;
+		for (sig = 1; sig < NSIG; sig++) {
+			signal(sig, SIG_DFL);
+		}
+		if (gid && *gid) {
+			gid_t gids[64];
+			int n = parse_groups(groups, gids, 64);
+			if (setgroups(n, gids) == -1) {
+				fprintf(stderr, "setgroups error : %s\n", strerror(errno));
+				exit(126);
+			}
+			if (setgid((gid_t)strtoul(gid, NULL, 10)) == -1) {
+				fprintf(stderr, "setgid to %s error : %s\n", gid, strerror(errno));
+				exit(126);
+			}
+		}
+		if (uid && *uid && setuid((uid_t)strtoul(uid, NULL, 10)) == -1) {
+			fprintf(stderr, "setuid to %s error : %s\n", uid, strerror(errno));
+			exit(126);
+		}
+		unsetenv("tinydocker_pid");
+		unsetenv("tinydocker_workdir");
+		unsetenv("tinydocker_uid");
+		unsetenv("tinydocker_gid");
+		unsetenv("tinydocker_groups");
+		unsetenv("tinydocker_exec_status_fd");
+		execvp(argv[0], argv);
+		int err = errno;
+		fprintf(stderr, "exec %s error : %s\n", argv[0], strerror(err));
+		exit(err == ENOENT ? 127 : 126);
+	}
+
+	write_status(status_fd, "pid", child_pid);
+	int status;
+	while (waitpid(child_pid, &status, 0) == -1) {
+		if (errno != EINTR) {
+			fprintf(stderr, "wait command error : %s\n", strerror(errno));
+			exit(126);
 		}
-		close(fd);
 	}
-	int res = system(tinydocker_command);
-	exit(0);
-	return;
+	int exit_code = WIFSIGNALED(status) ? 128 + WTERMSIG(status) : WEXITSTATUS(status);
+	write_status(status_fd, "exit", exit_code);
+	exit(exit_code);
 }
 */
 import "C"
@@ -0,0 +1,164 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	/* every `*.json` in the directory holds hooks in the form of `hooks` of config.json. */
+	HooksDir           = "/etc/tinydocker/hooks.d"
+	DefaultHookTimeout = 30
+	/* how long output of a killed hook is waited for, a daemon forked by it may keep it open. */
+	hookKillGrace = time.Second
+)
+
+/* hooks of these stages run after container starts or stops, a failure does not stop the others. */
+var warnOnlyStages = map[string]bool{"poststart": true, "poststop": true}
+
+/* refer: https://github.com/opencontainers/runtime-spec/blob/master/config.md#posix-platform-hooks */
+type Hooks struct {
+	Prestart        []Hook `json:"prestart,omitempty"`
+	CreateRuntime   []Hook `json:"createRuntime,omitempty"`
+	CreateContainer []Hook `json:"createContainer,omitempty"`
+	StartContainer  []Hook `json:"startContainer,omitempty"`
+	Poststart       []Hook `json:"poststart,omitempty"`
+	Poststop        []Hook `json:"poststop,omitempty"`
+}
+
+type Hook struct {
+	Path    string   `json:"path"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Timeout *int     `json:"timeout,omitempty"`
+}
+
+/* hooks of global hooks directory go first, followed by those of container. */
+func MergeHooks(global *Hooks, local *Hooks) *Hooks {
+	merged := &Hooks{}
+	for _, h := range []*Hooks{global, local} {
+		if h == nil {
+			continue
+		}
+		merged.Prestart = append(merged.Prestart, h.Prestart...)
+		merged.CreateRuntime = append(merged.CreateRuntime, h.CreateRuntime...)
+		merged.CreateContainer = append(merged.CreateContainer, h.CreateContainer...)
+		merged.StartContainer = append(merged.StartContainer, h.StartContainer...)
+		merged.Poststart = append(merged.Poststart, h.Poststart...)
+		merged.Poststop = append(merged.Poststop, h.Poststop...)
+	}
+	return merged
+}
+
+/* load hooks of global hooks directory in file name order, a missing directory has no hooks. */
+func LoadGlobalHooks() (*Hooks, error) {
+	return loadHooksDir(HooksDir)
+}
+
+func loadHooksDir(dir string) (*Hooks, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	global := &Hooks{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read hooks file %s error : %v", file, err)
+		}
+		var hooks Hooks
+		if err := json.Unmarshal(content, &hooks); err != nil {
+			return nil, fmt.Errorf("unmarshal hooks file %s error : %v", file, err)
+		}
+		global = MergeHooks(global, &hooks)
+	}
+	return global, nil
+}
+
+/*
+	run hooks in order with state on stdin. As the runtime spec says, the first failure stops the
+	stage and is returned, except for poststart and poststop, whose failures are warned and the
+	remaining hooks still run.
+*/
+func RunHooks(stage string, hooks []Hook, state *PublicState) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal state for %s hooks error : %v", stage, err)
+	}
+	for _, hook := range hooks {
+		if err := runHook(hook, stateBytes); err != nil {
+			if warnOnlyStages[stage] {
+				log.Warnf("%s hook %s error : %v", stage, hook.Path, err)
+				continue
+			}
+			return fmt.Errorf("%s hook %s error : %v", stage, hook.Path, err)
+		}
+	}
+	return nil
+}
+
+func runHook(hook Hook, stateBytes []byte) error {
+	cmd := exec.Command(hook.Path)
+	/* args[0] of hook is its argv[0], as execv(3) does. */
+	if len(hook.Args) > 0 {
+		cmd.Args = hook.Args
+	}
+	cmd.Env = hook.Env
+	cmd.Stdin = bytes.NewReader(stateBytes)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	/* hook leads its own process group, so that processes it forks are killed with it. */
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timeout := DefaultHookTimeout
+	if hook.Timeout != nil {
+		if *hook.Timeout <= 0 {
+			killHook(cmd)
+			cmd.Wait()
+			return fmt.Errorf("timeout must be greater than zero")
+		}
+		timeout = *hook.Timeout
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			if msg := strings.TrimSpace(output.String()); msg != "" {
+				return fmt.Errorf("%v: %s", err, msg)
+			}
+			return err
+		}
+		return nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		killHook(cmd)
+		/* Wait returns only once output is closed, which a process out of the group may hold. */
+		select {
+		case <-done:
+		case <-time.After(hookKillGrace):
+		}
+		return fmt.Errorf("timeout after %d seconds", timeout)
+	}
+}
+
+func killHook(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,165 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/qqzeng/tinydocker/container"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHooksDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+/* a hook running script by sh, with args[0] as its argv[0]. */
+func shellHook(script string) Hook {
+	return Hook{Path: "/bin/sh", Args: []string{"sh", "-c", script}}
+}
+
+/* the first failure stops the stage, except for poststart and poststop which run every hook. */
+func TestRunHooksFailure(t *testing.T) {
+	dir, cleanup := newHooksDir(t)
+	defer cleanup()
+	cases := map[string]string{
+		"prestart":        "1\n",
+		"createRuntime":   "1\n",
+		"createContainer": "1\n",
+		"startContainer":  "1\n",
+		"poststart":       "1\n2\n",
+		"poststop":        "1\n2\n",
+	}
+	for stage, expected := range cases {
+		record := filepath.Join(dir, stage)
+		hooks := []Hook{
+			shellHook("echo 1 >> " + record),
+			shellHook("echo failed; exit 3"),
+			shellHook("echo 2 >> " + record),
+		}
+		err := RunHooks(stage, hooks, &PublicState{ID: "c1"})
+		if warnOnlyStages[stage] != (err == nil) {
+			t.Errorf("%s hooks with a failure return %v", stage, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "failed") {
+			t.Errorf("error of %s hook has no output of hook : %v", stage, err)
+		}
+		if content, _ := ioutil.ReadFile(record); string(content) != expected {
+			t.Errorf("%s hooks run as %q, expect %q", stage, content, expected)
+		}
+	}
+}
+
+/* state of container is given on stdin, and env of hook is its whole environment. */
+func TestRunHookState(t *testing.T) {
+	dir, cleanup := newHooksDir(t)
+	defer cleanup()
+	stateFile, envFile := filepath.Join(dir, "state"), filepath.Join(dir, "env")
+	hook := shellHook(fmt.Sprintf("cat > %s; echo $FOO,$HOME > %s", stateFile, envFile))
+	hook.Env = []string{"FOO=bar"}
+	state := &PublicState{Version: Version, ID: "c1", Status: Created, Pid: 42, Bundle: "/bundle"}
+	if err := RunHooks("prestart", []Hook{hook}, state); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received PublicState
+	if err := json.Unmarshal(content, &received); err != nil {
+		t.Fatalf("hook receives %q : %v", content, err)
+	}
+	if received.ID != state.ID || received.Pid != state.Pid || received.Status != state.Status || received.Bundle != state.Bundle {
+		t.Errorf("hook receives state %+v, expect %+v", received, *state)
+	}
+	if env, _ := ioutil.ReadFile(envFile); string(env) != "bar,\n" {
+		t.Errorf("hook runs with env %q, expect only FOO", env)
+	}
+}
+
+/* a hook running out of time is killed along with processes it forks. */
+func TestRunHookTimeout(t *testing.T) {
+	dir, cleanup := newHooksDir(t)
+	defer cleanup()
+	pidFile := filepath.Join(dir, "pid")
+	timeout := 1
+	hook := shellHook(fmt.Sprintf("sleep 30 & echo $! > %s; sleep 30", pidFile))
+	hook.Timeout = &timeout
+	start := time.Now()
+	if err := runHook(hook, nil); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("hook running out of time returns %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook is killed after %v, expect about %d seconds", elapsed, timeout)
+	}
+	content, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* the forked process may be left a zombie, until its new parent reaps it. */
+	for i := 0; i < 50 && container.ProcessStartTime(pid) != ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if container.ProcessStartTime(pid) != "" {
+		t.Errorf("process %d forked by hook is still running", pid)
+	}
+
+	zero := 0
+	hook = shellHook("true")
+	hook.Timeout = &zero
+	if err := runHook(hook, nil); err == nil {
+		t.Errorf("hook of zero timeout runs")
+	}
+}
+
+/* global hooks are loaded in file name order, and run before those of container. */
+func TestLoadAndMergeHooks(t *testing.T) {
+	dir, cleanup := newHooksDir(t)
+	defer cleanup()
+	files := map[string]string{
+		"20-second.json": `{"prestart": [{"path": "/second"}], "poststop": [{"path": "/cleanup"}]}`,
+		"10-first.json":  `{"prestart": [{"path": "/first"}]}`,
+		"ignored.conf":   `{"prestart": [{"path": "/ignored"}]}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	global, err := loadHooksDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := MergeHooks(global, &Hooks{Prestart: []Hook{{Path: "/local"}}})
+	var paths []string
+	for _, hook := range merged.Prestart {
+		paths = append(paths, hook.Path)
+	}
+	if strings.Join(paths, ",") != "/first,/second,/local" {
+		t.Errorf("prestart hooks are %v, expect /first, /second and /local", paths)
+	}
+	if len(merged.Poststop) != 1 || merged.Poststop[0].Path != "/cleanup" {
+		t.Errorf("poststop hooks are %v, expect /cleanup", merged.Poststop)
+	}
+	if hooks, err := loadHooksDir(filepath.Join(dir, "missing")); err != nil || len(MergeHooks(hooks, nil).Prestart) != 0 {
+		t.Errorf("missing hooks directory has hooks %v : %v", hooks, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "30-broken.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadHooksDir(dir); err == nil {
+		t.Errorf("broken hooks file is loaded")
+	}
+}
@@ -0,0 +1,190 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vishvananda/netns"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+/* the configuration sent by `create` to init process through pipe. */
+type initConfig struct {
+	Spec     *Spec  `json:"spec"`
+	Rootfs   string `json:"rootfs"`
+	Bundle   string `json:"bundle"`
+	StateDir string       `json:"stateDir"`
+	Console  string       `json:"console"` /* the slave pty bound to /dev/console */
+	State    *PublicState `json:"state"`   /* the state given to hooks run by init */
+}
+
+/* namespaces which may be joined by path in init, setns of others is refused to a threaded process. */
+var joinableNamespaces = map[string]bool{
+	"network": true,
+	"ipc":     true,
+	"uts":     true,
+	"cgroup":  true,
+}
+
+var rlimitTypes = map[string]int{
+	"RLIMIT_CPU":        0,
+	"RLIMIT_FSIZE":      1,
+	"RLIMIT_DATA":       2,
+	"RLIMIT_STACK":      3,
+	"RLIMIT_CORE":       4,
+	"RLIMIT_RSS":        5,
+	"RLIMIT_NPROC":      6,
+	"RLIMIT_NOFILE":     7,
+	"RLIMIT_MEMLOCK":    8,
+	"RLIMIT_AS":         9,
+	"RLIMIT_LOCKS":      10,
+	"RLIMIT_SIGPENDING": 11,
+	"RLIMIT_MSGQUEUE":   12,
+	"RLIMIT_NICE":       13,
+	"RLIMIT_RTPRIO":     14,
+	"RLIMIT_RTTIME":     15,
+}
+
+/*
+	the init process of OCI container, re-executed by `create` in new namespaces. It prepares the
+	container, reports through sync pipe, then blocks on the exec fifo until `start` and executes
+	the user process. Only returns on error.
+*/
+func InitProcess() error {
+	/* namespaces joined by setns belong to this thread, which must be the one calling execve. */
+	runtime.LockOSThread()
+	configPipe := os.NewFile(uintptr(3), "config")
+	syncPipe := os.NewFile(uintptr(4), "sync")
+	var config initConfig
+	err := json.NewDecoder(configPipe).Decode(&config)
+	configPipe.Close()
+	if err == nil {
+		err = initContainer(&config, syncPipe)
+	}
+	/* before reporting ready, errors are returned to `create` through sync pipe. */
+	if err != nil {
+		if _, werr := syncPipe.WriteString(err.Error()); werr != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		syncPipe.Close()
+	}
+	return err
+}
+
+func initContainer(config *initConfig, syncPipe *os.File) error {
+	spec := config.Spec
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Path != "" {
+			if err := joinNamespace(ns); err != nil {
+				return err
+			}
+		}
+	}
+	/* the state directory is invisible after pivot root, keep it open to reach exec fifo. */
+	stateDirFd, err := syscall.Open(config.StateDir, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open state directory %s error : %v", config.StateDir, err)
+	}
+	if err := prepareRootfs(spec, config.Rootfs, config.Bundle, config.Console); err != nil {
+		return err
+	}
+	/* createContainer hooks run in container namespaces before pivot root. */
+	if err := RunHooks("createContainer", spec.Hooks.CreateContainer, config.State); err != nil {
+		return err
+	}
+	if err := finishRootfs(spec, config.Rootfs); err != nil {
+		return err
+	}
+	if spec.Hostname != "" {
+		if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+			return fmt.Errorf("set hostname error : %v", err)
+		}
+	}
+	process := spec.Process
+	for _, rl := range process.Rlimits {
+		resource, ok := rlimitTypes[rl.Type]
+		if !ok {
+			return fmt.Errorf("unknown rlimit type %s", rl.Type)
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: rl.Soft, Max: rl.Hard}); err != nil {
+			return fmt.Errorf("set rlimit %s error : %v", rl.Type, err)
+		}
+	}
+	/* search executable in PATH of process environment. */
+	os.Clearenv()
+	for _, env := range process.Env {
+		if kv := strings.SplitN(env, "=", 2); len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	path, err := exec.LookPath(process.Args[0])
+	if err != nil {
+		return fmt.Errorf("look up executable %s error : %v", process.Args[0], err)
+	}
+	if err := syscall.Chdir(process.Cwd); err != nil {
+		return fmt.Errorf("change directory to %s error : %v", process.Cwd, err)
+	}
+	if err := setUser(process.User); err != nil {
+		return err
+	}
+
+	if _, err := syscall.Write(int(syncPipe.Fd()), []byte("ok")); err != nil {
+		return fmt.Errorf("report ready error : %v", err)
+	}
+	syncPipe.Close()
+	/* opening fifo blocks until `start` opens it for reading. */
+	fifoFd, err := syscall.Openat(stateDirFd, ExecFifoName, syscall.O_WRONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo error : %v", err)
+	}
+	if _, err := syscall.Write(fifoFd, []byte("0")); err != nil {
+		return fmt.Errorf("write exec fifo error : %v", err)
+	}
+	syscall.Close(fifoFd)
+	syscall.Close(stateDirFd)
+	/* startContainer hooks run in container after `start`, and resolve in its root filesystem. */
+	config.State.Status = Created
+	if err := RunHooks("startContainer", spec.Hooks.StartContainer, config.State); err != nil {
+		return err
+	}
+	if err := syscall.Exec(path, process.Args, process.Env); err != nil {
+		fmt.Fprintf(os.Stderr, "exec %s error : %v\n", path, err)
+		os.Exit(127)
+	}
+	return nil
+}
+
+func joinNamespace(ns Namespace) error {
+	if !joinableNamespaces[ns.Type] {
+		return fmt.Errorf("joining %s namespace by path is not supported", ns.Type)
+	}
+	f, err := os.Open(ns.Path)
+	if err != nil {
+		return fmt.Errorf("open %s namespace %s error : %v", ns.Type, ns.Path, err)
+	}
+	defer f.Close()
+	if err := netns.Setns(netns.NsHandle(f.Fd()), 0); err != nil {
+		return fmt.Errorf("join %s namespace %s error : %v", ns.Type, ns.Path, err)
+	}
+	return nil
+}
+
+func setUser(user User) error {
+	groups := make([]int, len(user.AdditionalGids))
+	for i, gid := range user.AdditionalGids {
+		groups[i] = int(gid)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("set additional groups error : %v", err)
+	}
+	if err := syscall.Setgid(int(user.GID)); err != nil {
+		return fmt.Errorf("set gid %d error : %v", user.GID, err)
+	}
+	if err := syscall.Setuid(int(user.UID)); err != nil {
+		return fmt.Errorf("set uid %d error : %v", user.UID, err)
+	}
+	return nil
+}
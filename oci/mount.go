@@ -0,0 +1,332 @@
+package oci
+
+import (
+	"fmt"
+	"github.com/qqzeng/tinydocker/cgroups/subsystems"
+	"github.com/qqzeng/tinydocker/container"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+/* mount options turned into flags, the value tells whether the flag is cleared. */
+var mountFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"async":         {true, syscall.MS_SYNCHRONOUS},
+	"atime":         {true, syscall.MS_NOATIME},
+	"bind":          {false, syscall.MS_BIND},
+	"defaults":      {false, 0},
+	"dev":           {true, syscall.MS_NODEV},
+	"diratime":      {true, syscall.MS_NODIRATIME},
+	"dirsync":       {false, syscall.MS_DIRSYNC},
+	"exec":          {true, syscall.MS_NOEXEC},
+	"mand":          {false, syscall.MS_MANDLOCK},
+	"noatime":       {false, syscall.MS_NOATIME},
+	"nodev":         {false, syscall.MS_NODEV},
+	"nodiratime":    {false, syscall.MS_NODIRATIME},
+	"noexec":        {false, syscall.MS_NOEXEC},
+	"nomand":        {true, syscall.MS_MANDLOCK},
+	"norelatime":    {true, syscall.MS_RELATIME},
+	"nostrictatime": {true, syscall.MS_STRICTATIME},
+	"nosuid":        {false, syscall.MS_NOSUID},
+	"rbind":         {false, syscall.MS_BIND | syscall.MS_REC},
+	"relatime":      {false, syscall.MS_RELATIME},
+	"remount":       {false, syscall.MS_REMOUNT},
+	"ro":            {false, syscall.MS_RDONLY},
+	"rw":            {true, syscall.MS_RDONLY},
+	"strictatime":   {false, syscall.MS_STRICTATIME},
+	"suid":          {true, syscall.MS_NOSUID},
+	"sync":          {false, syscall.MS_SYNCHRONOUS},
+}
+
+var propagationFlags = map[string]uintptr{
+	"private":     syscall.MS_PRIVATE,
+	"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":      syscall.MS_SHARED,
+	"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+	"slave":       syscall.MS_SLAVE,
+	"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+	"unbindable":  syscall.MS_UNBINDABLE,
+	"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+}
+
+/* devices bind mounted from host, since mknod is not allowed in user namespace. */
+var defaultDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+func parseMountOptions(options []string) (uintptr, uintptr, string) {
+	var flags, propagation uintptr
+	var data []string
+	for _, option := range options {
+		if f, ok := mountFlags[option]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+		} else if p, ok := propagationFlags[option]; ok {
+			propagation |= p
+		} else {
+			data = append(data, option)
+		}
+	}
+	return flags, propagation, strings.Join(data, ",")
+}
+
+/*
+	join path in rootfs with symbolic links resolved inside rootfs, so that a malicious rootfs can
+	not redirect mounts to the host.
+*/
+func securePath(rootfs string, unsafePath string) (string, error) {
+	resolved := ""
+	pending := strings.Split(filepath.Clean("/"+unsafePath), "/")
+	links := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir(resolved)
+			if resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > 255 {
+			return "", fmt.Errorf("too many symbolic links in %s", unsafePath)
+		}
+		target, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return filepath.Join(rootfs, "/"+resolved), nil
+}
+
+/*
+	prepare mounts of the root filesystem in the new mount namespace, refer:
+	https://github.com/opencontainers/runtime-spec/blob/master/config-linux.md
+*/
+func prepareRootfs(spec *Spec, rootfs string, bundle string, console string) error {
+	/* our mounts must not propagate to host, and pivot_root refuses shared mounts. */
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make / private error : %v", err)
+	}
+	if err := syscall.Mount(rootfs, rootfs, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mount rootfs %s error : %v", rootfs, err)
+	}
+	for _, m := range spec.Mounts {
+		if err := mountEntry(m, rootfs, bundle); err != nil {
+			return err
+		}
+	}
+	return setupDevices(rootfs, console)
+}
+
+/* pivot into the prepared root filesystem and apply paths protection. */
+func finishRootfs(spec *Spec, rootfs string) error {
+	if err := container.PivotRoot(rootfs); err != nil {
+		return fmt.Errorf("pivot root to %s error : %v", rootfs, err)
+	}
+	if spec.Linux.RootfsPropagation != "" {
+		flag, ok := propagationFlags[spec.Linux.RootfsPropagation]
+		if !ok {
+			return fmt.Errorf("unknown rootfs propagation %s", spec.Linux.RootfsPropagation)
+		}
+		if err := syscall.Mount("", "/", "", flag, ""); err != nil {
+			return fmt.Errorf("set rootfs propagation error : %v", err)
+		}
+	}
+	for _, p := range spec.Linux.ReadonlyPaths {
+		if err := readonlyPath(p); err != nil {
+			return err
+		}
+	}
+	for _, p := range spec.Linux.MaskedPaths {
+		if err := maskPath(p); err != nil {
+			return err
+		}
+	}
+	if spec.Root.Readonly {
+		if err := syscall.Mount("/", "/", "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("remount rootfs readonly error : %v", err)
+		}
+	}
+	return nil
+}
+
+func mountEntry(m Mount, rootfs string, bundle string) error {
+	dest, err := securePath(rootfs, m.Destination)
+	if err != nil {
+		return fmt.Errorf("resolve mount destination %s error : %v", m.Destination, err)
+	}
+	flags, propagation, data := parseMountOptions(m.Options)
+	switch {
+	case m.Type == "bind" || flags&syscall.MS_BIND != 0:
+		source := m.Source
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(bundle, source)
+		}
+		if err := createMountPoint(source, dest); err != nil {
+			return err
+		}
+		if err := syscall.Mount(source, dest, "", syscall.MS_BIND|(flags&syscall.MS_REC), ""); err != nil {
+			return fmt.Errorf("bind mount %s to %s error : %v", source, m.Destination, err)
+		}
+		/* flags other than bind are only honored by a remount. */
+		if flags&^(syscall.MS_BIND|syscall.MS_REC|syscall.MS_REMOUNT) != 0 {
+			if err := syscall.Mount(source, dest, "", flags|syscall.MS_BIND|syscall.MS_REMOUNT, data); err != nil {
+				return fmt.Errorf("remount %s error : %v", m.Destination, err)
+			}
+		}
+	case m.Type == "cgroup":
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("create mount point %s error : %v", m.Destination, err)
+		}
+		/* without cgroup namespace, expose the hierarchies of host as docker does. */
+		if subsystems.FindCgroup2MountPoint() == "/sys/fs/cgroup" {
+			err = syscall.Mount("cgroup2", dest, "cgroup2", flags, "")
+		} else if err = syscall.Mount("/sys/fs/cgroup", dest, "", syscall.MS_BIND|syscall.MS_REC, ""); err == nil && flags&syscall.MS_RDONLY != 0 {
+			err = syscall.Mount("/sys/fs/cgroup", dest, "", syscall.MS_BIND|syscall.MS_REMOUNT|flags, "")
+		}
+		if err != nil {
+			return fmt.Errorf("mount cgroup to %s error : %v", m.Destination, err)
+		}
+	default:
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("create mount point %s error : %v", m.Destination, err)
+		}
+		if err := syscall.Mount(m.Source, dest, m.Type, flags, data); err != nil {
+			return fmt.Errorf("mount %s to %s error : %v", m.Type, m.Destination, err)
+		}
+	}
+	if propagation != 0 {
+		if err := syscall.Mount("", dest, "", propagation, ""); err != nil {
+			return fmt.Errorf("set propagation of %s error : %v", m.Destination, err)
+		}
+	}
+	return nil
+}
+
+/* bind mount target must be the same kind, a directory or a file. */
+func createMountPoint(source string, dest string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("stat mount source %s error : %v", source, err)
+	}
+	if fi.IsDir() {
+		return os.MkdirAll(dest, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE, 0755)
+	if err != nil {
+		return fmt.Errorf("create mount point %s error : %v", dest, err)
+	}
+	return f.Close()
+}
+
+func setupDevices(rootfs string, console string) error {
+	/* a `dev` of rootfs linked elsewhere is followed inside rootfs, as mount destinations are. */
+	devDir, err := securePath(rootfs, "/dev")
+	if err != nil {
+		return fmt.Errorf("resolve /dev in rootfs error : %v", err)
+	}
+	if devDir == filepath.Clean(rootfs) {
+		return fmt.Errorf("/dev of rootfs %s resolves to rootfs itself", rootfs)
+	}
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		return fmt.Errorf("create %s error : %v", devDir, err)
+	}
+	for _, device := range defaultDevices {
+		dest, err := securePath(rootfs, "/dev/"+device)
+		if err != nil {
+			return fmt.Errorf("resolve /dev/%s in rootfs error : %v", device, err)
+		}
+		if err := bindDevice("/dev/"+device, dest); err != nil {
+			return err
+		}
+	}
+	if console != "" {
+		dest, err := securePath(rootfs, "/dev/console")
+		if err != nil {
+			return fmt.Errorf("resolve /dev/console in rootfs error : %v", err)
+		}
+		if err := bindDevice(console, dest); err != nil {
+			return err
+		}
+	}
+	links := [][2]string{
+		{"/proc/self/fd", "fd"},
+		{"/proc/self/fd/0", "stdin"},
+		{"/proc/self/fd/1", "stdout"},
+		{"/proc/self/fd/2", "stderr"},
+		{"pts/ptmx", "ptmx"},
+	}
+	/* symlink does not follow its last element, which is created in the resolved devDir. */
+	for _, link := range links {
+		if err := os.Symlink(link[0], filepath.Join(devDir, link[1])); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("create symbolic link /dev/%s error : %v", link[1], err)
+		}
+	}
+	return nil
+}
+
+func bindDevice(source string, dest string) error {
+	if err := createMountPoint(source, dest); err != nil {
+		return err
+	}
+	if err := syscall.Mount(source, dest, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount device %s error : %v", source, err)
+	}
+	return nil
+}
+
+func readonlyPath(p string) error {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return nil
+	}
+	if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mount readonly path %s error : %v", p, err)
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount(p, p, "", flags, ""); err != nil {
+		return fmt.Errorf("remount readonly path %s error : %v", p, err)
+	}
+	return nil
+}
+
+/* hide a directory under an empty readonly tmpfs, and a file under /dev/null. */
+func maskPath(p string) error {
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat masked path %s error : %v", p, err)
+	}
+	if fi.IsDir() {
+		err = syscall.Mount("tmpfs", p, "tmpfs", syscall.MS_RDONLY, "")
+	} else {
+		err = syscall.Mount("/dev/null", p, "", syscall.MS_BIND, "")
+	}
+	if err != nil {
+		return fmt.Errorf("mask path %s error : %v", p, err)
+	}
+	return nil
+}
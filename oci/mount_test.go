@@ -0,0 +1,81 @@
+package oci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	cases := []struct {
+		options     []string
+		flags       uintptr
+		propagation uintptr
+		data        string
+	}{
+		{nil, 0, 0, ""},
+		{[]string{"nosuid", "noexec", "nodev"}, syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV, 0, ""},
+		{[]string{"ro", "rw"}, 0, 0, ""},
+		{[]string{"rw", "ro"}, syscall.MS_RDONLY, 0, ""},
+		{[]string{"rbind", "rprivate"}, syscall.MS_BIND | syscall.MS_REC, syscall.MS_PRIVATE | syscall.MS_REC, ""},
+		{[]string{"nosuid", "mode=755", "size=65536k"}, syscall.MS_NOSUID, 0, "mode=755,size=65536k"},
+		{[]string{"defaults", "noexec", "exec"}, 0, 0, ""},
+		{[]string{"slave", "newinstance", "ptmxmode=0666"}, 0, syscall.MS_SLAVE, "newinstance,ptmxmode=0666"},
+	}
+	for _, c := range cases {
+		flags, propagation, data := parseMountOptions(c.options)
+		if flags != c.flags || propagation != c.propagation || data != c.data {
+			t.Errorf("options %v are parsed as %#x, %#x, %q, expect %#x, %#x, %q",
+				c.options, flags, propagation, data, c.flags, c.propagation, c.data)
+		}
+	}
+}
+
+/* symlinks of rootfs are resolved as if rootfs were `/`, neither they nor `..` lead out of it. */
+func TestSecurePath(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+	if err := os.MkdirAll(filepath.Join(rootfs, "usr", "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"etc":     "/host/etc",
+		"up":      "../../../..",
+		"lib":     "usr/lib",
+		"lib64":   "./lib",
+		"dev":     "/",
+		"loop":    "loop2",
+		"loop2":   "loop",
+		"usr/tmp": "../../tmp",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(rootfs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := map[string]string{
+		"/":              "/",
+		"/proc":          "/proc",
+		"../../proc":     "/proc",
+		"/etc/passwd":    "/host/etc/passwd",
+		"/up/usr/lib":    "/usr/lib",
+		"/lib64/libc.so": "/usr/lib/libc.so",
+		"/dev/null":      "/null",
+		"/usr/tmp/x":     "/tmp/x",
+		"/not/exist/yet": "/not/exist/yet",
+	}
+	for unsafePath, expected := range cases {
+		resolved, err := securePath(rootfs, unsafePath)
+		if err != nil || resolved != filepath.Join(rootfs, expected) {
+			t.Errorf("%s is resolved to %s, expect %s : %v", unsafePath, resolved, filepath.Join(rootfs, expected), err)
+		}
+	}
+	if resolved, err := securePath(rootfs, "/loop/file"); err == nil {
+		t.Errorf("symlink loop is resolved to %s", resolved)
+	}
+}
@@ -0,0 +1,419 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/cgroups"
+	"github.com/qqzeng/tinydocker/cgroups/subsystems"
+	"github.com/qqzeng/tinydocker/container"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	/* CLONE_NEWCGROUP is missing in package syscall. */
+	cloneNewCgroup = 0x02000000
+	/* how long `delete --force` waits for a killed init process. */
+	killTimeout = 10 * time.Second
+)
+
+var namespaceFlags = map[string]uintptr{
+	"pid":     syscall.CLONE_NEWPID,
+	"network": syscall.CLONE_NEWNET,
+	"mount":   syscall.CLONE_NEWNS,
+	"ipc":     syscall.CLONE_NEWIPC,
+	"uts":     syscall.CLONE_NEWUTS,
+	"user":    syscall.CLONE_NEWUSER,
+	"cgroup":  cloneNewCgroup,
+}
+
+/*
+	create a container from bundle: the init process is started in new namespaces and cgroup,
+	prepares the root filesystem, and waits on the exec fifo until `start`.
+*/
+func Create(id string, bundle string, consoleSocket string, pidFile string) (err error) {
+	if err := validateId(id); err != nil {
+		return err
+	}
+	if bundle, err = filepath.Abs(bundle); err != nil {
+		return fmt.Errorf("resolve bundle path error : %v", err)
+	}
+	spec, err := LoadSpec(bundle)
+	if err != nil {
+		return err
+	}
+	globalHooks, err := LoadGlobalHooks()
+	if err != nil {
+		return err
+	}
+	spec.Hooks = MergeHooks(globalHooks, spec.Hooks)
+	rootfs := spec.RootfsPath(bundle)
+	if fi, err := os.Stat(rootfs); err != nil || !fi.IsDir() {
+		return fmt.Errorf("rootfs %s is not a directory", rootfs)
+	}
+	cloneflags, err := cloneFlags(spec)
+	if err != nil {
+		return err
+	}
+	if spec.Process.Terminal && consoleSocket == "" {
+		return fmt.Errorf("--console-socket is required when process.terminal is true")
+	}
+	if !spec.Process.Terminal && consoleSocket != "" {
+		return fmt.Errorf("--console-socket is only allowed when process.terminal is true")
+	}
+
+	/* creating the state directory reserves the id. */
+	if err := os.MkdirAll(path.Dir(path.Clean(stateDir(id))), 0700); err != nil {
+		return fmt.Errorf("create state root directory error : %v", err)
+	}
+	if err := os.Mkdir(stateDir(id), 0700); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("container %s already exists", id)
+		}
+		return fmt.Errorf("create state directory error : %v", err)
+	}
+	state := &State{
+		Version:     Version,
+		ID:          id,
+		Status:      Creating,
+		Bundle:      bundle,
+		Annotations: spec.Annotations,
+		Created:     time.Now().Format(container.TimeFormat),
+		CgroupPath:  spec.Linux.CgroupsPath,
+		Hooks:       spec.Hooks,
+	}
+	if state.CgroupPath == "" {
+		state.CgroupPath = path.Join(container.CgroupParent, id)
+	}
+	cgroupManager := cgroups.NewCgroupManager(state.CgroupPath)
+	defer func() {
+		if err != nil {
+			cgroupManager.Destory()
+			os.RemoveAll(stateDir(id))
+		}
+	}()
+	if err := syscall.Mkfifo(filepath.Join(stateDir(id), ExecFifoName), 0600); err != nil {
+		return fmt.Errorf("create exec fifo error : %v", err)
+	}
+	if err := state.dump(); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("/proc/self/exe", "oci-init")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: cloneflags,
+		Setsid:     true,
+	}
+	if cloneflags&syscall.CLONE_NEWUSER != 0 {
+		cmd.SysProcAttr.UidMappings = idMappings(spec.Linux.UIDMappings)
+		cmd.SysProcAttr.GidMappings = idMappings(spec.Linux.GIDMappings)
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+	}
+	var console string
+	if spec.Process.Terminal {
+		master, slavePath, err := container.NewPty()
+		if err != nil {
+			return err
+		}
+		defer master.Close()
+		slave, err := os.OpenFile(slavePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+		if err != nil {
+			return fmt.Errorf("open pty slave %s error : %v", slavePath, err)
+		}
+		defer slave.Close()
+		if err := sendConsole(consoleSocket, master); err != nil {
+			return err
+		}
+		console = slavePath
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+	} else {
+		/* the caller of `create` owns the stdio of container process. */
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	}
+	configRead, configWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("new pipe error : %v", err)
+	}
+	syncRead, syncWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("new pipe error : %v", err)
+	}
+	cmd.ExtraFiles = []*os.File{configRead, syncWrite}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start init process error : %v", err)
+	}
+	configRead.Close()
+	syncWrite.Close()
+	defer func() {
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}()
+
+	if err := applyResources(cgroupManager, spec.Linux.Resources, cmd.Process.Pid); err != nil {
+		return err
+	}
+	/* namespaces exist now, the init process waits for its config until hooks finish. */
+	state.Pid = cmd.Process.Pid
+	if err := RunHooks("prestart", spec.Hooks.Prestart, state.Public()); err != nil {
+		return err
+	}
+	if err := RunHooks("createRuntime", spec.Hooks.CreateRuntime, state.Public()); err != nil {
+		return err
+	}
+	config := &initConfig{
+		Spec:     spec,
+		Rootfs:   rootfs,
+		Bundle:   bundle,
+		StateDir: stateDir(id),
+		Console:  console,
+		State:    state.Public(),
+	}
+	if err := json.NewEncoder(configWrite).Encode(config); err != nil {
+		return fmt.Errorf("send init config error : %v", err)
+	}
+	configWrite.Close()
+	reply, err := ioutil.ReadAll(syncRead)
+	syncRead.Close()
+	if err != nil {
+		return fmt.Errorf("read init process reply error : %v", err)
+	}
+	if string(reply) != "ok" {
+		if len(reply) == 0 {
+			return fmt.Errorf("init process exits unexpectedly")
+		}
+		return fmt.Errorf("init process error : %s", reply)
+	}
+
+	state.InitStartTime = container.ProcessStartTime(state.Pid)
+	state.Status = Created
+	if err := state.dump(); err != nil {
+		return err
+	}
+	if pidFile != "" {
+		if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(state.Pid)), 0644); err != nil {
+			return fmt.Errorf("write pid file %s error : %v", pidFile, err)
+		}
+	}
+	/* the init process outlives us, and is reaped by the host init once exited. */
+	cmd.Process.Release()
+	return nil
+}
+
+func cloneFlags(spec *Spec) (uintptr, error) {
+	var flags uintptr
+	hasMount := false
+	for _, ns := range spec.Linux.Namespaces {
+		flag, ok := namespaceFlags[ns.Type]
+		if !ok {
+			return 0, fmt.Errorf("unknown namespace type %s", ns.Type)
+		}
+		if ns.Type == "mount" {
+			if ns.Path != "" {
+				return 0, fmt.Errorf("joining mount namespace by path is not supported")
+			}
+			hasMount = true
+		}
+		if ns.Path == "" {
+			flags |= flag
+		} else if !joinableNamespaces[ns.Type] {
+			return 0, fmt.Errorf("joining %s namespace by path is not supported", ns.Type)
+		}
+	}
+	/* the root filesystem is prepared by mounts, which must not leak to host. */
+	if !hasMount {
+		return 0, fmt.Errorf("a mount namespace is required")
+	}
+	return flags, nil
+}
+
+func idMappings(mappings []IDMapping) []syscall.SysProcIDMap {
+	var result []syscall.SysProcIDMap
+	for _, m := range mappings {
+		result = append(result, syscall.SysProcIDMap{
+			ContainerID: int(m.ContainerID),
+			HostID:      int(m.HostID),
+			Size:        int(m.Size),
+		})
+	}
+	return result
+}
+
+/*
+	put init process into cgroup of container. Resource limits are mandatory once requested, the
+	cgroup itself is best effort as `run` does.
+*/
+func applyResources(cgroupManager *cgroups.CgroupManager, resources *Resources, pid int) error {
+	res := &subsystems.ResourceConfig{}
+	if resources != nil {
+		if resources.Memory != nil && resources.Memory.Limit != nil {
+			res.MemoryLimit = strconv.FormatInt(*resources.Memory.Limit, 10)
+		}
+		if resources.CPU != nil {
+			if resources.CPU.Shares != nil {
+				res.CpuShare = strconv.FormatUint(*resources.CPU.Shares, 10)
+			}
+			res.CpuSet = resources.CPU.Cpus
+		}
+	}
+	limited := res.MemoryLimit != "" || res.CpuShare != "" || res.CpuSet != ""
+	if err := cgroupManager.Set(res); err != nil {
+		if limited {
+			return fmt.Errorf("set cgroup resources error : %v", err)
+		}
+		log.Warnf("Create cgroup %s error : %v", cgroupManager.Path, err)
+		return nil
+	}
+	if err := cgroupManager.Apply(pid); err != nil {
+		if limited {
+			return fmt.Errorf("apply cgroup error : %v", err)
+		}
+		log.Warnf("Apply cgroup %s error : %v", cgroupManager.Path, err)
+	}
+	return nil
+}
+
+/* pass the pty master to the console socket with SCM_RIGHTS, as runc does. */
+func sendConsole(consoleSocket string, master *os.File) error {
+	conn, err := net.Dial("unix", consoleSocket)
+	if err != nil {
+		return fmt.Errorf("connect console socket %s error : %v", consoleSocket, err)
+	}
+	defer conn.Close()
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("console socket %s is not a unix socket", consoleSocket)
+	}
+	if _, _, err := unixConn.WriteMsgUnix([]byte(master.Name()), syscall.UnixRights(int(master.Fd())), nil); err != nil {
+		return fmt.Errorf("send pty master to console socket error : %v", err)
+	}
+	return nil
+}
+
+/* let the created container execute its user process. */
+func Start(id string) error {
+	state, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+	if state.Status != Created {
+		return fmt.Errorf("cannot start container %s in %s status", id, state.Status)
+	}
+	fifoPath := filepath.Join(stateDir(id), ExecFifoName)
+	/* non-blocking, so that a dead init process does not hang us. */
+	fifo, err := os.OpenFile(fifoPath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo error : %v", err)
+	}
+	defer fifo.Close()
+	buf := make([]byte, 1)
+	for {
+		n, err := syscall.Read(int(fifo.Fd()), buf)
+		if n > 0 {
+			break
+		}
+		if err != syscall.EAGAIN && container.ProcessStartTime(state.Pid) != state.InitStartTime {
+			return fmt.Errorf("init process of container %s exits before start", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Remove(fifoPath); err != nil {
+		return fmt.Errorf("remove exec fifo error : %v", err)
+	}
+	/* failure of poststart hooks does not affect the started container. */
+	state.Status = Running
+	if state.Hooks != nil {
+		if err := RunHooks("poststart", state.Hooks.Poststart, state.Public()); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+	return nil
+}
+
+func Kill(id string, sig syscall.Signal) error {
+	state, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+	if state.Status != Created && state.Status != Running {
+		return fmt.Errorf("cannot kill container %s in %s status", id, state.Status)
+	}
+	if err := syscall.Kill(state.Pid, sig); err != nil {
+		return fmt.Errorf("send signal %d to container %s error : %v", sig, id, err)
+	}
+	return nil
+}
+
+/* remove a stopped container, a container not stopped is killed first with force. */
+func Delete(id string, force bool) error {
+	state, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+	if state.Status == Created || state.Status == Running {
+		if !force {
+			return fmt.Errorf("cannot delete container %s in %s status, stop it first or use --force", id, state.Status)
+		}
+		syscall.Kill(state.Pid, syscall.SIGKILL)
+		deadline := time.Now().Add(killTimeout)
+		for container.ProcessStartTime(state.Pid) == state.InitStartTime {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("container %s does not exit after killed", id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := cgroups.NewCgroupManager(state.CgroupPath).Destory(); err != nil {
+		log.Warnf("Remove cgroup %s error : %v", state.CgroupPath, err)
+	}
+	if err := os.RemoveAll(stateDir(id)); err != nil {
+		return fmt.Errorf("remove state directory of container %s error : %v", id, err)
+	}
+	/* failure of poststop hooks does not affect the deleted container. */
+	state.Status = Stopped
+	state.Pid = 0
+	if state.Hooks != nil {
+		if err := RunHooks("poststop", state.Hooks.Poststop, state.Public()); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+	return nil
+}
+
+/* print the state of container defined by runtime-spec. */
+func PrintState(id string) error {
+	state, err := LoadState(id)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(state.Public(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal state error : %v", err)
+	}
+	fmt.Fprintln(os.Stdout, string(content))
+	return nil
+}
+
+/* write a default config.json into bundle. */
+func WriteDefaultSpec(bundle string) error {
+	configFile := filepath.Join(bundle, ConfigName)
+	if _, err := os.Stat(configFile); err == nil {
+		return fmt.Errorf("file %s exists, remove it first", configFile)
+	}
+	content, err := json.MarshalIndent(DefaultSpec(), "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal default spec error : %v", err)
+	}
+	return ioutil.WriteFile(configFile, content, 0666)
+}
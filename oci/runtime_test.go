@@ -0,0 +1,38 @@
+package oci
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestCloneFlags(t *testing.T) {
+	cases := []struct {
+		namespaces []Namespace
+		flags      uintptr
+		valid      bool
+	}{
+		{[]Namespace{{Type: "mount"}}, syscall.CLONE_NEWNS, true},
+		{[]Namespace{{Type: "pid"}, {Type: "mount"}, {Type: "uts"}},
+			syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS, true},
+		{[]Namespace{{Type: "mount"}, {Type: "network", Path: "/proc/1/ns/net"}}, syscall.CLONE_NEWNS, true},
+		{[]Namespace{{Type: "mount"}, {Type: "cgroup"}}, syscall.CLONE_NEWNS | cloneNewCgroup, true},
+		{nil, 0, false},
+		{[]Namespace{{Type: "pid"}}, 0, false},
+		{[]Namespace{{Type: "mount", Path: "/proc/1/ns/mnt"}}, 0, false},
+		{[]Namespace{{Type: "mount"}, {Type: "pid", Path: "/proc/1/ns/pid"}}, 0, false},
+		{[]Namespace{{Type: "mount"}, {Type: "user", Path: "/proc/1/ns/user"}}, 0, false},
+		{[]Namespace{{Type: "mount"}, {Type: "time"}}, 0, false},
+	}
+	for _, c := range cases {
+		flags, err := cloneFlags(&Spec{Linux: &Linux{Namespaces: c.namespaces}})
+		if !c.valid {
+			if err == nil {
+				t.Errorf("namespaces %v are accepted with flags %#x", c.namespaces, flags)
+			}
+			continue
+		}
+		if err != nil || flags != c.flags {
+			t.Errorf("flags of namespaces %v are %#x, expect %#x : %v", c.namespaces, flags, c.flags, err)
+		}
+	}
+}
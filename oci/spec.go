@@ -0,0 +1,184 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+/*
+	the subset of OCI runtime-spec configuration understood by tinydocker, refer:
+	https://github.com/opencontainers/runtime-spec/blob/master/config.md
+*/
+
+const (
+	Version    = "1.0.2"
+	ConfigName = "config.json"
+)
+
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Hooks       *Hooks            `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+type Process struct {
+	Terminal bool     `json:"terminal,omitempty"`
+	User     User     `json:"user"`
+	Args     []string `json:"args"`
+	Env      []string `json:"env,omitempty"`
+	Cwd      string   `json:"cwd"`
+	Rlimits  []Rlimit `json:"rlimits,omitempty"`
+}
+
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type Linux struct {
+	UIDMappings       []IDMapping       `json:"uidMappings,omitempty"`
+	GIDMappings       []IDMapping       `json:"gidMappings,omitempty"`
+	Resources         *Resources        `json:"resources,omitempty"`
+	CgroupsPath       string            `json:"cgroupsPath,omitempty"`
+	Namespaces        []Namespace       `json:"namespaces,omitempty"`
+	RootfsPropagation string            `json:"rootfsPropagation,omitempty"`
+	MaskedPaths       []string          `json:"maskedPaths,omitempty"`
+	ReadonlyPaths     []string          `json:"readonlyPaths,omitempty"`
+}
+
+type IDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+type Namespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+type Resources struct {
+	Memory *Memory `json:"memory,omitempty"`
+	CPU    *CPU    `json:"cpu,omitempty"`
+}
+
+type Memory struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+type CPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+	Mems   string  `json:"mems,omitempty"`
+}
+
+/* a default configuration running `sh` in a busybox like rootfs, as `runc spec` does. */
+func DefaultSpec() *Spec {
+	return &Spec{
+		Version: Version,
+		Root: &Root{
+			Path:     "rootfs",
+			Readonly: true,
+		},
+		Process: &Process{
+			Terminal: true,
+			User:     User{},
+			Args:     []string{"sh"},
+			Env:      []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "TERM=xterm"},
+			Cwd:      "/",
+			Rlimits: []Rlimit{
+				{Type: "RLIMIT_NOFILE", Hard: 1024, Soft: 1024},
+			},
+		},
+		Hostname: "tinydocker",
+		Mounts: []Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs",
+				Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+			{Destination: "/dev/pts", Type: "devpts", Source: "devpts",
+				Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+			{Destination: "/dev/shm", Type: "tmpfs", Source: "shm",
+				Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+			{Destination: "/dev/mqueue", Type: "mqueue", Source: "mqueue",
+				Options: []string{"nosuid", "noexec", "nodev"}},
+			{Destination: "/sys", Type: "sysfs", Source: "sysfs",
+				Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+		},
+		Linux: &Linux{
+			Namespaces: []Namespace{
+				{Type: "pid"},
+				{Type: "network"},
+				{Type: "ipc"},
+				{Type: "uts"},
+				{Type: "mount"},
+			},
+			MaskedPaths: []string{
+				"/proc/acpi", "/proc/kcore", "/proc/keys", "/proc/latency_stats", "/proc/timer_list",
+				"/proc/timer_stats", "/proc/sched_debug", "/proc/scsi", "/sys/firmware",
+			},
+			ReadonlyPaths: []string{
+				"/proc/asound", "/proc/bus", "/proc/fs", "/proc/irq", "/proc/sys", "/proc/sysrq-trigger",
+			},
+		},
+	}
+}
+
+/* load and validate config.json of bundle. */
+func LoadSpec(bundle string) (*Spec, error) {
+	configFile := filepath.Join(bundle, ConfigName)
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("read bundle config %s error : %v", configFile, err)
+	}
+	var spec Spec
+	if err := json.Unmarshal(content, &spec); err != nil {
+		return nil, fmt.Errorf("unmarshal bundle config %s error : %v", configFile, err)
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return nil, fmt.Errorf("root.path is required in %s", configFile)
+	}
+	if spec.Process == nil || len(spec.Process.Args) == 0 {
+		return nil, fmt.Errorf("process.args is required in %s", configFile)
+	}
+	if spec.Process.Cwd == "" || !filepath.IsAbs(spec.Process.Cwd) {
+		return nil, fmt.Errorf("process.cwd must be an absolute path in %s", configFile)
+	}
+	if spec.Linux == nil {
+		spec.Linux = &Linux{}
+	}
+	return &spec, nil
+}
+
+/* the root filesystem of bundle, a relative root.path is relative to bundle. */
+func (s *Spec) RootfsPath(bundle string) string {
+	if filepath.IsAbs(s.Root.Path) {
+		return s.Root.Path
+	}
+	return filepath.Join(bundle, s.Root.Path)
+}
@@ -0,0 +1,162 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/qqzeng/tinydocker/container"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	StateLocation = "/var/run/tinydocker/oci/%s/"
+	StateName     = "state.json"
+	ExecFifoName  = "exec.fifo"
+
+	Creating = "creating"
+	Created  = "created"
+	Running  = "running"
+	Stopped  = "stopped"
+)
+
+/* ids of containers name their state directories, `.` and `..` are refused besides. */
+var idPattern = regexp.MustCompile(`^[\w+.-]+$`)
+
+/* the state of container defined by runtime-spec, plus private fields kept by tinydocker. */
+type State struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	Created       string `json:"created"`       /* the create time of container */
+	InitStartTime string `json:"initStartTime"` /* the start time of init process, guards against pid reuse */
+	CgroupPath    string `json:"cgroupPath"`    /* the cgroup path of container */
+	Hooks         *Hooks `json:"hooks"`         /* hooks of container merged with global ones */
+}
+
+/* the state without private fields, printed by `state` and given to hooks. */
+type PublicState struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func stateDir(id string) string {
+	return fmt.Sprintf(StateLocation, id)
+}
+
+func validateId(id string) error {
+	if !idPattern.MatchString(id) || id == "." || id == ".." {
+		return fmt.Errorf("invalid container id %s", id)
+	}
+	return nil
+}
+
+func (s *State) dump() error {
+	stateBytes, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal state of container %s error : %v", s.ID, err)
+	}
+	/* write and rename, so that concurrent readers never see a partial state. */
+	tmpFile := filepath.Join(stateDir(s.ID), "."+StateName)
+	if err := ioutil.WriteFile(tmpFile, stateBytes, 0600); err != nil {
+		return fmt.Errorf("write state of container %s error : %v", s.ID, err)
+	}
+	return os.Rename(tmpFile, filepath.Join(stateDir(s.ID), StateName))
+}
+
+/* load state of container with status refreshed from its init process. */
+func LoadState(id string) (*State, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(filepath.Join(stateDir(id), StateName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("container %s does not exist", id)
+		}
+		return nil, fmt.Errorf("read state of container %s error : %v", id, err)
+	}
+	var state State
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("unmarshal state of container %s error : %v", id, err)
+	}
+	state.refresh()
+	return &state, nil
+}
+
+/*
+	a created container is blocked on the exec fifo, which is removed by `start`. The container
+	is stopped once the init process is gone, or the pid has been reused by another process.
+*/
+func (s *State) refresh() {
+	if s.Status == Creating || s.Status == Stopped {
+		return
+	}
+	if s.Pid <= 0 || container.ProcessStartTime(s.Pid) != s.InitStartTime {
+		s.Status = Stopped
+		s.Pid = 0
+		return
+	}
+	if _, err := os.Stat(filepath.Join(stateDir(s.ID), ExecFifoName)); err == nil {
+		s.Status = Created
+	} else {
+		s.Status = Running
+	}
+}
+
+func (s *State) Public() *PublicState {
+	return &PublicState{
+		Version:     s.Version,
+		ID:          s.ID,
+		Status:      s.Status,
+		Pid:         s.Pid,
+		Bundle:      s.Bundle,
+		Annotations: s.Annotations,
+	}
+}
+
+/* signals accepted by `kill`, given by name with or without `SIG` prefix, or by number. */
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"ABRT": syscall.SIGABRT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"PIPE": syscall.SIGPIPE,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+	"CHLD": syscall.SIGCHLD,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+	"TSTP": syscall.SIGTSTP,
+	"TTIN": syscall.SIGTTIN,
+	"TTOU": syscall.SIGTTOU,
+	"WINCH": syscall.SIGWINCH,
+}
+
+func ParseSignal(sig string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(sig); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, fmt.Errorf("invalid signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	if s, ok := signalNames[strings.TrimPrefix(strings.ToUpper(sig), "SIG")]; ok {
+		return s, nil
+	}
+	return 0, fmt.Errorf("unknown signal %s", sig)
+}
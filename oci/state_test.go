@@ -0,0 +1,52 @@
+package oci
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := map[string]syscall.Signal{
+		"TERM":    syscall.SIGTERM,
+		"SIGKILL": syscall.SIGKILL,
+		"sighup":  syscall.SIGHUP,
+		"usr1":    syscall.SIGUSR1,
+		"9":       syscall.SIGKILL,
+		"64":      syscall.Signal(64),
+		"0":       0,
+		"65":      0,
+		"-9":      0,
+		"SIGFOO":  0,
+		"":        0,
+	}
+	for sig, expected := range cases {
+		parsed, err := ParseSignal(sig)
+		if expected == 0 {
+			if err == nil {
+				t.Errorf("invalid signal %q is parsed as %d", sig, parsed)
+			}
+			continue
+		}
+		if err != nil || parsed != expected {
+			t.Errorf("signal %q is parsed as %d, expect %d : %v", sig, parsed, expected, err)
+		}
+	}
+}
+
+func TestValidateId(t *testing.T) {
+	cases := map[string]bool{
+		"web":       true,
+		"web-1.2_a": true,
+		"a+b":       true,
+		"":          false,
+		".":         false,
+		"..":        false,
+		"a/b":       false,
+		"a b":       false,
+	}
+	for id, valid := range cases {
+		if err := validateId(id); (err == nil) != valid {
+			t.Errorf("id %q is valid is %v, expect %v", id, err == nil, valid)
+		}
+	}
+}
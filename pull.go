@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/qqzeng/tinydocker/registry"
+	"os"
+	"strings"
+)
+
+/* a client of registry with the credentials saved by `login`, if any. */
+func newRegistryClient(domain string) (*registry.Client, error) {
+	auth, err := registry.LoadAuth(registry.DefaultAuthFile, domain)
+	if err != nil {
+		return nil, err
+	}
+	return registry.NewClient(domain, auth), nil
+}
+
+/* pull image from registry, e.g. busybox, localhost:5000/app:v1 or app@sha256:<hex>. */
+func PullImage(name string, quiet bool) error {
+	named, err := registry.ParseNamed(name)
+	if err != nil {
+		return err
+	}
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+	client, err := newRegistryClient(named.Domain)
+	if err != nil {
+		return err
+	}
+	if !quiet {
+		fmt.Printf("%s: Pulling from %s\n", named.ManifestRef(), named.Path)
+	}
+	img, digest, err := registry.Pull(store, client, named)
+	if err != nil {
+		return err
+	}
+	if quiet {
+		fmt.Println(named)
+		return nil
+	}
+	fmt.Printf("Digest: %s\n", digest)
+	fmt.Printf("Status: Downloaded image %s for %s\n", img.ID.Short(), named)
+	return nil
+}
+
+/* push image in store to registry by its reference, e.g. localhost:5000/app:v1. */
+func PushImage(name string) error {
+	named, err := registry.ParseNamed(name)
+	if err != nil {
+		return err
+	}
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+	img, err := store.Lookup(named.Reference().String())
+	if err != nil {
+		return err
+	}
+	client, err := newRegistryClient(named.Domain)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("The push refers to repository [%s/%s]\n", named.Domain, named.Path)
+	digest, err := registry.Push(store, client, named, img)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: digest: %s\n", named.Tag, digest)
+	return nil
+}
+
+/* verify credentials of registry server, Docker Hub by default, and save them. */
+func Login(server string, username string, password string, passwordStdin bool) error {
+	domain := registry.DefaultDomain
+	if server != "" {
+		domain = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://"), "/")
+	}
+	if username == "" {
+		return fmt.Errorf("missing username, use -u")
+	}
+	if passwordStdin {
+		if password != "" {
+			return fmt.Errorf("--password and --password-stdin can not be both given")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read password from stdin error : %v", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		return fmt.Errorf("missing password, use -p or --password-stdin")
+	}
+	auth := &registry.AuthConfig{Username: username, Password: password}
+	if err := registry.NewClient(domain, auth).Login(); err != nil {
+		return err
+	}
+	if err := registry.SaveAuth(registry.DefaultAuthFile, domain, auth); err != nil {
+		return fmt.Errorf("save credentials error : %v", err)
+	}
+	fmt.Println("Login Succeeded")
+	return nil
+}
@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/* credentials of registries saved by `login`, in the format of docker config.json. */
+const DefaultAuthFile = "/root/.tinydocker/config.json"
+
+type AuthConfig struct {
+	Username string
+	Password string
+}
+
+type authFile struct {
+	Auths map[string]authEntry `json:"auths"`
+}
+
+type authEntry struct {
+	Auth string `json:"auth"` /* base64 of username:password */
+}
+
+func loadAuthFile(file string) (*authFile, error) {
+	auths := &authFile{Auths: map[string]authEntry{}}
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return auths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, auths); err != nil {
+		return nil, fmt.Errorf("unmarshal auth file %s error : %v", file, err)
+	}
+	if auths.Auths == nil {
+		auths.Auths = map[string]authEntry{}
+	}
+	return auths, nil
+}
+
+/* the saved credentials of registry domain, nil if not logged in. */
+func LoadAuth(file string, domain string) (*AuthConfig, error) {
+	auths, err := loadAuthFile(file)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := auths.Auths[domain]
+	if !ok {
+		return nil, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("decode auth of %s error : %v", domain, err)
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid auth of %s", domain)
+	}
+	return &AuthConfig{Username: parts[0], Password: parts[1]}, nil
+}
+
+/* save credentials of registry domain, only readable by owner. */
+func SaveAuth(file string, domain string, auth *AuthConfig) error {
+	auths, err := loadAuthFile(file)
+	if err != nil {
+		return err
+	}
+	auths.Auths[domain] = authEntry{Auth: base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))}
+	content, err := json.MarshalIndent(auths, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, content, 0600)
+}
+
+/* a challenge of `WWW-Authenticate` header, e.g. Bearer realm="...",service="...",scope="...". */
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+var challengeParamRegexp = regexp.MustCompile(`([a-zA-Z]+)="([^"]*)"`)
+
+func parseChallenge(header string) challenge {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	c := challenge{scheme: strings.ToLower(parts[0]), params: map[string]string{}}
+	if len(parts) == 2 {
+		for _, match := range challengeParamRegexp.FindAllStringSubmatch(parts[1], -1) {
+			c.params[strings.ToLower(match[1])] = match[2]
+		}
+	}
+	return c
+}
+
+/* get a bearer token of scope from the token server of challenge, with credentials if given. */
+func (c *Client) fetchToken(ch challenge, scope string) (string, error) {
+	realm, ok := ch.params["realm"]
+	if !ok {
+		return "", fmt.Errorf("bearer challenge of %s has no realm", c.domain)
+	}
+	realmUrl, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid realm %s : %v", realm, err)
+	}
+	query := realmUrl.Query()
+	if service, ok := ch.params["service"]; ok {
+		query.Set("service", service)
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	realmUrl.RawQuery = query.Encode()
+	req, err := http.NewRequest("GET", realmUrl.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.auth != nil {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("get token from %s error : %v", realmUrl.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("unauthorized to %s, login with correct credentials", c.domain)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get token from %s error : %s", realmUrl.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decode token error : %v", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("token server of %s returns no token", c.domain)
+	}
+	return token.Token, nil
+}
@@ -0,0 +1,409 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/image"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	/* manifests larger than this are rejected, as they are read into memory. */
+	maxManifestSize = 4 << 20
+	defaultRetries  = 5
+)
+
+/* the manifest types accepted from registry, the first is preferred. */
+var manifestTypes = []string{
+	image.MediaTypeImageIndex,
+	image.MediaTypeDockerManifestList,
+	image.MediaTypeImageManifest,
+	image.MediaTypeDockerManifest,
+}
+
+/* a client of a registry serving the distribution v2 API. */
+type Client struct {
+	domain     string
+	endpoint   string /* scheme and host of registry, e.g. https://registry-1.docker.io */
+	auth       *AuthConfig
+	http       *http.Client
+	basic      bool              /* whether registry asks for basic auth instead of tokens */
+	tokens     map[string]string /* bearer tokens by scope */
+	retries    int
+	retryDelay time.Duration
+}
+
+/* a client of registry domain, with credentials if auth is not nil. */
+func NewClient(domain string, auth *AuthConfig) *Client {
+	host := domain
+	if domain == DefaultDomain {
+		host = defaultEndpoint
+	}
+	scheme := "https"
+	if isLoopback(host) {
+		scheme = "http"
+	}
+	return &Client{
+		domain:     domain,
+		endpoint:   scheme + "://" + host,
+		auth:       auth,
+		http:       &http.Client{},
+		tokens:     map[string]string{},
+		retries:    defaultRetries,
+		retryDelay: time.Second,
+	}
+}
+
+/* registries on loopback are served over plain http, as docker allows them as insecure. */
+func isLoopback(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
+
+func repositoryScope(path string, actions string) string {
+	return "repository:" + path + ":" + actions
+}
+
+func (c *Client) url(format string, args ...interface{}) string {
+	return c.endpoint + "/v2/" + fmt.Sprintf(format, args...)
+}
+
+func (c *Client) authorize(req *http.Request, scope string) {
+	if c.basic {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	} else if token, ok := c.tokens[scope]; ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+/*
+	send request with authorization of scope. A challenge of registry is answered once, by a bearer
+	token from its token server or by basic auth, and request is sent again.
+*/
+func (c *Client) do(req *http.Request, scope string) (*http.Response, error) {
+	c.authorize(req, scope)
+	resp, err := c.http.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	ch := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch ch.scheme {
+	case "bearer":
+		if scope == "" {
+			scope = ch.params["scope"]
+		}
+		token, err := c.fetchToken(ch, scope)
+		if err != nil {
+			return nil, err
+		}
+		c.tokens[scope] = token
+	case "basic":
+		if c.auth == nil {
+			return nil, fmt.Errorf("unauthorized to %s, login first", c.domain)
+		}
+		c.basic = true
+	default:
+		return nil, fmt.Errorf("unauthorized to %s with unsupported challenge %q", c.domain, ch.scheme)
+	}
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("request to %s can not be sent again", req.URL.Path)
+		}
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	c.authorize(req, scope)
+	if resp, err = c.http.Do(req); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unauthorized to %s, login with correct credentials", c.domain)
+	}
+	return resp, nil
+}
+
+/* the error of an unexpected response, with the messages of registry if any. */
+func responseError(resp *http.Response, action string) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(content, &body) == nil && len(body.Errors) > 0 {
+		var messages []string
+		for _, e := range body.Errors {
+			messages = append(messages, strings.ToLower(e.Code)+": "+e.Message)
+		}
+		return fmt.Errorf("%s error : %s, %s", action, resp.Status, strings.Join(messages, "; "))
+	}
+	return fmt.Errorf("%s error : %s", action, resp.Status)
+}
+
+/* check registry is reachable and accepts credentials of client, if any. */
+func (c *Client) Login() error {
+	req, err := http.NewRequest("GET", c.endpoint+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "login to "+c.domain)
+	}
+	return nil
+}
+
+/*
+	get manifest or index of repository by tag or digest, with its media type and digest. Content
+	is verified against the digest requested or the one registry reports.
+*/
+func (c *Client) GetManifest(path string, ref string) ([]byte, string, image.Digest, error) {
+	req, err := http.NewRequest("GET", c.url("%s/manifests/%s", path, ref), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	resp, err := c.do(req, repositoryScope(path, "pull"))
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", "", fmt.Errorf("manifest %s of %s is not found", ref, path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", responseError(resp, "get manifest "+ref)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("read manifest %s error : %v", ref, err)
+	}
+	if len(content) > maxManifestSize {
+		return nil, "", "", fmt.Errorf("manifest %s is larger than %d bytes", ref, maxManifestSize)
+	}
+	digest := image.FromBytes(content)
+	if expected := resp.Header.Get("Docker-Content-Digest"); expected != "" && expected != digest.String() {
+		return nil, "", "", fmt.Errorf("digest of manifest %s is %s, but registry reports %s", ref, digest, expected)
+	}
+	if strings.HasPrefix(ref, "sha256:") && ref != digest.String() {
+		return nil, "", "", fmt.Errorf("digest of manifest %s is %s", ref, digest)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType == "application/json" {
+		if mediaType, err = image.ParseMediaType(content); err != nil {
+			return nil, "", "", err
+		}
+	}
+	return content, mediaType, digest, nil
+}
+
+/* an error after which a download is tried again, e.g. a broken connection. */
+type retriableError struct {
+	error
+}
+
+/*
+	download blob of repository into file and verify its digest. A broken download is tried again
+	from where it stopped, if registry supports range requests.
+*/
+func (c *Client) FetchBlob(path string, desc image.Descriptor, file *os.File) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		err := c.fetchBlobOnce(path, desc, file)
+		if err == nil {
+			break
+		}
+		if _, ok := err.(retriableError); !ok || attempt >= c.retries {
+			return err
+		}
+		log.Warnf("fetch blob %s error : %v, retry", desc.Digest.Short(), err)
+		time.Sleep(c.retryDelay * time.Duration(attempt+1))
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if desc.Size > 0 && size != desc.Size {
+		return fmt.Errorf("size of blob %s is %d, expect %d", desc.Digest, size, desc.Size)
+	}
+	if actual := image.FromHash(hash); actual != desc.Digest {
+		return fmt.Errorf("digest of blob %s is %s", desc.Digest, actual)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+/* download the rest of blob, the part in file is kept if registry sends only the rest. */
+func (c *Client) fetchBlobOnce(path string, desc image.Descriptor, file *os.File) error {
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if desc.Size > 0 && offset == desc.Size {
+		return nil
+	}
+	req, err := http.NewRequest("GET", c.url("%s/blobs/%s", path, desc.Digest), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.do(req, repositoryScope(path, "pull"))
+	if err != nil {
+		if _, ok := err.(*url.Error); ok {
+			return retriableError{err}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			file.Truncate(0)
+			return retriableError{fmt.Errorf("unexpected range %s", resp.Header.Get("Content-Range"))}
+		}
+	case resp.StatusCode == http.StatusOK:
+		/* registry ignores range and sends whole blob. */
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("blob %s of %s is not found", desc.Digest, path)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return retriableError{responseError(resp, "fetch blob "+desc.Digest.Short())}
+	default:
+		return responseError(resp, "fetch blob "+desc.Digest.Short())
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return retriableError{err}
+	}
+	return nil
+}
+
+func (c *Client) blobExists(path string, digest image.Digest) (bool, error) {
+	req, err := http.NewRequest("HEAD", c.url("%s/blobs/%s", path, digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, repositoryScope(path, "pull,push"))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("check blob %s error : %s", digest.Short(), resp.Status)
+}
+
+/* upload blob to repository in a single request, unless repository has it already. */
+func (c *Client) PushBlob(path string, desc image.Descriptor, content io.ReadSeeker) error {
+	scope := repositoryScope(path, "pull,push")
+	if exists, err := c.blobExists(path, desc.Digest); err != nil || exists {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.url("%s/blobs/uploads/", path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError(resp, "start upload of blob "+desc.Digest.Short())
+	}
+	/* the upload location may be relative to registry. */
+	base, err := url.Parse(c.endpoint)
+	if err != nil {
+		return err
+	}
+	location, err := base.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("invalid upload location %s : %v", resp.Header.Get("Location"), err)
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest.String())
+	location.RawQuery = query.Encode()
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err = http.NewRequest("PUT", location.String(), ioutil.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = desc.Size
+	req.GetBody = func() (io.ReadCloser, error) {
+		_, err := content.Seek(0, io.SeekStart)
+		return ioutil.NopCloser(content), err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(req, scope)
+	if err != nil {
+		return fmt.Errorf("upload blob %s error : %v", desc.Digest.Short(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "upload blob "+desc.Digest.Short())
+	}
+	return nil
+}
+
+/* put manifest to repository by tag, and get its digest. */
+func (c *Client) PutManifest(path string, ref string, mediaType string, content []byte) (image.Digest, error) {
+	req, err := http.NewRequest("PUT", c.url("%s/manifests/%s", path, ref), bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req, repositoryScope(path, "pull,push"))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError(resp, "put manifest "+ref)
+	}
+	return image.FromBytes(content), nil
+}
@@ -0,0 +1,155 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/image"
+	"io/ioutil"
+	"os"
+)
+
+/*
+	pull image from registry into store, and tag it unless it is named by digest. An index is
+	resolved to the manifest for host platform, and layers already in store are not downloaded.
+	The digest of manifest or index pulled is returned with the image.
+*/
+func Pull(store *image.Store, client *Client, named Named) (*image.Image, image.Digest, error) {
+	content, mediaType, digest, err := client.GetManifest(named.Path, named.ManifestRef())
+	if err != nil {
+		return nil, "", err
+	}
+	if image.IsIndex(mediaType) {
+		var index image.Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return nil, "", fmt.Errorf("unmarshal index of %s error : %v", named, err)
+		}
+		desc, err := index.HostManifest()
+		if err != nil {
+			return nil, "", fmt.Errorf("pull %s error : %v", named, err)
+		}
+		if err := desc.Digest.Validate(); err != nil {
+			return nil, "", fmt.Errorf("pull %s error : %v", named, err)
+		}
+		if content, mediaType, _, err = client.GetManifest(named.Path, desc.Digest.String()); err != nil {
+			return nil, "", err
+		}
+	}
+	if !image.IsManifest(mediaType) {
+		return nil, "", fmt.Errorf("unsupported manifest type %s of %s", mediaType, named)
+	}
+	var manifest image.Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, "", fmt.Errorf("unmarshal manifest of %s error : %v", named, err)
+	}
+
+	/* digests of manifest name files of store, check them before any is used. */
+	for _, desc := range append([]image.Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := desc.Digest.Validate(); err != nil {
+			return nil, "", fmt.Errorf("pull %s error : %v", named, err)
+		}
+	}
+	config, err := fetchConfig(store, client, named.Path, manifest.Config)
+	if err != nil {
+		return nil, "", err
+	}
+	img, err := image.ParseImage(config)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(manifest.Layers) != len(img.RootFS.DiffIDs) {
+		return nil, "", fmt.Errorf("manifest of %s has %d layers, but config has %d", named, len(manifest.Layers), len(img.RootFS.DiffIDs))
+	}
+	for i, layer := range manifest.Layers {
+		diffID := img.RootFS.DiffIDs[i]
+		if _, err := os.Stat(store.BlobPath(diffID)); err == nil {
+			log.Infof("%s: already exists", layer.Digest.Short())
+			continue
+		}
+		if !image.IsSupportedLayer(layer.MediaType) {
+			return nil, "", fmt.Errorf("unsupported layer type %s of %s", layer.MediaType, named)
+		}
+		if err := pullLayer(store, client, named.Path, layer, diffID); err != nil {
+			return nil, "", err
+		}
+		log.Infof("%s: pull complete", layer.Digest.Short())
+	}
+	if img, err = store.PutImageConfig(config); err != nil {
+		return nil, "", err
+	}
+	if named.Tag != "" {
+		if err := store.Tag(named.Reference(), img.ID); err != nil {
+			return nil, "", err
+		}
+	}
+	return img, digest, nil
+}
+
+func fetchConfig(store *image.Store, client *Client, path string, desc image.Descriptor) ([]byte, error) {
+	file, err := store.TempFile("config")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := client.FetchBlob(path, desc, file); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(file)
+}
+
+/* download layer and import it, its content must match the diff id in config. */
+func pullLayer(store *image.Store, client *Client, path string, layer image.Descriptor, diffID image.Digest) error {
+	file, err := store.TempFile("download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := client.FetchBlob(path, layer, file); err != nil {
+		return err
+	}
+	imported, err := store.ImportLayer(file)
+	if err != nil {
+		return err
+	}
+	if imported != diffID {
+		return fmt.Errorf("diff id of layer %s is %s, but config has %s", layer.Digest, imported, diffID)
+	}
+	return nil
+}
+
+/*
+	push image to registry by the tag of named. Layers are pushed uncompressed as they are kept in
+	store, and those already in repository are skipped. The digest of manifest is returned.
+*/
+func Push(store *image.Store, client *Client, named Named, img *image.Image) (image.Digest, error) {
+	if named.Tag == "" {
+		return "", fmt.Errorf("push %s error : a tag is required", named)
+	}
+	manifest, config, err := store.ImageManifest(img)
+	if err != nil {
+		return "", err
+	}
+	for _, layer := range manifest.Layers {
+		file, err := os.Open(store.BlobPath(layer.Digest))
+		if err != nil {
+			return "", err
+		}
+		err = client.PushBlob(named.Path, layer, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		log.Infof("%s: pushed", layer.Digest.Short())
+	}
+	if err := client.PushBlob(named.Path, manifest.Config, bytes.NewReader(config)); err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	return client.PutManifest(named.Path, named.Tag, manifest.MediaType, content)
+}
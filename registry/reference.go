@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/qqzeng/tinydocker/image"
+	"strings"
+)
+
+const (
+	/* the registry of images named without registry host, e.g. `busybox`. */
+	DefaultDomain   = "docker.io"
+	defaultEndpoint = "registry-1.docker.io"
+	officialPrefix  = "library/"
+)
+
+/* an image in registry, by tag or by digest. */
+type Named struct {
+	Domain string       /* the registry host as named, e.g. docker.io or localhost:5000 */
+	Path   string       /* the repository in registry, e.g. library/busybox */
+	Tag    string       /* empty if image is named by digest */
+	Digest image.Digest /* the digest of manifest, if given */
+}
+
+/*
+	parse name in form of [host[:port]/]repository[:tag|@digest]. The first component is a host if
+	it has a dot or a port or is localhost, images without host are from Docker Hub.
+*/
+func ParseNamed(name string) (Named, error) {
+	var named Named
+	if index := strings.Index(name, "@"); index >= 0 {
+		digest, err := image.ParseDigest(name[index+1:])
+		if err != nil || !strings.HasPrefix(name[index+1:], "sha256:") {
+			return Named{}, fmt.Errorf("invalid digest in %s", name)
+		}
+		named.Digest = digest
+		name = name[:index]
+		if strings.LastIndex(name, ":") > strings.LastIndex(name, "/") {
+			return Named{}, fmt.Errorf("both tag and digest are given in %s", name)
+		}
+	}
+	ref, err := image.ParseReference(name)
+	if err != nil {
+		return Named{}, err
+	}
+	if named.Digest == "" {
+		named.Tag = ref.Tag
+	}
+	named.Domain, named.Path = DefaultDomain, ref.Repository
+	if index := strings.Index(ref.Repository, "/"); index >= 0 {
+		first := ref.Repository[:index]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			named.Domain, named.Path = first, ref.Repository[index+1:]
+		}
+	}
+	if named.Domain == DefaultDomain && !strings.Contains(named.Path, "/") {
+		named.Path = officialPrefix + named.Path
+	}
+	return named, nil
+}
+
+/* the reference to image in local store, with the default registry and library left out. */
+func (n Named) Reference() image.Reference {
+	repository := n.Domain + "/" + n.Path
+	if n.Domain == DefaultDomain {
+		repository = strings.TrimPrefix(n.Path, officialPrefix)
+	}
+	return image.Reference{Repository: repository, Tag: n.Tag}
+}
+
+/* the tag or digest of manifest in registry. */
+func (n Named) ManifestRef() string {
+	if n.Digest != "" {
+		return n.Digest.String()
+	}
+	return n.Tag
+}
+
+func (n Named) String() string {
+	if n.Digest != "" {
+		return n.Reference().Repository + "@" + n.Digest.String()
+	}
+	return n.Reference().String()
+}
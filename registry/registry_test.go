@@ -0,0 +1,435 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/qqzeng/tinydocker/image"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+	an in-process registry serving the distribution v2 API, with a token server asking for
+	credentials if username is set.
+*/
+type fakeRegistry struct {
+	t         *testing.T
+	server    *httptest.Server
+	username  string
+	password  string
+	mu        sync.Mutex
+	blobs     map[image.Digest][]byte
+	manifests map[string][]byte /* by repository and tag or digest */
+	types     map[string]string
+	truncate  map[image.Digest]bool /* blobs whose next download breaks halfway */
+	ranges    int                   /* range requests of blobs */
+	blobGets  int
+	uploads   int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		t:         t,
+		blobs:     map[image.Digest][]byte{},
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+		truncate:  map[image.Digest]bool{},
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+func (r *fakeRegistry) domain() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func (r *fakeRegistry) addBlob(content []byte) image.Descriptor {
+	r.blobs[image.FromBytes(content)] = content
+	return image.Descriptor{Digest: image.FromBytes(content), Size: int64(len(content))}
+}
+
+func (r *fakeRegistry) addManifest(repository string, tag string, mediaType string, v interface{}) image.Descriptor {
+	content, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	digest := image.FromBytes(content)
+	for _, ref := range []string{tag, digest.String()} {
+		r.manifests[repository+"@"+ref] = content
+		r.types[repository+"@"+ref] = mediaType
+	}
+	return image.Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content))}
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.URL.Path == "/token" {
+		if user, password, _ := req.BasicAuth(); r.username != "" && (user != r.username || password != r.password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "token-" + req.URL.Query().Get("scope")})
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer token-") {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if path == "" {
+		return
+	}
+	if index := strings.LastIndex(path, "/manifests/"); index >= 0 {
+		key := path[:index] + "@" + path[index+len("/manifests/"):]
+		switch req.Method {
+		case "GET":
+			content, ok := r.manifests[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", r.types[key])
+			w.Header().Set("Docker-Content-Digest", image.FromBytes(content).String())
+			w.Write(content)
+		case "PUT":
+			content, _ := ioutil.ReadAll(req.Body)
+			digest := image.FromBytes(content)
+			for _, k := range []string{key, path[:index] + "@" + digest.String()} {
+				r.manifests[k] = content
+				r.types[k] = req.Header.Get("Content-Type")
+			}
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+	if strings.Contains(path, "/blobs/uploads/") {
+		switch req.Method {
+		case "POST":
+			w.Header().Set("Location", "/v2/"+path+"1?state=a")
+			w.WriteHeader(http.StatusAccepted)
+		case "PUT":
+			content, _ := ioutil.ReadAll(req.Body)
+			if digest := image.FromBytes(content); digest.String() != req.URL.Query().Get("digest") || req.URL.Query().Get("state") != "a" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.blobs[image.FromBytes(content)] = content
+			r.uploads++
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+	index := strings.LastIndex(path, "/blobs/")
+	if index < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	digest := image.Digest(path[index+len("/blobs/"):])
+	content, ok := r.blobs[digest]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	if req.Method == "HEAD" {
+		return
+	}
+	r.blobGets++
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		r.ranges++
+		offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)-offset))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[offset:])
+		return
+	}
+	if r.truncate[digest] {
+		delete(r.truncate, digest)
+		w.Write(content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(content)
+}
+
+func testLayer(t *testing.T, name string, content string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte(content))
+	tw.Close()
+	return buf.Bytes()
+}
+
+func newTestStore(t *testing.T) (*image.Store, func()) {
+	root, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := image.NewStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, func() { os.RemoveAll(root) }
+}
+
+func testClient(r *fakeRegistry, auth *AuthConfig) *Client {
+	client := NewClient(r.domain(), auth)
+	client.retryDelay = 0
+	return client
+}
+
+/* an image of gzip compressed layer with content in registry. */
+func (r *fakeRegistry) addImage(t *testing.T, repository string, tag string, content string) (image.Descriptor, image.Digest) {
+	layer := testLayer(t, "file", content)
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write(layer)
+	gw.Close()
+	layerDesc := r.addBlob(compressed.Bytes())
+	layerDesc.MediaType = image.MediaTypeImageLayerGzip
+	img := image.NewImage()
+	img.RootFS.DiffIDs = []image.Digest{image.FromBytes(layer)}
+	config, _ := json.Marshal(img)
+	configDesc := r.addBlob(config)
+	configDesc.MediaType = image.MediaTypeImageConfig
+	manifest := image.Manifest{SchemaVersion: 2, MediaType: image.MediaTypeImageManifest, Config: configDesc, Layers: []image.Descriptor{layerDesc}}
+	return r.addManifest(repository, tag, image.MediaTypeImageManifest, manifest), configDesc.Digest
+}
+
+func TestParseNamed(t *testing.T) {
+	digest := image.FromBytes([]byte("a"))
+	cases := map[string]Named{
+		"busybox":                             {Domain: "docker.io", Path: "library/busybox", Tag: "latest"},
+		"docker.io/library/busybox:1.36":      {Domain: "docker.io", Path: "library/busybox", Tag: "1.36"},
+		"team/app:v1":                         {Domain: "docker.io", Path: "team/app", Tag: "v1"},
+		"localhost:5000/app":                  {Domain: "localhost:5000", Path: "app", Tag: "latest"},
+		"ghcr.io/team/app@" + digest.String(): {Domain: "ghcr.io", Path: "team/app", Digest: digest},
+	}
+	for name, expected := range cases {
+		if named, err := ParseNamed(name); err != nil || named != expected {
+			t.Errorf("%s is parsed as %+v, expect %+v : %v", name, named, expected, err)
+		}
+	}
+	if named, _ := ParseNamed("docker.io/library/busybox"); named.Reference().String() != "busybox:latest" {
+		t.Errorf("local reference of docker.io/library/busybox is %s", named.Reference())
+	}
+	for _, name := range []string{"Busybox", "busybox@sha256:00", "busybox:v1@" + digest.String()} {
+		if named, err := ParseNamed(name); err == nil {
+			t.Errorf("invalid name %s is parsed as %+v", name, named)
+		}
+	}
+}
+
+func TestPushAndPull(t *testing.T) {
+	r := newFakeRegistry(t)
+	defer r.server.Close()
+	r.username, r.password = "user", "secret"
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	img := image.NewImage()
+	for _, layer := range [][]byte{testLayer(t, "a", "a"), testLayer(t, "b", "b")} {
+		diffID, err := store.ImportLayer(bytes.NewReader(layer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img.RootFS.DiffIDs = append(img.RootFS.DiffIDs, diffID)
+	}
+	if _, err := store.CreateImage(img); err != nil {
+		t.Fatal(err)
+	}
+	named, err := ParseNamed(r.domain() + "/team/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Push(store, testClient(r, nil), named, img); err == nil {
+		t.Errorf("push without credentials succeeds")
+	}
+	if err := testClient(r, &AuthConfig{Username: "user", Password: "wrong"}).Login(); err == nil {
+		t.Errorf("login with wrong password succeeds")
+	}
+	auth := &AuthConfig{Username: "user", Password: "secret"}
+	if err := testClient(r, auth).Login(); err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := Push(store, testClient(r, auth), named, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* blobs in repository are not uploaded again. */
+	if _, err := Push(store, testClient(r, auth), named, img); err != nil || r.uploads != 3 {
+		t.Errorf("pushing again uploads %d blobs in total, expect 3 : %v", r.uploads, err)
+	}
+
+	other, otherCleanup := newTestStore(t)
+	defer otherCleanup()
+	pulled, digest, err := Pull(other, testClient(r, auth), named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.ID != img.ID || digest != pushed {
+		t.Errorf("pulled image %s of manifest %s, expect image %s of %s", pulled.ID, digest, img.ID, pushed)
+	}
+	if found, err := other.Lookup(r.domain() + "/team/app:v1"); err != nil || found.ID != img.ID {
+		t.Errorf("pulled image is not tagged : %v", err)
+	}
+	dir, err := other.RootfsDir(pulled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(dir, "b")); string(content) != "b" {
+		t.Errorf("file of pulled image is %q", content)
+	}
+	/* layers in store are not downloaded again. */
+	gets := r.blobGets
+	if _, _, err := Pull(other, testClient(r, auth), named); err != nil || r.blobGets != gets+1 {
+		t.Errorf("pulling again downloads %d blobs, expect only config : %v", r.blobGets-gets, err)
+	}
+}
+
+func TestPullManifestList(t *testing.T) {
+	r := newFakeRegistry(t)
+	defer r.server.Close()
+	other, _ := r.addImage(t, "app", "other", "other")
+	host, hostConfig := r.addImage(t, "app", "host", "host")
+	other.Platform = &image.Platform{OS: runtime.GOOS, Architecture: "unknown"}
+	host.Platform = &image.Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	index := image.Index{SchemaVersion: 2, MediaType: image.MediaTypeImageIndex, Manifests: []image.Descriptor{other, host}}
+	indexDesc := r.addManifest("app", "latest", image.MediaTypeImageIndex, index)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	named, _ := ParseNamed(r.domain() + "/app")
+	img, digest, err := Pull(store, testClient(r, nil), named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ID != hostConfig || digest != indexDesc.Digest {
+		t.Errorf("pulled image %s of %s, expect %s of index %s", img.ID, digest, hostConfig, indexDesc.Digest)
+	}
+
+	/* by digest, the image is kept untagged. */
+	byDigest, _ := ParseNamed(r.domain() + "/app@" + host.Digest.String())
+	if img, _, err := Pull(store, testClient(r, nil), byDigest); err != nil || img.ID != hostConfig {
+		t.Errorf("pull by digest error : %v", err)
+	}
+	if refs, _ := store.References(hostConfig); len(refs) != 1 {
+		t.Errorf("references of image are %v, expect only latest", refs)
+	}
+}
+
+func TestPullResumeAndVerify(t *testing.T) {
+	r := newFakeRegistry(t)
+	defer r.server.Close()
+	desc, _ := r.addImage(t, "app", "latest", strings.Repeat("content", 4096))
+	var manifest image.Manifest
+	json.Unmarshal(r.manifests["app@latest"], &manifest)
+	layer := manifest.Layers[0].Digest
+	r.truncate[layer] = true
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	named, _ := ParseNamed(r.domain() + "/app")
+	if _, _, err := Pull(store, testClient(r, nil), named); err != nil {
+		t.Fatal(err)
+	}
+	if r.ranges != 1 {
+		t.Errorf("broken download is resumed by %d range requests, expect 1", r.ranges)
+	}
+
+	/* blobs not matching their digests are rejected. */
+	other, otherCleanup := newTestStore(t)
+	defer otherCleanup()
+	content := r.blobs[layer]
+	r.blobs[layer] = append([]byte{}, content...)
+	r.blobs[layer][len(content)-1] ^= 0xff
+	if _, _, err := Pull(other, testClient(r, nil), named); err == nil {
+		t.Errorf("pull corrupted layer succeeds")
+	}
+	r.blobs[layer] = content
+	r.manifests["app@"+desc.Digest.String()] = append(r.manifests["app@latest"], ' ')
+	byDigest, _ := ParseNamed(r.domain() + "/app@" + desc.Digest.String())
+	if _, _, err := Pull(other, testClient(r, nil), byDigest); err == nil {
+		t.Errorf("pull corrupted manifest succeeds")
+	}
+}
+
+/* digests served by registry name files of store, those climbing out of it are refused. */
+func TestPullInvalidDigests(t *testing.T) {
+	r := newFakeRegistry(t)
+	defer r.server.Close()
+	layer := testLayer(t, "file", "content")
+	layerDesc := r.addBlob(layer)
+	layerDesc.MediaType = image.MediaTypeImageLayer
+	escaping := image.Digest("sha256:../../../../../../../../..")
+
+	img := image.NewImage()
+	img.RootFS.DiffIDs = []image.Digest{escaping}
+	config, _ := json.Marshal(img)
+	configDesc := r.addBlob(config)
+	configDesc.MediaType = image.MediaTypeImageConfig
+	r.addManifest("app", "diffid", image.MediaTypeImageManifest,
+		image.Manifest{SchemaVersion: 2, MediaType: image.MediaTypeImageManifest, Config: configDesc, Layers: []image.Descriptor{layerDesc}})
+
+	img.RootFS.DiffIDs = []image.Digest{image.FromBytes(layer)}
+	config, _ = json.Marshal(img)
+	validConfig := r.addBlob(config)
+	validConfig.MediaType = image.MediaTypeImageConfig
+	escapingLayer := layerDesc
+	escapingLayer.Digest = escaping
+	r.addManifest("app", "layer", image.MediaTypeImageManifest,
+		image.Manifest{SchemaVersion: 2, MediaType: image.MediaTypeImageManifest, Config: validConfig, Layers: []image.Descriptor{escapingLayer}})
+
+	for _, tag := range []string{"diffid", "layer"} {
+		store, cleanup := newTestStore(t)
+		named, _ := ParseNamed(r.domain() + "/app:" + tag)
+		if _, _, err := Pull(store, testClient(r, nil), named); err == nil {
+			t.Errorf("pull image with invalid digest of %s succeeds", tag)
+		}
+		if images, err := store.Images(); err != nil || len(images) != 0 {
+			t.Errorf("images %v are kept after pulling image with invalid digest of %s : %v", images, tag, err)
+		}
+		cleanup()
+	}
+	if _, err := image.ParseImage(r.blobs[configDesc.Digest]); err == nil {
+		t.Errorf("config with invalid diff id is parsed")
+	}
+}
+
+func TestSaveAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config", "config.json")
+	if auth, err := LoadAuth(file, "localhost:5000"); err != nil || auth != nil {
+		t.Errorf("auth of missing file is %v : %v", auth, err)
+	}
+	if err := SaveAuth(file, "localhost:5000", &AuthConfig{Username: "user", Password: "pass:word"}); err != nil {
+		t.Fatal(err)
+	}
+	if auth, err := LoadAuth(file, "localhost:5000"); err != nil || auth.Username != "user" || auth.Password != "pass:word" {
+		t.Errorf("saved auth is %v : %v", auth, err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("auth file is readable by others : %v", err)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/cgroups"
 	"github.com/qqzeng/tinydocker/container"
 	"os"
+	"path"
 )
 
 /*  TODO: clear the volume directory of container. */
@@ -18,9 +20,17 @@ func RemoveContainer(containerName string) {
 		log.Errorf("Can not remove %s container %s", containerInfo.Status, containerName)
 		return
 	}
-	containerSavedDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	cgroupManager := cgroups.NewCgroupManager(path.Join(container.CgroupParent, containerInfo.Id))
+	if err := cgroupManager.Destory(); err != nil {
+		log.Warnf("Remove cgroup of container %s error : %v", containerName, err)
+	}
+	container.DeleteWorkSpace(containerInfo.Volume, containerInfo.Id, containerInfo.Driver)
+	containerSavedDir := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
 	if err := os.RemoveAll(containerSavedDir); err != nil {
 		log.Errorf("Remove container name %s error : %v", containerName, err)
 		return
 	}
+	if err := container.ReleaseName(containerInfo.Name, containerInfo.Id); err != nil {
+		log.Warnf("Release name of container %s error : %v", containerName, err)
+	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
@@ -8,134 +9,306 @@ import (
 	"github.com/qqzeng/tinydocker/cgroups/subsystems"
 	"github.com/qqzeng/tinydocker/container"
 	"github.com/qqzeng/tinydocker/network"
-	"math/rand"
+	"github.com/qqzeng/tinydocker/oci"
+	"github.com/qqzeng/tinydocker/storage"
 	"os"
+	"os/exec"
+	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 func Run(tty bool, comArray []string, res *subsystems.ResourceConfig, volumeStr string,
-	containerName string, imageName string, envSlice []string, nw string, portmapping []string) {
-	id := randStringBytes(container.NameLength)
+	containerName string, imageName string, envSlice []string, nw string, portmapping []string,
+	labels map[string]string, healthConfig *container.HealthConfig, ulimits []container.Ulimit,
+	sysctls map[string]string, namespaces map[string]string, timeOffsets *container.TimeOffsets,
+	storageDriver string, storageOpts map[string]string) {
+	/* image is resolved before supervisor starts, so that errors are visible. */
+	store, err := openImageStore()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	img, err := resolveImage(store, imageName)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	layerDirs, err := store.LayerDirs(img)
+	if err != nil {
+		log.Errorf("Get root filesystem of image %s error : %v", imageName, err)
+		return
+	}
+	imageDir := layerDirs[len(layerDirs)-1]
+	/* drivers keeping layers themselves, e.g. btrfs, make them from those of image store. */
+	if driver, err := storage.New(storageDriver); err == nil {
+		if layerDriver, ok := driver.(storage.LayerDriver); ok {
+			if err := layerDriver.PrepareLayers(layerDirs); err != nil {
+				log.Errorf("Prepare layers of image %s error : %v", imageName, err)
+				return
+			}
+		}
+	}
+	comArray = imageCommand(img.Config, comArray)
+	if len(comArray) == 0 {
+		log.Errorf("No command specified for image %s", imageName)
+		return
+	}
+	envSlice = imageEnv(img.Config, envSlice)
+	/* a detached container is run by a background supervisor, which waits for its exit. */
+	if !tty && os.Getenv(ENV_SUPERVISOR) == "" {
+		/* check name early, as errors of supervisor are not visible. */
+		if containerName != "" {
+			if !container.IsContainerName(containerName) {
+				log.Errorf("Invalid container name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", containerName)
+				return
+			}
+			if c, err := container.LookupContainer(containerName); err == nil && c.Name == containerName {
+				log.Errorf("Container name %s is already in use by container %s", containerName, c.Id)
+				return
+			}
+		}
+		startSupervisor()
+		return
+	}
+	os.Unsetenv(ENV_SUPERVISOR)
+	id := container.NewRandomId()
 	if containerName == "" {
-		containerName = id
+		containerName = id[:TruncatedIdLength]
+	}
+	if err := container.ReserveName(containerName, id); err != nil {
+		log.Error(err)
+		return
 	}
-	parent, wp := container.NewParentProcess(tty, volumeStr, containerName, imageName, envSlice)
+	parent, wp := container.NewParentProcess(tty, volumeStr, id, imageDir, envSlice, namespaces, storageDriver,
+		storageOpts)
 	if parent == nil {
 		log.Error("new parent process error")
+		container.ReleaseName(containerName, id)
 		return
 	}
-	if err := parent.Start(); err != nil {
+	if err := container.StartInitProcess(parent, namespaces); err != nil {
 		log.Error(err)
+		container.ReleaseName(containerName, id)
+		return
 	}
 	/* record container information */
-	cName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName, id, volumeStr)
-	if err != nil {
+	containerInfo := &container.ContainerInfo{
+		Pid:         strconv.Itoa(parent.Process.Pid),
+		Id:          id,
+		Name:        containerName,
+		Command:     strings.Join(comArray, " "),
+		CreateTime:  time.Now().Format(container.TimeFormat),
+		Status:      container.RUNNING,
+		Volume:      volumeStr,
+		PortMapping: portmapping,
+		Image:       imageName,
+		ImageID:     img.ID.String(),
+		Env:         envSlice,
+		Tty:         tty,
+		Resources:   res,
+		Mounts:      container.GetMounts(volumeStr, imageDir, id, storageDriver),
+		StartedAt:   time.Now().Format(container.TimeFormat),
+		Labels:      labels,
+		Healthcheck: healthConfig,
+		Ulimits:     ulimits,
+		Sysctls:     sysctls,
+		Namespaces:  namespaces,
+		TimeOffsets: timeOffsets,
+		Driver:      storageDriver,
+		StorageOpt:  storageOpts,
+		WorkingDir:  img.Config.WorkingDir,
+		User:        img.Config.User,
+	}
+	/* with the pid, tells the init process from a later process reusing its pid. */
+	containerInfo.InitStartTime = container.ProcessStartTime(parent.Process.Pid)
+	if healthConfig != nil {
+		containerInfo.Health = &container.Health{Status: container.HealthStarting}
+	}
+	if err := recordContainerInfo(containerInfo); err != nil {
 		log.Errorf("Record container information error: %v", err)
 	}
 
-	cgroupManager := cgroups.NewCgroupManager("tinydocker-cgroup")
-	defer cgroupManager.Destory()
+	cgroupManager := cgroups.NewCgroupManager(path.Join(container.CgroupParent, id))
 	cgroupManager.Set(res)
 	cgroupManager.Apply(parent.Process.Pid)
 
 	/* setup network information */
 	if nw != "" {
 		network.Init()
-		cInfo := &container.ContainerInfo{
-			Id:          id,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: portmapping,
-		}
-		if err := network.Connect(nw, cInfo); err != nil {
+		if err := network.Connect(nw, containerInfo); err != nil {
 			log.Errorf("Fail to connect network : %v", err)
 			return
 		}
+		if err := containerInfo.Dump(); err != nil {
+			log.Errorf("Record container network information error: %v", err)
+		}
 	}
 
-	sendInitCommand(comArray, wp)
+	if err := runGlobalHooks(containerInfo, oci.Creating, "prestart", "createRuntime"); err != nil {
+		log.Errorf("Run hooks of container %s error : %v", containerName, err)
+		parent.Process.Kill()
+		wp.Close()
+	} else {
+		sendInitCommand(comArray, wp)
+		if err := runGlobalHooks(containerInfo, oci.Running, "poststart"); err != nil {
+			log.Warnf("Run hooks of container %s error : %v", containerName, err)
+		}
+	}
 
 	if tty {
-		parent.Wait()
+		if healthConfig != nil {
+			monitor := startHealthMonitor(id, healthConfig)
+			parent.Wait()
+			monitor.Stop()
+		} else {
+			parent.Wait()
+		}
 		/* TODO: need to delete container information for detached container process. */
-		deleteContainerInfo(cName)
-		container.DeleteWorkSpace(volumeStr, containerName)
+		deleteContainerInfo(id)
+		container.ReleaseName(containerName, id)
+		container.DeleteWorkSpace(volumeStr, id, storageDriver)
+		cgroupManager.Destory()
+		if err := runGlobalHooks(containerInfo, oci.Stopped, "poststop"); err != nil {
+			log.Warnf("Run hooks of container %s error : %v", containerName, err)
+		}
 	} else {
 		log.Infof("Pid of current running container is %v", parent.Process.Pid)
-		//time.Sleep(3 * time.Second)
+		notifySupervisorReady(id)
+		superviseContainer(parent, id, comArray, cgroupManager, nw)
 	}
 }
 
-func sendInitCommand(comArray []string, wp *os.File) {
-	command := strings.Join(comArray, " ")
-	log.Infof("command all is %s", command)
-	wp.WriteString(command)
+/*
+	re-execute ourselves with the same arguments as a supervisor in a new session, and wait until
+	it reports the started container through a pipe.
+*/
+func startSupervisor() {
+	rp, wp, err := container.NewPipe()
+	if err != nil {
+		log.Errorf("New pipe error %v", err)
+		return
+	}
+	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
+	cmd.Env = append(os.Environ(), ENV_SUPERVISOR+"=1")
+	cmd.ExtraFiles = []*os.File{wp}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		log.Errorf("Start container supervisor error : %v", err)
+		return
+	}
 	wp.Close()
+	containerId, err := bufio.NewReader(rp).ReadString('\n')
+	rp.Close()
+	if err != nil {
+		log.Errorf("Container supervisor exits before container starts")
+		cmd.Wait()
+		return
+	}
+	cmd.Process.Release()
+	fmt.Fprint(os.Stdout, containerId)
 }
 
-func randStringBytes(n int) string {
-	letters := "0123456789"
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
+/* tell the waiting `tinydocker run` the container is started. */
+func notifySupervisorReady(containerId string) {
+	readyPipe := os.NewFile(uintptr(3), "supervisor")
+	readyPipe.WriteString(containerId + "\n")
+	readyPipe.Close()
 }
 
-func recordContainerInfo(containerPid int, comArray []string, containerName string,
-	id string, volumeStr string) (string, error) {
-	/* construct container struct. */
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(comArray, " ")
-	containerInfo := &container.ContainerInfo{
-		Pid:        strconv.Itoa(containerPid),
-		Id:         id,
-		Name:       containerName,
-		Command:    command,
-		CreateTime: createTime,
-		Status:     container.RUNNING,
-		Volume:     volumeStr,
-	}
-	containerBytes, err := json.Marshal(containerInfo)
+/* wait for container init process to exit and record its exit code. */
+func waitContainerExit(parent *exec.Cmd, containerId string) {
+	recordContainerExit(containerId, exitCodeOf(parent.Wait()))
+}
+
+func recordContainerExit(containerId string, exitCode int) {
+	log.Infof("Container %s exits with code %d", containerId, exitCode)
+	var containerInfo *container.ContainerInfo
+	err := container.UpdateContainerInfo(containerId, func(current *container.ContainerInfo) bool {
+		if current.Status != container.STOP {
+			current.Status = container.EXIT
+		}
+		current.Pid = ""
+		current.ExitCode = exitCode
+		current.FinishedAt = time.Now().Format(container.TimeFormat)
+		containerInfo = current
+		return true
+	})
 	if err != nil {
-		log.Errorf("Record container %v information error %v", containerName, err)
-		return "", err
+		/* removed while running, nothing to record. */
+		if !os.IsNotExist(err) {
+			log.Errorf("Record exit of container %s error : %v", containerId, err)
+		}
+		return
 	}
-	containerInfoStr := string(containerBytes)
+	if err := runGlobalHooks(containerInfo, oci.Stopped, "poststop"); err != nil {
+		log.Warnf("Run hooks of container %s error : %v", containerId, err)
+	}
+}
 
-	/* create saving directories. */
-	containerSavedUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	// TODO: why created already?
-	//exists, _ := PathExists(containerSavedUrl)
-	//if exists {
-	//	return "", fmt.Errorf("container %s exists, please give another container name", containerName)
-	//}
-	if err := os.MkdirAll(containerSavedUrl, 0622); err != nil {
-		return "", fmt.Errorf("create container saved directory failed, %v", err)
+/*
+	run hooks of global hooks directory for container, with an OCI state built from container
+	information. Stages running inside container, createContainer and startContainer, only apply
+	to OCI containers.
+*/
+func runGlobalHooks(containerInfo *container.ContainerInfo, status string, stages ...string) error {
+	hooks, err := oci.LoadGlobalHooks()
+	if err != nil {
+		return err
 	}
-	log.Infof("Create container saved directory %s", containerSavedUrl)
-	containerSavedFile := containerSavedUrl + container.ConfigName
-	savedFile, err := os.Create(containerSavedFile)
+	state := &oci.PublicState{
+		Version:     oci.Version,
+		ID:          containerInfo.Id,
+		Status:      status,
+		Bundle:      fmt.Sprintf(container.MntUrl, containerInfo.Id),
+		Annotations: containerInfo.Labels,
+	}
+	if status != oci.Stopped {
+		state.Pid, _ = strconv.Atoi(containerInfo.Pid)
+	}
+	stageHooks := map[string][]oci.Hook{
+		"prestart":      hooks.Prestart,
+		"createRuntime": hooks.CreateRuntime,
+		"poststart":     hooks.Poststart,
+		"poststop":      hooks.Poststop,
+	}
+	for _, stage := range stages {
+		if err := oci.RunHooks(stage, stageHooks[stage], state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* send command to init as a JSON array, so that arguments with spaces are kept as they are. */
+func sendInitCommand(comArray []string, wp *os.File) {
+	log.Infof("command all is %s", strings.Join(comArray, " "))
+	command, err := json.Marshal(comArray)
 	if err != nil {
-		return "", fmt.Errorf("create container saved file failed, %v", err)
+		log.Errorf("Marshal command error : %v", err)
+	} else {
+		wp.Write(command)
 	}
-	defer savedFile.Close()
+	wp.Close()
+}
 
-	/* write container information to file. */
-	if _, err := savedFile.WriteString(containerInfoStr); err != nil {
-		return "", fmt.Errorf("write container infomation to file failed, %v", err)
+func recordContainerInfo(containerInfo *container.ContainerInfo) error {
+	/* create saving directories and write container information to file. */
+	containerSavedUrl := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
+	if err := containerInfo.Dump(); err != nil {
+		return err
 	}
-	return containerName, nil
+	log.Infof("Create container saved directory %s", containerSavedUrl)
+	return nil
 }
 
-func deleteContainerInfo(containerName string) {
-	containerSavedUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+func deleteContainerInfo(containerId string) {
+	containerSavedUrl := fmt.Sprintf(container.DefaultInfoLocation, containerId)
 	exists, _ := PathExists(containerSavedUrl)
 	if !exists {
-		log.Errorf("Container %s not found, abort delete operation", containerName)
+		log.Errorf("Container %s not found, abort delete operation", containerId)
 		return
 	}
 	if err := os.RemoveAll(containerSavedUrl); err != nil {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/* load images from a Docker archive or OCI image layout tarball, from stdin if input is empty. */
+func LoadImages(input string, quiet bool) error {
+	var reader io.Reader = os.Stdin
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("open %s error : %v", input, err)
+		}
+		defer file.Close()
+		reader = file
+	}
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+	images, err := store.Load(reader)
+	if err != nil {
+		return fmt.Errorf("load images error : %v", err)
+	}
+	if quiet {
+		return nil
+	}
+	for _, tagged := range images {
+		if len(tagged.References) == 0 {
+			fmt.Printf("Loaded image ID: %s\n", tagged.Image.ID)
+			continue
+		}
+		for _, ref := range tagged.References {
+			fmt.Printf("Loaded image: %s\n", ref)
+		}
+	}
+	return nil
+}
+
+/*
+	save images to a tarball, to stdout if output is empty. Images given by reference are saved
+	with it, those given by id are saved untagged.
+*/
+func SaveImages(names []string, output string) error {
+	store, err := openImageStore()
+	if err != nil {
+		return err
+	}
+	var images []image.TaggedImage
+	for _, name := range names {
+		img, err := resolveImage(store, name)
+		if err != nil {
+			return err
+		}
+		tagged := image.TaggedImage{Image: img}
+		if ref, err := image.ParseReference(name); err == nil {
+			if found, err := store.Lookup(ref.String()); err == nil && found.ID == img.ID {
+				tagged.References = []image.Reference{ref}
+			}
+		}
+		images = append(images, tagged)
+	}
+	err = writeOutput(output, func(writer io.Writer) error {
+		return store.Save(writer, images)
+	})
+	if err != nil {
+		return fmt.Errorf("save images error : %v", err)
+	}
+	return nil
+}
+
+/*
+	write a tarball to output, or to stdout if output is empty, which must not be a terminal. Output
+	is written via a temporary file beside it, so that a failed write leaves no partial tarball.
+*/
+/*
+	send logs to stderr if output is stdout, before anything is logged, e.g. by the import of an
+	image of old layout, so that they do not corrupt the archive.
+*/
+func keepStdoutForOutput(output string) {
+	if output == "" {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+func writeOutput(output string, write func(writer io.Writer) error) error {
+	if output == "" {
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refuse to write archive to a terminal, use -o or redirect stdout")
+		}
+		return write(os.Stdout)
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(output), ".tinydocker-output")
+	if err != nil {
+		return err
+	}
+	err = write(tmpFile)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), output)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("write %s error : %v", output, err)
+	}
+	return nil
+}
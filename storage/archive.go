@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	/* a file `.wh.<name>` in layer tarball deletes `<name>` of lower layers, refer to the OCI image spec. */
+	whiteoutPrefix = ".wh."
+	/* the PAX record of an extended attribute, as GNU tar and docker write it. */
+	paxXattrPrefix = "SCHILY.xattr."
+	/* xattrs of overlay marking whiteouts and opaque directories are not content of files. */
+	overlayXattrPrefix = "trusted.overlay."
+)
+
+/*
+	write changes of container as a layer tarball, with files read from its root filesystem at
+	root. A deleted file becomes an OCI whiteout, whether driver marks it by an aufs whiteout, an
+	overlay device or its absence.
+*/
+func WriteChanges(writer io.Writer, root string, changes []Change) error {
+	tw := tar.NewWriter(writer)
+	links := map[uint64]string{}
+	for _, change := range changes {
+		name := strings.TrimPrefix(change.Path, "/")
+		if change.Kind == ChangeDelete {
+			hdr := &tar.Header{
+				Name:     filepath.Join(filepath.Dir(name), whiteoutPrefix+filepath.Base(name)),
+				Typeflag: tar.TypeReg,
+				Mode:     0600,
+				ModTime:  time.Unix(0, 0),
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeTarEntry(tw, filepath.Join(root, name), name, links); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+/*
+	write the whole filesystem at root as a tarball, e.g. the merged root filesystem of container.
+	Filesystems mounted under root, such as volumes, are left out but their mount points are kept.
+*/
+func WriteTree(writer io.Writer, root string) error {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	rootDev := rootInfo.Sys().(*syscall.Stat_t).Dev
+	tw := tar.NewWriter(writer)
+	links := map[uint64]string{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if err := writeTarEntry(tw, path, name, links); err != nil {
+			return err
+		}
+		if info.IsDir() && info.Sys().(*syscall.Stat_t).Dev != rootDev {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+/*
+	write file at path as entry name of tarball with its ownership, mode and extended attributes.
+	A regular file linked to one written before, recorded in links by inode, is written as a
+	hardlink to it. Sockets are skipped as tarball can not hold them.
+*/
+func writeTarEntry(tw *tar.Writer, path string, name string, links map[uint64]string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket != 0 {
+		return nil
+	}
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("archive %s error : %v", path, err)
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	/* names of owners on host mean nothing in container. */
+	hdr.Uname, hdr.Gname = "", ""
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	for key, value := range xattrs {
+		if strings.HasPrefix(key, overlayXattrPrefix) {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[paxXattrPrefix+key] = value
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && stat.Nlink > 1 {
+		if target, ok := links[stat.Ino]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = target
+			hdr.Size = 0
+		} else {
+			links[stat.Ino] = name
+		}
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("archive %s error : %v", path, err)
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("archive %s error : %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+/* changes of an aufs style upper layer are written as a layer with OCI whiteouts. */
+func TestWriteChanges(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	imageDir := filepath.Join(tmpDir, "image")
+	createTestImage(t, imageDir)
+	upperDir := filepath.Join(tmpDir, "upper")
+	for _, dir := range []string{upperDir, filepath.Join(upperDir, "etc")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		"added":                 "added",
+		aufsWhiteout + "fifo":   "",
+		"etc/" + aufsOpaque:     "",
+		"etc/hosts":             "localhost",
+		aufsMetaPrefix + "plnk": "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(upperDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(filepath.Join(upperDir, "added"), filepath.Join(upperDir, "linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := upperChanges(upperDir, imageDir, aufsWhiteoutOf, aufsIsOpaque)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	/* files are read from upper layer, as from the mounted root filesystem. */
+	if err := WriteChanges(&buf, upperDir, changes); err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := string(hdr.Typeflag) + " " + hdr.Name
+		if hdr.Typeflag == tar.TypeLink {
+			entry += " " + hdr.Linkname
+		}
+		entries = append(entries, entry)
+	}
+	/* entries are in order of changed paths, whiteouts included. */
+	expected := []string{
+		"0 added",
+		"5 etc/",
+		"0 etc/hosts",
+		"0 etc/" + whiteoutPrefix + "passwd",
+		"0 " + whiteoutPrefix + "fifo",
+		"1 linked added",
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("layer has entries %v, expect %v", entries, expected)
+	}
+}
+
+/* the whole tree is written with ownership, device numbers and xattrs, sockets are skipped. */
+func TestWriteTree(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("making devices and setting trusted xattrs need root")
+	}
+	tmpDir, err := ioutil.TempDir("", "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	createTestImage(t, tmpDir)
+	if err := os.Lchown(filepath.Join(tmpDir, "etc", "passwd"), 1000, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mknod(filepath.Join(tmpDir, "null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3))); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(tmpDir, "socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if err := unix.Lsetxattr(filepath.Join(tmpDir, "busybox"), "trusted.comment", []byte("shell"), 0); err != nil {
+		t.Skipf("filesystem has no xattr support : %v", err)
+	}
+	if err := unix.Lsetxattr(filepath.Join(tmpDir, "etc"), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTree(&buf, tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[hdr.Name] = hdr
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{"busybox", "etc/", "etc/passwd", "fifo", "ls", "null", "sh"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("tree has entries %v, expect %v", names, expected)
+	}
+	if hdr := headers["etc/passwd"]; hdr.Uid != 1000 || hdr.Gid != 1001 {
+		t.Errorf("etc/passwd is owned by %d:%d, expect 1000:1001", hdr.Uid, hdr.Gid)
+	}
+	if hdr := headers["null"]; hdr.Typeflag != tar.TypeChar || hdr.Devmajor != 1 || hdr.Devminor != 3 {
+		t.Errorf("null is written as type %c device %d:%d", hdr.Typeflag, hdr.Devmajor, hdr.Devminor)
+	}
+	if hdr := headers["busybox"]; hdr.PAXRecords[paxXattrPrefix+"trusted.comment"] != "shell" {
+		t.Errorf("busybox has PAX records %v", hdr.PAXRecords)
+	}
+	if hdr := headers["etc/"]; len(hdr.PAXRecords) != 0 {
+		t.Errorf("overlay xattrs are written as %v", hdr.PAXRecords)
+	}
+	if hdr := headers["sh"]; hdr.Typeflag != tar.TypeLink || hdr.Linkname != "busybox" {
+		t.Errorf("sh is written as type %c to %s, expect a hardlink to busybox", hdr.Typeflag, hdr.Linkname)
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	/* writable layers of aufs are kept where they were before storage drivers were introduced. */
+	aufsLayerRoot = "/root/writeLayer"
+	aufsWhiteout  = ".wh."
+	/* metadata of aufs, e.g. `.wh..wh..opq` marking an opaque directory. */
+	aufsMetaPrefix = ".wh..wh."
+	aufsOpaque     = aufsMetaPrefix + ".opq"
+)
+
+type aufsDriver struct{}
+
+func init() {
+	Register("aufs", func() (Driver, error) {
+		if !supportsFilesystem("aufs") {
+			return nil, fmt.Errorf("aufs is not supported by kernel")
+		}
+		return &aufsDriver{}, nil
+	})
+}
+
+func (d *aufsDriver) String() string {
+	return "aufs"
+}
+
+func (d *aufsDriver) layerDir(id string) string {
+	return filepath.Join(aufsLayerRoot, id)
+}
+
+func (d *aufsDriver) layerRoot(id string) string {
+	return d.layerDir(id)
+}
+
+func (d *aufsDriver) Create(id string, imageDir string) error {
+	layerDir := d.layerDir(id)
+	if err := clearDir(layerDir); err != nil {
+		return fmt.Errorf("remove existing layer %s error : %v", layerDir, err)
+	}
+	if err := os.MkdirAll(layerDir, 0777); err != nil {
+		return fmt.Errorf("create layer %s error : %v", layerDir, err)
+	}
+	return nil
+}
+
+func (d *aufsDriver) Mount(id string, imageDir string, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0777); err != nil {
+		return fmt.Errorf("create mount point %s error : %v", mountPoint, err)
+	}
+	dirs := "dirs=" + d.layerDir(id) + ":" + imageDir
+	if output, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount aufs to %s error : %v, %s", mountPoint, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *aufsDriver) Unmount(mountPoint string) error {
+	if output, err := exec.Command("umount", mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("unmount %s error : %v, %s", mountPoint, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (d *aufsDriver) Remove(id string) error {
+	return os.RemoveAll(d.layerDir(id))
+}
+
+func (d *aufsDriver) Diff(id string, imageDir string) ([]Change, error) {
+	changes, err := upperChanges(d.layerDir(id), imageDir, aufsWhiteoutOf, aufsIsOpaque)
+	if err != nil {
+		return nil, err
+	}
+	return withoutLostFound(id, changes), nil
+}
+
+/* aufs hides a file of image by an empty `.wh.<name>` file beside it. */
+func aufsWhiteoutOf(path string, info os.FileInfo) (string, bool) {
+	name := info.Name()
+	if strings.HasPrefix(name, aufsMetaPrefix) {
+		return "", true
+	}
+	if strings.HasPrefix(name, aufsWhiteout) {
+		return strings.TrimPrefix(name, aufsWhiteout), true
+	}
+	return "", false
+}
+
+func aufsIsOpaque(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, aufsOpaque))
+	return err == nil
+}
+
+func (d *aufsDriver) Size(id string) (int64, error) {
+	return dirSize(d.layerDir(id))
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	btrfsSuperMagic = 0x9123683E
+	/* ioctls of btrfs taking struct btrfs_ioctl_vol_args, refer linux/btrfs.h. */
+	btrfsIocSnapCreate   = 0x50009401
+	btrfsIocSubvolCreate = 0x5000940E
+	btrfsIocSnapDestroy  = 0x5000940F
+	btrfsPathNameMax     = 4087
+)
+
+type btrfsVolArgs struct {
+	fd   int64
+	name [btrfsPathNameMax + 1]byte
+}
+
+/*
+	btrfs driver keeps each image as a subvolume under `images`, and the root filesystem of each
+	container as a snapshot of its image under `containers`, which is bind mounted. Layers of an
+	image are snapshots of the layers below them. The home of driver must be on btrfs.
+*/
+type btrfsDriver struct {
+	home string
+}
+
+func init() {
+	Register("btrfs", func() (Driver, error) {
+		home := driverHome("btrfs")
+		if !supportsFilesystem("btrfs") {
+			return nil, fmt.Errorf("btrfs is not supported by kernel")
+		}
+		if !onBtrfs(home) {
+			return nil, fmt.Errorf("%s is not on btrfs", home)
+		}
+		return &btrfsDriver{home: home}, nil
+	})
+}
+
+/* whether path, or its nearest existing parent, is on btrfs. */
+func onBtrfs(path string) bool {
+	for {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(path, &stat); err == nil {
+			return uint32(stat.Type) == btrfsSuperMagic
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+func (d *btrfsDriver) String() string {
+	return "btrfs"
+}
+
+func (d *btrfsDriver) imageSubvolume(imageDir string) string {
+	return filepath.Join(d.home, "images", filepath.Base(imageDir))
+}
+
+func (d *btrfsDriver) containerSubvolume(id string) string {
+	return filepath.Join(d.home, "containers", id)
+}
+
+/* the file recording image of container snapshot, kept outside of the snapshot. */
+func (d *btrfsDriver) parentFile(id string) string {
+	return filepath.Join(d.home, "parents", id)
+}
+
+/* call a volume ioctl on parent directory of subvolume, with fd of the source subvolume for snapshots. */
+func btrfsVolIoctl(request uintptr, subvolume string, srcFd uintptr) error {
+	parentDir := filepath.Dir(subvolume)
+	name := filepath.Base(subvolume)
+	if len(name) > btrfsPathNameMax {
+		return fmt.Errorf("subvolume name %s is too long", name)
+	}
+	parent, err := os.Open(parentDir)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	var args btrfsVolArgs
+	args.fd = int64(srcFd)
+	copy(args.name[:], name)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, parent.Fd(), request, uintptr(unsafe.Pointer(&args))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func createSubvolume(subvolume string) error {
+	if err := btrfsVolIoctl(btrfsIocSubvolCreate, subvolume, 0); err != nil {
+		return fmt.Errorf("create subvolume %s error : %v", subvolume, err)
+	}
+	return nil
+}
+
+func snapshotSubvolume(src string, dst string) error {
+	srcDir, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcDir.Close()
+	if err := btrfsVolIoctl(btrfsIocSnapCreate, dst, srcDir.Fd()); err != nil {
+		return fmt.Errorf("snapshot subvolume %s to %s error : %v", src, dst, err)
+	}
+	return nil
+}
+
+func deleteSubvolume(subvolume string) error {
+	if _, err := os.Lstat(subvolume); os.IsNotExist(err) {
+		return nil
+	}
+	if err := btrfsVolIoctl(btrfsIocSnapDestroy, subvolume, 0); err != nil {
+		return fmt.Errorf("delete subvolume %s error : %v", subvolume, err)
+	}
+	return nil
+}
+
+/*
+	make image a subvolume on first use by copying its directory. The copy is made under a
+	temporary name and renamed, so that a partial image is never snapshotted.
+*/
+func (d *btrfsDriver) ensureImage(imageDir string) error {
+	return d.makeImageSubvolume(imageDir, func(tmpSubvolume string) error {
+		if err := createSubvolume(tmpSubvolume); err != nil {
+			return err
+		}
+		if err := copyTree(imageDir, tmpSubvolume); err != nil {
+			return fmt.Errorf("copy image %s error : %v", imageDir, err)
+		}
+		return nil
+	})
+}
+
+/* make the subvolume of imageDir by fill under a temporary name unless it exists. */
+func (d *btrfsDriver) makeImageSubvolume(imageDir string, fill func(tmpSubvolume string) error) error {
+	subvolume := d.imageSubvolume(imageDir)
+	if _, err := os.Stat(subvolume); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(subvolume), 0700); err != nil {
+		return err
+	}
+	tmpSubvolume := filepath.Join(filepath.Dir(subvolume), "."+filepath.Base(subvolume))
+	if err := deleteSubvolume(tmpSubvolume); err != nil {
+		return err
+	}
+	if err := fill(tmpSubvolume); err != nil {
+		deleteSubvolume(tmpSubvolume)
+		return err
+	}
+	return os.Rename(tmpSubvolume, subvolume)
+}
+
+/*
+	only the bottom layer is copied, each layer above is a snapshot of the layer below with just
+	the files changed by it applied, so that files are shared among layers as in image store.
+*/
+func (d *btrfsDriver) PrepareLayers(layerDirs []string) error {
+	for i, layerDir := range layerDirs {
+		if i == 0 {
+			if err := d.ensureImage(layerDir); err != nil {
+				return err
+			}
+			continue
+		}
+		parentDir := layerDirs[i-1]
+		err := d.makeImageSubvolume(layerDir, func(tmpSubvolume string) error {
+			if err := snapshotSubvolume(d.imageSubvolume(parentDir), tmpSubvolume); err != nil {
+				return err
+			}
+			changes, err := treeChanges(layerDir, parentDir)
+			if err != nil {
+				return fmt.Errorf("find changes of layer %s error : %v", layerDir, err)
+			}
+			if err := applyChanges(layerDir, tmpSubvolume, changes); err != nil {
+				return fmt.Errorf("apply layer %s error : %v", layerDir, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* snapshots of layer, e.g. layers above it and containers, are independent of it. */
+func (d *btrfsDriver) RemoveLayer(layerDir string) error {
+	return deleteSubvolume(d.imageSubvolume(layerDir))
+}
+
+func (d *btrfsDriver) Create(id string, imageDir string) error {
+	if err := d.ensureImage(imageDir); err != nil {
+		return err
+	}
+	subvolume := d.containerSubvolume(id)
+	if err := deleteSubvolume(subvolume); err != nil {
+		return err
+	}
+	for _, dir := range []string{filepath.Dir(subvolume), filepath.Dir(d.parentFile(id))} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(d.parentFile(id), []byte(imageDir), 0600); err != nil {
+		return fmt.Errorf("record image of container %s error : %v", id, err)
+	}
+	return snapshotSubvolume(d.imageSubvolume(imageDir), subvolume)
+}
+
+func (d *btrfsDriver) Mount(id string, imageDir string, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0777); err != nil {
+		return fmt.Errorf("create mount point %s error : %v", mountPoint, err)
+	}
+	subvolume := d.containerSubvolume(id)
+	if err := syscall.Mount(subvolume, mountPoint, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s to %s error : %v", subvolume, mountPoint, err)
+	}
+	return nil
+}
+
+func (d *btrfsDriver) Unmount(mountPoint string) error {
+	if err := syscall.Unmount(mountPoint, 0); err != nil {
+		return fmt.Errorf("unmount %s error : %v", mountPoint, err)
+	}
+	return nil
+}
+
+func (d *btrfsDriver) Remove(id string) error {
+	if err := deleteSubvolume(d.containerSubvolume(id)); err != nil {
+		return err
+	}
+	return os.RemoveAll(d.parentFile(id))
+}
+
+/* a snapshot keeps metadata of image files, changes are found by comparing it with image subvolume. */
+func (d *btrfsDriver) Diff(id string, imageDir string) ([]Change, error) {
+	return treeChanges(d.containerSubvolume(id), d.imageSubvolume(imageDir))
+}
+
+/* extents of snapshot are shared with its image until changed, only added or modified files are counted. */
+func (d *btrfsDriver) Size(id string) (int64, error) {
+	image, err := ioutil.ReadFile(d.parentFile(id))
+	if err != nil {
+		return 0, fmt.Errorf("read image of container %s error : %v", id, err)
+	}
+	changes, err := d.Diff(id, string(image))
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, change := range changes {
+		if change.Kind == ChangeDelete {
+			continue
+		}
+		if info, err := os.Lstat(filepath.Join(d.containerSubvolume(id), change.Path)); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
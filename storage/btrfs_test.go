@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+/* a btrfs filesystem on a loop device, the test is skipped where it can not be made. */
+func newLoopbackBtrfs(t *testing.T) (string, func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("loop mount requires root")
+	}
+	if !supportsFilesystem("btrfs") {
+		t.Skip("btrfs is not supported by kernel")
+	}
+	mkfs, err := exec.LookPath("mkfs.btrfs")
+	if err != nil {
+		t.Skip("mkfs.btrfs is not found")
+	}
+	tmpDir, err := ioutil.TempDir("", "btrfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageFile := filepath.Join(tmpDir, "btrfs.img")
+	mountPoint := filepath.Join(tmpDir, "mnt")
+	if err := os.Mkdir(mountPoint, 0700); err != nil {
+		t.Fatal(err)
+	}
+	/* a sparse image, btrfs refuses devices smaller than about 110MB. */
+	if err := ioutil.WriteFile(imageFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(imageFile, 256<<20); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command(mkfs, "-q", imageFile).CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("mkfs.btrfs error : %v, %s", err, output)
+	}
+	if output, err := exec.Command("mount", "-o", "loop", imageFile, mountPoint).CombinedOutput(); err != nil {
+		os.RemoveAll(tmpDir)
+		t.Skipf("loop mount is not available : %v, %s", err, output)
+	}
+	return mountPoint, func() {
+		syscall.Unmount(mountPoint, syscall.MNT_DETACH)
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestBtrfsSnapshot(t *testing.T) {
+	home, cleanup := newLoopbackBtrfs(t)
+	defer cleanup()
+	if !onBtrfs(filepath.Join(home, "not-created-yet")) {
+		t.Fatalf("%s is not detected as btrfs", home)
+	}
+	driver := &btrfsDriver{home: home}
+	imageDir := filepath.Join(home, "..", "busybox")
+	createTestImage(t, imageDir)
+
+	if err := driver.Create("c1", imageDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Create("c2", imageDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		changes, err := driver.Diff(id, imageDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("fresh snapshot %s has changes %v", id, changes)
+		}
+	}
+	layerDir := driver.containerSubvolume("c1")
+	if err := ioutil.WriteFile(filepath.Join(layerDir, "added"), []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(layerDir, "fifo")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := driver.Diff("c1", imageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/added", Kind: ChangeAdd},
+		{Path: "/fifo", Kind: ChangeDelete},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes are %v, expect %v", changes, expected)
+	}
+	if size, err := driver.Size("c1"); err != nil || size != 5 {
+		t.Errorf("size of c1 is %d, expect 5 : %v", size, err)
+	}
+	/* snapshots are independent of each other. */
+	if _, err := os.Lstat(filepath.Join(driver.containerSubvolume("c2"), "fifo")); err != nil {
+		t.Errorf("fifo removed from c1 is missing in c2 : %v", err)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		if err := driver.Remove(id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(driver.containerSubvolume(id)); !os.IsNotExist(err) {
+			t.Errorf("subvolume of %s still exists : %v", id, err)
+		}
+	}
+}
+
+/* a layer on top of image at parentDir, changing, adding, replacing and deleting files. */
+func createTestLayer(t *testing.T, parentDir string, layerDir string) {
+	if err := copyTree(parentDir, layerDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(layerDir, "etc", "passwd"), []byte("root:x:0:0\nnobody:x:99:99\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(layerDir, "usr", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../busybox", filepath.Join(layerDir, "usr", "bin", "env")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(layerDir, "ls")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(layerDir, "ls"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(layerDir, "fifo")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	parentDir, layerDir, dst := filepath.Join(tmpDir, "parent"), filepath.Join(tmpDir, "layer"), filepath.Join(tmpDir, "dst")
+	createTestImage(t, parentDir)
+	createTestLayer(t, parentDir, layerDir)
+	if err := copyTree(parentDir, dst); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := treeChanges(layerDir, parentDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyChanges(layerDir, dst, changes); err != nil {
+		t.Fatal(err)
+	}
+	if remaining, err := treeChanges(dst, layerDir); err != nil || len(remaining) != 0 {
+		t.Errorf("tree differs from layer by %v : %v", remaining, err)
+	}
+}
+
+/* a layer is a snapshot of its parent, and is removed independently of it. */
+func TestBtrfsLayers(t *testing.T) {
+	home, cleanup := newLoopbackBtrfs(t)
+	defer cleanup()
+	driver := &btrfsDriver{home: home}
+	parentDir, layerDir := filepath.Join(home, "..", "parent"), filepath.Join(home, "..", "layer")
+	createTestImage(t, parentDir)
+	createTestLayer(t, parentDir, layerDir)
+	if err := driver.PrepareLayers([]string{parentDir, layerDir}); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := treeChanges(driver.imageSubvolume(layerDir), layerDir); err != nil || len(changes) != 0 {
+		t.Errorf("layer subvolume differs from layer by %v : %v", changes, err)
+	}
+	if err := driver.Create("c1", layerDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.RemoveLayer(layerDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(driver.imageSubvolume(layerDir)); !os.IsNotExist(err) {
+		t.Errorf("subvolume of removed layer still exists : %v", err)
+	}
+	for _, subvolume := range []string{driver.imageSubvolume(parentDir), driver.containerSubvolume("c1")} {
+		if _, err := os.Lstat(filepath.Join(subvolume, "busybox")); err != nil {
+			t.Errorf("%s is changed by removing layer above : %v", subvolume, err)
+		}
+	}
+	if err := driver.Remove("c1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+type ChangeKind int
+
+const (
+	ChangeModify ChangeKind = iota
+	ChangeAdd
+	ChangeDelete
+)
+
+/* a file changed in root filesystem of container. */
+type Change struct {
+	Path string     `json:"path"` /* the absolute path in container */
+	Kind ChangeKind `json:"kind"` /* modified, added or deleted */
+}
+
+/* in form of `docker diff`, e.g. `A /tmp/file`. */
+func (c Change) String() string {
+	return string("CAD"[c.Kind]) + " " + c.Path
+}
+
+/*
+	tell whether a file of upper layer is a whiteout hiding a file of image, and the name of the
+	hidden file. A whiteout naming nothing is metadata of union filesystem and skipped.
+*/
+type whiteoutFunc func(path string, info os.FileInfo) (hidden string, isWhiteout bool)
+
+/* tell whether a directory of upper layer is opaque, hiding all entries of image in it. */
+type opaqueFunc func(dir string) bool
+
+/*
+	changes in upper layer of a union filesystem relative to the lower image layer. Entries of image
+	hidden by an opaque directory are reported deleted.
+*/
+func upperChanges(upperDir string, imageDir string, whiteout whiteoutFunc, opaque opaqueFunc) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(upperDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == upperDir {
+			return nil
+		}
+		rel, err := filepath.Rel(upperDir, path)
+		if err != nil {
+			return err
+		}
+		if hidden, isWhiteout := whiteout(path, info); isWhiteout {
+			if hidden != "" {
+				changes = append(changes, Change{Path: "/" + filepath.Join(filepath.Dir(rel), hidden), Kind: ChangeDelete})
+			}
+			return nil
+		}
+		kind := ChangeAdd
+		imageInfo, err := os.Lstat(filepath.Join(imageDir, rel))
+		if err == nil {
+			kind = ChangeModify
+		}
+		changes = append(changes, Change{Path: "/" + rel, Kind: kind})
+		if kind == ChangeModify && imageInfo.IsDir() && info.IsDir() && opaque(path) {
+			hidden, err := hiddenEntries(path, filepath.Join(imageDir, rel))
+			if err != nil {
+				return err
+			}
+			for _, name := range hidden {
+				changes = append(changes, Change{Path: "/" + filepath.Join(rel, name), Kind: ChangeDelete})
+			}
+		}
+		return nil
+	})
+	sortChanges(changes)
+	return changes, err
+}
+
+/* entries of image directory not in the opaque upper directory. */
+func hiddenEntries(upperDir string, imageDir string) ([]string, error) {
+	dir, err := os.Open(imageDir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+	var hidden []string
+	for _, name := range names {
+		if _, err := os.Lstat(filepath.Join(upperDir, name)); os.IsNotExist(err) {
+			hidden = append(hidden, name)
+		}
+	}
+	return hidden, nil
+}
+
+/* changes of a full copy of image, by comparing metadata of files in both trees. */
+func treeChanges(layerDir string, imageDir string) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(layerDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layerDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		imageInfo, err := os.Lstat(filepath.Join(imageDir, rel))
+		if err != nil {
+			changes = append(changes, Change{Path: "/" + rel, Kind: ChangeAdd})
+			return nil
+		}
+		if fileChanged(path, info, filepath.Join(imageDir, rel), imageInfo) {
+			changes = append(changes, Change{Path: "/" + rel, Kind: ChangeModify})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(imageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(imageDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(layerDir, rel)); os.IsNotExist(err) {
+			changes = append(changes, Change{Path: "/" + rel, Kind: ChangeDelete})
+			/* children of a deleted directory are not reported. */
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	sortChanges(changes)
+	return changes, err
+}
+
+/* a file is changed if its type, permissions, ownership, size, mtime, device or link target differs. */
+func fileChanged(path string, info os.FileInfo, oldPath string, oldInfo os.FileInfo) bool {
+	stat, ok1 := info.Sys().(*syscall.Stat_t)
+	oldStat, ok2 := oldInfo.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 {
+		return true
+	}
+	if stat.Mode != oldStat.Mode || stat.Uid != oldStat.Uid || stat.Gid != oldStat.Gid ||
+		stat.Rdev != oldStat.Rdev || !info.ModTime().Equal(oldInfo.ModTime()) {
+		return true
+	}
+	/* size of directory depends on filesystem, its mtime tells whether entries changed. */
+	if !info.IsDir() && stat.Size != oldStat.Size {
+		return true
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, _ := os.Readlink(path)
+		oldLink, _ := os.Readlink(oldPath)
+		return link != oldLink
+	}
+	return false
+}
+
+func sortChanges(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		/* compare by components, so that a directory comes right before its children. */
+		return strings.Replace(changes[i].Path, "/", "\x00", -1) < strings.Replace(changes[j].Path, "/", "\x00", -1)
+	})
+}
+
+func inodeOf(info os.FileInfo) (uint64, uint64) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Ino, uint64(stat.Nlink)
+	}
+	return 0, 0
+}
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+/*
+	copy directory tree preserving ownership, permissions, extended attributes, timestamps,
+	hardlinks, symlinks and device nodes, as needed for a root filesystem.
+*/
+func copyTree(src string, dst string) error {
+	/* the first copy of each hardlinked inode, later links point to it. */
+	links := map[uint64]string{}
+	var dirs []string
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		/* times of directory change while its children are copied, set them at last. */
+		if info.IsDir() {
+			dirs = append(dirs, rel)
+		}
+		return copyEntry(path, filepath.Join(dst, rel), info, links)
+	})
+	if err != nil {
+		return err
+	}
+	return copyDirTimes(src, dst, dirs)
+}
+
+/*
+	make dst, a copy of the tree changes are found against, the same as src by applying changes,
+	e.g. those of treeChanges(src, parent) onto a snapshot of parent.
+*/
+func applyChanges(src string, dst string, changes []Change) error {
+	links := map[uint64]string{}
+	/* directories whose entries or metadata change, their times are set at last. */
+	dirSet := map[string]bool{}
+	for _, change := range changes {
+		dirSet[filepath.Dir(change.Path)] = true
+		target := filepath.Join(dst, change.Path)
+		if change.Kind == ChangeDelete {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			continue
+		}
+		path := filepath.Join(src, change.Path)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		/* an existing directory is kept with its entries, anything else is replaced. */
+		if dstInfo, err := os.Lstat(target); err == nil && !(info.IsDir() && dstInfo.IsDir()) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		if info.IsDir() {
+			dirSet[change.Path] = true
+		}
+		if err := copyEntry(path, target, info, links); err != nil {
+			return err
+		}
+	}
+	var dirs []string
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+	/* a directory sorts before its children, so that they are set first. */
+	sort.Strings(dirs)
+	return copyDirTimes(src, dst, dirs)
+}
+
+/*
+	copy a single file, symlink, device node or directory without its entries from path to target.
+	A file of several hardlinks is linked to its first copy recorded in links by inode.
+*/
+func copyEntry(path string, target string, info os.FileInfo, links map[uint64]string) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("stat %s is not supported", path)
+	}
+	if !info.IsDir() && stat.Nlink > 1 {
+		if first, ok := links[stat.Ino]; ok {
+			return os.Link(first, target)
+		}
+		links[stat.Ino] = target
+	}
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case mode.IsRegular():
+		if err := copyFile(path, target); err != nil {
+			return err
+		}
+	case mode&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link, target); err != nil {
+			return err
+		}
+	case mode&(os.ModeDevice|os.ModeNamedPipe|os.ModeSocket) != 0:
+		if err := unix.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+			return fmt.Errorf("mknod %s error : %v", target, err)
+		}
+	default:
+		return fmt.Errorf("unknown file type of %s", path)
+	}
+	return copyMetadata(path, target, info, stat)
+}
+
+/* set times of directories, given relative to src and dst, deepest first. */
+func copyDirTimes(src string, dst string, dirs []string) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := copyTimes(filepath.Join(dst, dirs[i]), filepath.Join(src, dirs[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copy %s error : %v", src, err)
+	}
+	return out.Close()
+}
+
+/* ownership goes first, as chown clears setuid and setgid bits. */
+func copyMetadata(src string, dst string, info os.FileInfo, stat *syscall.Stat_t) error {
+	if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+		return fmt.Errorf("chown %s error : %v", dst, err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if err := unix.Fchmodat(unix.AT_FDCWD, dst, stat.Mode&07777, 0); err != nil {
+			return fmt.Errorf("chmod %s error : %v", dst, err)
+		}
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	return copyTimes(dst, src)
+}
+
+func copyXattrs(src string, dst string) error {
+	xattrs, err := readXattrs(src)
+	if err != nil {
+		return err
+	}
+	for name, value := range xattrs {
+		if err := unix.Lsetxattr(dst, name, []byte(value), 0); err != nil {
+			return fmt.Errorf("set xattr %s of %s error : %v", name, dst, err)
+		}
+	}
+	return nil
+}
+
+/* extended attributes of file by name, symlinks are not followed. */
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size == 0 {
+		/* filesystems without xattr support have nothing to copy. */
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, fmt.Errorf("list xattrs of %s error : %v", path, err)
+	}
+	xattrs := map[string]string{}
+	for _, name := range splitNull(buf[:size]) {
+		value, err := getXattr(path, name)
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+func getXattr(path string, name string) (string, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		return "", fmt.Errorf("get xattr %s of %s error : %v", name, path, err)
+	}
+	value := make([]byte, size)
+	if size, err = unix.Lgetxattr(path, name, value); err != nil {
+		return "", fmt.Errorf("get xattr %s of %s error : %v", name, path, err)
+	}
+	return string(value[:size]), nil
+}
+
+func splitNull(buf []byte) []string {
+	var names []string
+	start := 0
+	for i, b := range buf {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names
+}
+
+func copyTimes(dst string, src string) error {
+	var stat unix.Stat_t
+	if err := unix.Lstat(src, &stat); err != nil {
+		return err
+	}
+	times := []unix.Timespec{stat.Atim, stat.Mtim}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("set times of %s error : %v", dst, err)
+	}
+	return nil
+}
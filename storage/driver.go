@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+	a storage driver keeps the writable layer of container on top of the read only directory of
+	its image, and provides root filesystem of container by mounting both.
+*/
+type Driver interface {
+	/* the name of driver, e.g. overlay. */
+	String() string
+	/* create an empty writable layer of container on top of image. */
+	Create(id string, imageDir string) error
+	/* mount root filesystem of container at mountPoint. */
+	Mount(id string, imageDir string, mountPoint string) error
+	Unmount(mountPoint string) error
+	/* remove writable layer of container, which must be unmounted. */
+	Remove(id string) error
+	/* changes made by container to the files of image, sorted by path. */
+	Diff(id string, imageDir string) ([]Change, error)
+	/* the size in bytes of files in writable layer. */
+	Size(id string) (int64, error)
+}
+
+/*
+	a driver keeping layers of images itself, e.g. as btrfs subvolumes. Layers are given by their
+	root filesystems in image store, which are removed from driver along with them.
+*/
+type LayerDriver interface {
+	Driver
+	/* make layers given from the bottom one, layers made before are kept. */
+	PrepareLayers(layerDirs []string) error
+	RemoveLayer(layerDir string) error
+}
+
+/* the initializer of driver fails if driver is not supported by host. */
+type InitFunc func() (Driver, error)
+
+const (
+	/* containers created before storage drivers were introduced use aufs. */
+	DefaultDriver = "aufs"
+	StorageRoot   = "/root/storage"
+)
+
+var (
+	drivers = map[string]InitFunc{}
+	/* drivers tried in order when no driver is given, btrfs is only supported on a btrfs home. */
+	priority = []string{"btrfs", "overlay", "aufs", "vfs"}
+)
+
+func Register(name string, initFunc InitFunc) {
+	drivers[name] = initFunc
+}
+
+/* get driver by name, or the first supported one in priority order for an empty name. */
+func New(name string) (Driver, error) {
+	if name != "" {
+		initFunc, ok := drivers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown storage driver %s, must be one of %s", name, strings.Join(Names(), ", "))
+		}
+		return initFunc()
+	}
+	for _, name := range priority {
+		if driver, err := drivers[name](); err == nil {
+			return driver, nil
+		}
+	}
+	return nil, fmt.Errorf("no supported storage driver found, tried %s", strings.Join(priority, ", "))
+}
+
+/* remove a layer of image store from every supported driver keeping layers. */
+func RemoveLayer(layerDir string) error {
+	for _, name := range Names() {
+		driver, err := drivers[name]()
+		if err != nil {
+			continue
+		}
+		if layerDriver, ok := driver.(LayerDriver); ok {
+			if err := layerDriver.RemoveLayer(layerDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* names of registered drivers. */
+func Names() []string {
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* the directory of driver holding layers of containers. */
+func driverHome(name string) string {
+	return filepath.Join(StorageRoot, name)
+}
+
+/* whether filesystem is supported by kernel, as listed in /proc/filesystems. */
+func supportsFilesystem(fsType string) bool {
+	content, err := ioutil.ReadFile("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[len(fields)-1] == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+/* total size of regular files under directory, hardlinked files are counted once. */
+func dirSize(dir string) (int64, error) {
+	var size int64
+	seen := map[uint64]bool{}
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if ino, nlink := inodeOf(info); nlink > 1 {
+			if seen[ino] {
+				return nil
+			}
+			seen[ino] = true
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
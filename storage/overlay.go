@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+/* the extended attribute marking an opaque directory of upper layer. */
+const overlayOpaqueXattr = "trusted.overlay.opaque"
+
+/*
+	overlay driver keeps `upper` and `work` directories per container under its home, and mounts
+	the image directory as lower layer.
+*/
+type overlayDriver struct {
+	home string
+}
+
+func init() {
+	Register("overlay", func() (Driver, error) {
+		if !supportsFilesystem("overlay") {
+			return nil, fmt.Errorf("overlay is not supported by kernel")
+		}
+		return &overlayDriver{home: driverHome("overlay")}, nil
+	})
+}
+
+func (d *overlayDriver) String() string {
+	return "overlay"
+}
+
+func (d *overlayDriver) upperDir(id string) string {
+	return filepath.Join(d.home, id, "upper")
+}
+
+func (d *overlayDriver) workDir(id string) string {
+	return filepath.Join(d.home, id, "work")
+}
+
+/* upper and work directories must be on the same filesystem, both are under it. */
+func (d *overlayDriver) layerRoot(id string) string {
+	return filepath.Join(d.home, id)
+}
+
+func (d *overlayDriver) Create(id string, imageDir string) error {
+	if err := clearDir(d.layerRoot(id)); err != nil {
+		return fmt.Errorf("remove existing layer of %s error : %v", id, err)
+	}
+	for _, dir := range []string{d.upperDir(id), d.workDir(id)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("create directory %s error : %v", dir, err)
+		}
+	}
+	return nil
+}
+
+func (d *overlayDriver) Mount(id string, imageDir string, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0777); err != nil {
+		return fmt.Errorf("create mount point %s error : %v", mountPoint, err)
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", imageDir, d.upperDir(id), d.workDir(id))
+	if err := syscall.Mount("overlay", mountPoint, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mount overlay to %s error : %v", mountPoint, err)
+	}
+	return nil
+}
+
+func (d *overlayDriver) Unmount(mountPoint string) error {
+	if err := syscall.Unmount(mountPoint, 0); err != nil {
+		return fmt.Errorf("unmount %s error : %v", mountPoint, err)
+	}
+	return nil
+}
+
+func (d *overlayDriver) Remove(id string) error {
+	return os.RemoveAll(d.layerRoot(id))
+}
+
+/* overlay hides a file of image by a character device numbered 0/0 in its place. */
+func (d *overlayDriver) Diff(id string, imageDir string) ([]Change, error) {
+	return upperChanges(d.upperDir(id), imageDir, func(path string, info os.FileInfo) (string, bool) {
+		if info.Mode()&os.ModeCharDevice == 0 {
+			return "", false
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Rdev == 0 {
+			return info.Name(), true
+		}
+		return "", false
+	}, func(dir string) bool {
+		value, err := getXattr(dir, overlayOpaqueXattr)
+		return err == nil && value == "y"
+	})
+}
+
+func (d *overlayDriver) Size(id string) (int64, error) {
+	return dirSize(d.upperDir(id))
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	/* the only option of writable layer, e.g. `--storage-opt size=10G`. */
+	StorageOptSize = "size"
+	/* the smallest size of layer, below which ext4 has little room left for files. */
+	minQuotaSize = 16 << 20
+	/* made by mkfs on the filesystem backing layer, it is not a file of container. */
+	quotaLostFound = "lost+found"
+)
+
+/*
+	drivers keeping writable layer of container in a directory of host, which can be backed by a
+	size limited filesystem. The directory must hold everything written by container.
+*/
+type layerRooter interface {
+	layerRoot(id string) string
+}
+
+/* usage of the filesystem backing writable layer against its size, in bytes. */
+type QuotaUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+/* parse options of writable layer in form of key=value, only `size` is supported. */
+func ParseStorageOpts(optSlice []string) (map[string]string, error) {
+	opts := map[string]string{}
+	for _, opt := range optSlice {
+		pair := strings.SplitN(opt, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			return nil, fmt.Errorf("invalid storage option %s, must be in form of key=value", opt)
+		}
+		key := strings.ToLower(strings.TrimSpace(pair[0]))
+		switch key {
+		case StorageOptSize:
+			size, err := ParseSize(pair[1])
+			if err != nil {
+				return nil, err
+			}
+			if size < minQuotaSize {
+				return nil, fmt.Errorf("storage size %s is less than the minimum 16M", pair[1])
+			}
+		default:
+			return nil, fmt.Errorf("unknown storage option %s", key)
+		}
+		opts[key] = pair[1]
+	}
+	return opts, nil
+}
+
+/* parse size in bytes with an optional binary unit of k, m, g or t, e.g. `10G` or `512m`. */
+func ParseSize(sizeStr string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(sizeStr))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "b"), "i")
+	multiplier := int64(1)
+	if str != "" {
+		if index := strings.IndexByte("kmgt", str[len(str)-1]); index >= 0 {
+			multiplier = 1 << (10 * uint(index+1))
+			str = str[:len(str)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size %s", sizeStr)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+/* the sparse image of filesystem backing writable layer of container. */
+func quotaImage(id string) string {
+	return filepath.Join(StorageRoot, "quota", id+".img")
+}
+
+/*
+	back writable layer of container with a sparse ext4 image of size bytes, loop mounted at the
+	layer directory, so that container fails with ENOSPC instead of filling host. It is called
+	before the layer is created. As vfs copies the whole image into its layer, the copy counts
+	against size too, which must then exceed the size of image.
+*/
+func CreateQuota(driver Driver, id string, size int64) error {
+	rooter, ok := driver.(layerRooter)
+	if !ok {
+		return fmt.Errorf("storage size is not supported by %s driver", driver)
+	}
+	if err := RemoveQuota(driver, id); err != nil {
+		return err
+	}
+	image := quotaImage(id)
+	if err := os.MkdirAll(filepath.Dir(image), 0700); err != nil {
+		return fmt.Errorf("create directory %s error : %v", filepath.Dir(image), err)
+	}
+	file, err := os.OpenFile(image, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("create image %s error : %v", image, err)
+	}
+	err = file.Truncate(size)
+	file.Close()
+	if err != nil {
+		os.Remove(image)
+		return fmt.Errorf("resize image %s error : %v", image, err)
+	}
+	/* no blocks are reserved for root, container may use all of them. */
+	if output, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput(); err != nil {
+		os.Remove(image)
+		return fmt.Errorf("make filesystem on %s error : %v, %s", image, err, strings.TrimSpace(string(output)))
+	}
+	root := rooter.layerRoot(id)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		os.Remove(image)
+		return fmt.Errorf("create directory %s error : %v", root, err)
+	}
+	if output, err := exec.Command("mount", "-o", "loop", image, root).CombinedOutput(); err != nil {
+		os.Remove(image)
+		return fmt.Errorf("mount %s to %s error : %v, %s", image, root, err, strings.TrimSpace(string(output)))
+	}
+	/* the root of aufs and vfs is the layer itself, where lost+found would show in container. */
+	if err := os.Remove(filepath.Join(root, quotaLostFound)); err != nil && !os.IsNotExist(err) {
+		RemoveQuota(driver, id)
+		return fmt.Errorf("remove %s of %s error : %v", quotaLostFound, root, err)
+	}
+	return nil
+}
+
+/*
+	leave out lost+found, which fsck may make again on the filesystem backing layer of container,
+	from changes of a layer rooted at that filesystem.
+*/
+func withoutLostFound(id string, changes []Change) []Change {
+	if _, err := os.Stat(quotaImage(id)); err != nil {
+		return changes
+	}
+	lostFound := "/" + quotaLostFound
+	var filtered []Change
+	for _, change := range changes {
+		if change.Path == lostFound || strings.HasPrefix(change.Path, lostFound+"/") {
+			continue
+		}
+		filtered = append(filtered, change)
+	}
+	return filtered
+}
+
+/* unmount and remove the image backing writable layer of container, if any. Layer is lost. */
+func RemoveQuota(driver Driver, id string) error {
+	rooter, ok := driver.(layerRooter)
+	if !ok {
+		return nil
+	}
+	image := quotaImage(id)
+	if _, err := os.Stat(image); os.IsNotExist(err) {
+		return nil
+	}
+	root := rooter.layerRoot(id)
+	if isMountPoint(root) {
+		/* the loop device is released on unmount, as mount sets it to autoclear. */
+		if err := syscall.Unmount(root, 0); err != nil {
+			return fmt.Errorf("unmount %s error : %v", root, err)
+		}
+	}
+	if err := os.Remove(image); err != nil {
+		return fmt.Errorf("remove image %s error : %v", image, err)
+	}
+	return nil
+}
+
+/* usage of the size limited filesystem of container, nil if its layer is not limited. */
+func GetQuotaUsage(driver Driver, id string) (*QuotaUsage, error) {
+	rooter, ok := driver.(layerRooter)
+	if !ok {
+		return nil, nil
+	}
+	if _, err := os.Stat(quotaImage(id)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	root := rooter.layerRoot(id)
+	if !isMountPoint(root) {
+		return nil, fmt.Errorf("image of layer %s is not mounted", root)
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(root, &stat); err != nil {
+		return nil, fmt.Errorf("statfs %s error : %v", root, err)
+	}
+	/* metadata of ext4 is excluded from blocks, the rest is what container can use. */
+	return &QuotaUsage{
+		Used:  int64(stat.Blocks-stat.Bfree) * int64(stat.Bsize),
+		Limit: int64(stat.Blocks) * int64(stat.Bsize),
+	}, nil
+}
+
+/* a directory is a mount point if it is on a different device than its parent. */
+func isMountPoint(dir string) bool {
+	var stat, parentStat syscall.Stat_t
+	if err := syscall.Lstat(dir, &stat); err != nil {
+		return false
+	}
+	if err := syscall.Lstat(filepath.Dir(dir), &parentStat); err != nil {
+		return false
+	}
+	return stat.Dev != parentStat.Dev
+}
+
+/* remove everything under directory but keep the directory, which may be a mount point. */
+func clearDir(dir string) error {
+	dirFile, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names, err := dirFile.Readdirnames(-1)
+	dirFile.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
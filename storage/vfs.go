@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+/*
+	vfs driver copies the whole image into a directory per container, which is bind mounted as root
+	filesystem. It is slow and takes space, but works on any filesystem. A size limit of layer
+	covers the copy of image as well.
+*/
+type vfsDriver struct {
+	home string
+}
+
+func init() {
+	Register("vfs", func() (Driver, error) {
+		return &vfsDriver{home: driverHome("vfs")}, nil
+	})
+}
+
+func (d *vfsDriver) String() string {
+	return "vfs"
+}
+
+func (d *vfsDriver) layerDir(id string) string {
+	return filepath.Join(d.home, id)
+}
+
+func (d *vfsDriver) layerRoot(id string) string {
+	return d.layerDir(id)
+}
+
+func (d *vfsDriver) Create(id string, imageDir string) error {
+	layerDir := d.layerDir(id)
+	if err := clearDir(layerDir); err != nil {
+		return fmt.Errorf("remove existing layer %s error : %v", layerDir, err)
+	}
+	if err := os.MkdirAll(d.home, 0700); err != nil {
+		return fmt.Errorf("create directory %s error : %v", d.home, err)
+	}
+	if err := copyTree(imageDir, layerDir); err != nil {
+		clearDir(layerDir)
+		return fmt.Errorf("copy image %s error : %v", imageDir, err)
+	}
+	return nil
+}
+
+func (d *vfsDriver) Mount(id string, imageDir string, mountPoint string) error {
+	if err := os.MkdirAll(mountPoint, 0777); err != nil {
+		return fmt.Errorf("create mount point %s error : %v", mountPoint, err)
+	}
+	if err := syscall.Mount(d.layerDir(id), mountPoint, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s to %s error : %v", d.layerDir(id), mountPoint, err)
+	}
+	return nil
+}
+
+func (d *vfsDriver) Unmount(mountPoint string) error {
+	if err := syscall.Unmount(mountPoint, 0); err != nil {
+		return fmt.Errorf("unmount %s error : %v", mountPoint, err)
+	}
+	return nil
+}
+
+func (d *vfsDriver) Remove(id string) error {
+	return os.RemoveAll(d.layerDir(id))
+}
+
+/* without an upper layer, changes are found by comparing the copy with image. */
+func (d *vfsDriver) Diff(id string, imageDir string) ([]Change, error) {
+	changes, err := treeChanges(d.layerDir(id), imageDir)
+	if err != nil {
+		return nil, err
+	}
+	return withoutLostFound(id, changes), nil
+}
+
+/* the whole copy of image is owned by container. */
+func (d *vfsDriver) Size(id string) (int64, error) {
+	return dirSize(d.layerDir(id))
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/* an image with a directory, hardlinked files, a symlink and a fifo. */
+func createTestImage(t *testing.T, imageDir string) {
+	if err := os.MkdirAll(filepath.Join(imageDir, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(imageDir, "etc", "passwd"), []byte("root:x:0:0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(imageDir, "busybox"), []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(imageDir, "busybox"), 0755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(imageDir, "busybox"), filepath.Join(imageDir, "sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("busybox", filepath.Join(imageDir, "ls")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(imageDir, "fifo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"etc/passwd", "busybox", "etc", "fifo", "."} {
+		if err := os.Chtimes(filepath.Join(imageDir, name), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTestVfsDriver(t *testing.T) (*vfsDriver, string, func()) {
+	tmpDir, err := ioutil.TempDir("", "vfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageDir := filepath.Join(tmpDir, "image")
+	createTestImage(t, imageDir)
+	return &vfsDriver{home: filepath.Join(tmpDir, "vfs")}, imageDir, func() { os.RemoveAll(tmpDir) }
+}
+
+func TestVfsCreate(t *testing.T) {
+	driver, imageDir, cleanup := newTestVfsDriver(t)
+	defer cleanup()
+	if err := driver.Create("c1", imageDir); err != nil {
+		t.Fatal(err)
+	}
+	layerDir := driver.layerDir("c1")
+	busybox, err := os.Stat(filepath.Join(layerDir, "busybox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if busybox.Mode()&os.ModeSetuid == 0 {
+		t.Errorf("setuid bit of busybox is lost, mode %v", busybox.Mode())
+	}
+	if sh, err := os.Stat(filepath.Join(layerDir, "sh")); err != nil || !os.SameFile(busybox, sh) {
+		t.Errorf("hardlink of busybox is not preserved : %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(layerDir, "ls")); err != nil || link != "busybox" {
+		t.Errorf("symlink ls points to %s : %v", link, err)
+	}
+	if fifo, err := os.Lstat(filepath.Join(layerDir, "fifo")); err != nil || fifo.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("fifo is not preserved : %v", err)
+	}
+	changes, err := driver.Diff("c1", imageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("fresh copy of image has changes %v", changes)
+	}
+	size, err := driver.Size("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	/* busybox and sh are counted once. */
+	if expected := int64(len("root:x:0:0\n") + len("binary")); size != expected {
+		t.Errorf("size of layer is %d, expect %d", size, expected)
+	}
+}
+
+func TestVfsDiff(t *testing.T) {
+	driver, imageDir, cleanup := newTestVfsDriver(t)
+	defer cleanup()
+	if err := driver.Create("c1", imageDir); err != nil {
+		t.Fatal(err)
+	}
+	layerDir := driver.layerDir("c1")
+	if err := ioutil.WriteFile(filepath.Join(layerDir, "etc", "passwd"), []byte("root:x:0:0\nuser:x:1000:1000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(layerDir, "tmp", "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(layerDir, "fifo")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := driver.Diff("c1", imageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/etc/passwd", Kind: ChangeModify},
+		{Path: "/fifo", Kind: ChangeDelete},
+		{Path: "/tmp", Kind: ChangeAdd},
+		{Path: "/tmp/a", Kind: ChangeAdd},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes are %v, expect %v", changes, expected)
+	}
+}
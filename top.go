@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/cgroups"
+	"github.com/qqzeng/tinydocker/container"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	DefaultTopColumns = "pid,cpid,user,time,args"
+	/* USER_HZ, the unit of cpu time in /proc/<pid>/stat. */
+	clockTicks = 100
+)
+
+type processInfo struct {
+	pid  string
+	cpid string
+	ppid string
+	uid  string
+	user string
+	stat string
+	time string
+	comm string
+	args string
+}
+
+/* header and value getter for each column of `top -o` */
+var topColumns = map[string]struct {
+	header string
+	value  func(p *processInfo) string
+}{
+	"pid":  {"PID", func(p *processInfo) string { return p.pid }},
+	"cpid": {"CPID", func(p *processInfo) string { return p.cpid }},
+	"ppid": {"PPID", func(p *processInfo) string { return p.ppid }},
+	"uid":  {"UID", func(p *processInfo) string { return p.uid }},
+	"user": {"USER", func(p *processInfo) string { return p.user }},
+	"stat": {"STAT", func(p *processInfo) string { return p.stat }},
+	"time": {"TIME", func(p *processInfo) string { return p.time }},
+	"comm": {"COMMAND", func(p *processInfo) string { return p.comm }},
+	"args": {"COMMAND", func(p *processInfo) string { return p.args }},
+}
+
+/* aliases of column names, as ps(1) does. */
+var topColumnAliases = map[string]string{
+	"cmd":     "args",
+	"command": "args",
+	"ucomm":   "comm",
+	"euser":   "user",
+	"euid":    "uid",
+	"cputime": "time",
+	"state":   "stat",
+	"s":       "stat",
+}
+
+/* list processes in the cgroup of container with selected columns. */
+func TopContainer(containerName string, columnStr string) {
+	containerInfo, err := getContainerByName(containerName)
+	if err != nil {
+		log.Errorf("Get container name %s error : %v", containerName, err)
+		return
+	}
+	if containerInfo.Status != container.RUNNING {
+		log.Errorf("Container %s is not running", containerName)
+		return
+	}
+	var columns []string
+	for _, column := range strings.Split(columnStr, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if alias, ok := topColumnAliases[column]; ok {
+			column = alias
+		}
+		if _, ok := topColumns[column]; !ok {
+			log.Errorf("Unknown column %s, supported columns are pid, cpid, ppid, uid, user, stat, time, comm and args", column)
+			return
+		}
+		columns = append(columns, column)
+	}
+	pids, err := cgroups.GetProcesses(containerInfo.Pid)
+	if err != nil {
+		log.Errorf("List processes of container %s error : %v", containerName, err)
+		return
+	}
+	rootfs := fmt.Sprintf("/proc/%s/root", containerInfo.Pid)
+
+	wr := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	var headers []string
+	for _, column := range columns {
+		headers = append(headers, topColumns[column].header)
+	}
+	fmt.Fprintln(wr, strings.Join(headers, "\t"))
+	for _, pid := range pids {
+		p, err := readProcessInfo(strconv.Itoa(pid), rootfs)
+		if err != nil {
+			/* the process may have exited since we listed the cgroup. */
+			log.Debugf("Read process %d information error : %v", pid, err)
+			continue
+		}
+		var values []string
+		for _, column := range columns {
+			values = append(values, topColumns[column].value(p))
+		}
+		fmt.Fprintln(wr, strings.Join(values, "\t"))
+	}
+	if err := wr.Flush(); err != nil {
+		log.Errorf("Flush process information to stdout error : %v", err)
+	}
+}
+
+func readProcessInfo(pid string, rootfs string) (*processInfo, error) {
+	p := &processInfo{pid: pid}
+	statusFile, err := os.Open(fmt.Sprintf("/proc/%s/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer statusFile.Close()
+	scanner := bufio.NewScanner(statusFile)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Name:":
+			p.comm = fields[1]
+		case "State:":
+			p.stat = fields[1]
+		case "PPid:":
+			p.ppid = fields[1]
+		case "Uid:":
+			/* real, effective, saved and filesystem uid, show the effective one. */
+			p.uid = fields[1]
+			if len(fields) > 2 {
+				p.uid = fields[2]
+			}
+		case "NSpid:":
+			/* the innermost pid namespace comes last. */
+			p.cpid = fields[len(fields)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	p.user = container.LookupUserName(rootfs, p.uid)
+
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%s/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	/* comm may contain spaces and brackets, fields are counted from the last ')'. */
+	statFields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	if len(statFields) > 12 {
+		utime, _ := strconv.ParseUint(statFields[11], 10, 64)
+		stime, _ := strconv.ParseUint(statFields[12], 10, 64)
+		p.time = formatCpuTime((utime + stime) / clockTicks)
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%s/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+	p.args = strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	if p.args == "" {
+		p.args = "[" + p.comm + "]"
+	}
+	return p, nil
+}
+
+/* format seconds as [DD-]HH:MM:SS like ps(1). */
+func formatCpuTime(seconds uint64) string {
+	days := seconds / 86400
+	clock := fmt.Sprintf("%02d:%02d:%02d", seconds%86400/3600, seconds%3600/60, seconds%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, clock)
+	}
+	return clock
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/qqzeng/tinydocker/container"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+func CreateVolume(volumeName string, labels map[string]string) error {
+	volumeInfo, err := container.CreateVolume(volumeName, labels)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, volumeInfo.Name)
+	return nil
+}
+
+/* list volumes whose labels match every label filter. */
+func ListVolumes(quiet bool, labelFilters []string) {
+	volumes, err := container.ListVolumes()
+	if err != nil {
+		log.Errorf("Read volume information directory error : %v", err)
+		return
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	wr := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if !quiet {
+		fmt.Fprintln(wr, "NAME\tMOUNTPOINT\tCREATED\tLABELS")
+	}
+	for _, item := range volumes {
+		if !container.MatchLabels(item.Labels, labelFilters) {
+			continue
+		}
+		if quiet {
+			fmt.Fprintln(wr, item.Name)
+			continue
+		}
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\n", item.Name, item.Mountpoint, item.CreatedAt, formatLabels(item.Labels))
+	}
+	if err := wr.Flush(); err != nil {
+		log.Errorf("Flush volume information to stdout error : %v", err)
+	}
+}
+
+/* a volume used by any container, running or not, can not be removed. */
+func RemoveVolume(volumeName string) error {
+	volumeInfo, err := container.LoadVolume(volumeName)
+	if err != nil {
+		return fmt.Errorf("no such volume: %s", volumeName)
+	}
+	containers, err := getAllContainers()
+	if err != nil {
+		return err
+	}
+	for _, item := range containers {
+		for _, mount := range item.Mounts {
+			if mount.Source == volumeInfo.Mountpoint {
+				return fmt.Errorf("volume %s is in use by container %s", volumeName, item.Name)
+			}
+		}
+	}
+	return container.RemoveVolume(volumeName)
+}
+
+/* labels in form of sorted `key=value` joined by comma. */
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}